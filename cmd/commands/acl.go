@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/http_proxy"
+)
+
+// addACLFlags 注册访问控制相关的标志
+func addACLFlags(flags *pflag.FlagSet) {
+	flags.String("acl-file", "", "访问控制规则文件，按顺序匹配，第一条匹配的规则决定是否允许")
+	flags.Duration("acl-reload", time.Minute, "检查访问控制规则文件修改并重新加载的间隔，0表示不重新加载")
+}
+
+// newACL 根据命令行标志加载访问控制规则，未指定规则文件时返回 nil
+func newACL(flags *pflag.FlagSet) (*http_proxy.ACL, error) {
+	path, _ := flags.GetString("acl-file")
+	if path == "" {
+		return nil, nil
+	}
+	return http_proxy.NewACL(path)
+}
+
+var aclTestFlags = struct {
+	file   string
+	user   string
+	groups []string
+	method string
+	url    string
+	time   string
+}{}
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "访问控制规则工具",
+}
+
+var aclTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "检查用户访问指定地址时匹配的规则",
+	Example: `  zaproxy acl test --acl-file /etc/zaproxy/acl --user alice --url https://www.example.com
+  zaproxy acl test --acl-file /etc/zaproxy/acl --user bob --group dev --method GET --url http://example.com:8080/ --time "2026-10-19 20:00"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runACLTest(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	},
+}
+
+// runACLTest 检查请求并输出每条规则的匹配结果，拒绝时以状态码 1 退出
+func runACLTest() error {
+	if aclTestFlags.file == "" {
+		return fmt.Errorf("需要指定 --acl-file")
+	}
+	if aclTestFlags.url == "" {
+		return fmt.Errorf("需要指定 --url")
+	}
+	acl, err := http_proxy.NewACL(aclTestFlags.file)
+	if err != nil {
+		return err
+	}
+
+	// 不带协议的地址按 CONNECT 目标处理
+	target := aclTestFlags.url
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("无效的地址: %s", aclTestFlags.url)
+	}
+	method := strings.ToUpper(aclTestFlags.method)
+	if method == "" {
+		method = "GET"
+		if u.Scheme == "https" {
+			method = "CONNECT"
+		}
+	}
+
+	req := &http_proxy.ACLRequest{Method: method, Host: strings.ToLower(u.Hostname()), Port: 80, Time: time.Now()}
+	if u.Port() != "" {
+		if req.Port, err = strconv.Atoi(u.Port()); err != nil {
+			return fmt.Errorf("无效的地址: %s", aclTestFlags.url)
+		}
+	} else if u.Scheme == "https" {
+		req.Port = 443
+	}
+	if aclTestFlags.time != "" {
+		req.Time, err = time.ParseInLocation("2006-01-02 15:04", aclTestFlags.time, time.Local)
+		if err != nil {
+			return fmt.Errorf("无效的时间: %s", aclTestFlags.time)
+		}
+	}
+	if aclTestFlags.user != "" {
+		req.Identity = &http_proxy.Identity{Username: aclTestFlags.user, Groups: aclTestFlags.groups}
+	}
+
+	user := "(未认证)"
+	if req.Identity != nil {
+		user = req.Identity.Username
+	}
+	fmt.Printf("请求: %s %s:%d 用户 %s 时间 %s\n", req.Method, req.Host, req.Port, user, req.Time.Format("2006-01-02 15:04 Mon"))
+
+	decision, trace := acl.Explain(context.Background(), req)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range trace {
+		fmt.Fprintf(w, "  第 %d 行\t不匹配 (%s)\t%s\n", t.Rule.Line, t.Reason, t.Rule.Text)
+	}
+	if decision.Rule != nil {
+		fmt.Fprintf(w, "  第 %d 行\t匹配\t%s\n", decision.Rule.Line, decision.Rule.Text)
+	}
+	w.Flush()
+
+	result := "拒绝"
+	if decision.Allowed {
+		result = "允许"
+	}
+	if decision.Rule != nil {
+		fmt.Printf("结果: %s（第 %d 行）\n", result, decision.Rule.Line)
+	} else {
+		fmt.Printf("结果: %s（没有匹配的规则，使用默认策略）\n", result)
+	}
+	if !decision.Allowed {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(aclCmd)
+	aclCmd.AddCommand(aclTestCmd)
+	aclTestCmd.Flags().StringVar(&aclTestFlags.file, "acl-file", "", "访问控制规则文件")
+	aclTestCmd.Flags().StringVar(&aclTestFlags.user, "user", "", "用户名，为空时按未认证的请求检查")
+	aclTestCmd.Flags().StringSliceVar(&aclTestFlags.groups, "group", nil, "认证后端提供的用户组，如 LDAP 或 JWT 中的组")
+	aclTestCmd.Flags().StringVar(&aclTestFlags.method, "method", "", "请求方法，默认 https 地址为 CONNECT，其他为 GET")
+	aclTestCmd.Flags().StringVar(&aclTestFlags.url, "url", "", "目标地址，如 https://www.example.com 或 example.com:443")
+	aclTestCmd.Flags().StringVar(&aclTestFlags.time, "time", "", "检查时间，格式为 2006-01-02 15:04，默认为当前时间")
+}
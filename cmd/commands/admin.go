@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// addAdminFlags 注册管理接口相关的标志
+func addAdminFlags(flags *pflag.FlagSet) {
+	flags.String("admin-listen", "", "管理接口监听地址，如 127.0.0.1:12829，为空则不启用")
+	flags.String("admin-token", "", "管理接口的访问令牌（Authorization: Bearer <token>），未设置时管理接口只能监听回环地址")
+}
+
+// adminAPI 是管理接口，各功能模块通过 handle 注册自己的端点
+type adminAPI struct {
+	mux   *http.ServeMux
+	token string
+}
+
+func newAdminAPI(token string) *adminAPI {
+	return &adminAPI{mux: http.NewServeMux(), token: token}
+}
+
+// handle 注册管理接口端点
+func (a *adminAPI) handle(pattern string, handler http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, handler)
+}
+
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="zaproxy-admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// start 启动管理接口服务器，addr 为空时不启动，返回的函数用于关闭服务器
+// 未设置访问令牌时只允许监听回环地址，无法监听时返回错误
+func (a *adminAPI) start(addr string) (shutdown func(), err error) {
+	if addr == "" {
+		return func() {}, nil
+	}
+	if a.token == "" && !isLoopbackAddr(addr) {
+		return nil, fmt.Errorf("未设置 --admin-token 时管理接口只能监听回环地址: %s", addr)
+	}
+
+	// 同步监听，端口被占用等错误直接返回，使启动失败
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("管理接口监听失败: %w", err)
+	}
+
+	server := &http.Server{Addr: addr, Handler: a}
+	go func() {
+		log.Printf("admin server start : %s", ln.Addr())
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("admin server error: %v", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
+
+// isLoopbackAddr 判断监听地址是否为回环地址，未指定主机时监听所有地址，不是回环地址
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// writeJSON 以 JSON 格式写入响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("admin: write response error: %v", err)
+	}
+}
@@ -0,0 +1,373 @@
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/http_proxy"
+)
+
+// addAuthFlags 注册代理认证相关的标志
+func addAuthFlags(flags *pflag.FlagSet) {
+	flags.String("auth-realm", "restricted", "代理认证的认证域（Proxy-Authenticate 中的 realm）")
+	flags.StringSlice("auth-schemes", []string{"basic"}, "启用的代理认证方案：basic、digest、bearer，指定了令牌来源时默认同时启用 bearer")
+	flags.Int("auth-max-failures", 10, "连续认证失败达到该次数后封禁客户端IP或用户名，0表示不封禁")
+	flags.Duration("auth-backoff", time.Second, "认证失败后的等待时间，之后每次失败翻倍，0表示不退避")
+	flags.Duration("auth-max-backoff", time.Minute, "认证失败后等待时间的上限")
+	flags.Duration("auth-ban-duration", 15*time.Minute, "封禁时长")
+	flags.Duration("auth-failure-window", 15*time.Minute, "认证失败记录的保留时间，超过后重新计数")
+	flags.Int("auth-cache-size", http_proxy.DefaultIdentityCacheSize, "缓存的已认证身份数，0表示不缓存")
+	flags.Duration("auth-cache-ttl", http_proxy.DefaultIdentityCacheTTL, "已认证身份的缓存有效期")
+	flags.Duration("auth-reload", time.Minute, "检查认证文件修改并重新加载的间隔，0表示不重新加载")
+	flags.String("api-keys", "", "Bearer 令牌使用的 API 密钥文件，每行格式为：密钥 用户名 [组1,组2]")
+	flags.String("jwks", "", "校验 Bearer JWT 签名的本地 JWKS 文件")
+	flags.String("jwt-issuer", "", "要求 JWT 的 iss 等于该值，为空时不检查")
+	flags.String("jwt-audience", "", "要求 JWT 的 aud 包含该值，为空时不检查")
+	flags.String("jwt-user-claim", "sub", "映射为用户名的 JWT 声明")
+	flags.String("jwt-groups-claim", "groups", "映射为用户组的 JWT 声明")
+
+	flags.StringSlice("ldap-url", nil, "LDAP 服务器地址，如 ldaps://ldap.example.com，多个地址依次尝试")
+	flags.Bool("ldap-starttls", false, "在 ldap:// 连接上使用 StartTLS")
+	flags.String("ldap-ca-file", "", "校验 LDAP 服务器证书的 CA 证书文件（PEM），为空时使用系统根证书")
+	flags.Duration("ldap-timeout", http_proxy.DefaultLDAPTimeout, "LDAP 认证的超时时间")
+	flags.String("ldap-bind-dn", "", "用户 DN 模板，如 uid={username},ou=people,dc=example,dc=com，设置后直接以用户身份绑定")
+	flags.String("ldap-search-dn", "", "搜索用户和组时绑定的账号 DN，为空时匿名搜索")
+	flags.String("ldap-search-password", "", "搜索账号的密码")
+	flags.String("ldap-base-dn", "", "搜索用户的基准 DN")
+	flags.String("ldap-user-filter", "(uid={username})", "搜索用户的过滤器")
+	flags.String("ldap-group-attribute", "", "用户条目中记录所属组的属性，如 memberOf")
+	flags.String("ldap-group-base-dn", "", "搜索组的基准 DN，为空时不搜索组")
+	flags.String("ldap-group-filter", "(member={dn})", "搜索组的过滤器，{dn} 为用户 DN")
+	flags.String("ldap-group-name-attribute", "cn", "组名属性")
+	flags.String("ldap-fallback", http_proxy.LDAPFallbackDeny, "LDAP 不可用时的处理策略：deny 拒绝认证，cache 使用最近认证成功的结果")
+	flags.Duration("ldap-fallback-ttl", http_proxy.DefaultLDAPFallbackTTL, "LDAP 不可用时认证成功结果的有效期")
+}
+
+// newProxyAuth 根据命令行标志创建代理认证配置
+// 指定了 --auth-file 时从认证文件读取用户，指定了 --ldap-url 时使用 LDAP 目录，否则使用 --username 和 --password；
+// 指定了 --api-keys 或 --jwks 时接受 Bearer 令牌。都未设置时返回 nil。
+// run 运行认证文件重新加载和缓存清理等后台任务，直到 ctx 结束
+func newProxyAuth(flags *pflag.FlagSet, audit *log.Logger) (auth *http_proxy.ProxyAuth, run func(ctx context.Context), err error) {
+	var tasks []func(ctx context.Context)
+	var authenticator http_proxy.Authenticator
+	var digestCredentials http_proxy.DigestCredentials
+	var fileAuth *http_proxy.FileAuthenticator
+	reload, _ := flags.GetDuration("auth-reload")
+
+	ldapURLs, _ := flags.GetStringSlice("ldap-url")
+	if authFile != "" && len(ldapURLs) > 0 {
+		return nil, nil, fmt.Errorf("--auth-file 和 --ldap-url 不能同时使用")
+	}
+
+	if len(ldapURLs) > 0 {
+		ldapAuth, err := newLDAPAuthenticator(flags, ldapURLs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ldapAuth.FallbackCache != nil {
+			tasks = append(tasks, ldapAuth.FallbackCache.Run)
+		}
+		authenticator = ldapAuth
+	} else if authFile != "" {
+		fileAuth, err = http_proxy.NewFileAuthenticator(authFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("auth file: loaded %d users", fileAuth.Len())
+		if reload > 0 {
+			tasks = append(tasks, func(ctx context.Context) { fileAuth.Run(ctx, reload) })
+		}
+		authenticator, digestCredentials = fileAuth, fileAuth
+	} else {
+		username, _ := flags.GetString("username")
+		password, _ := flags.GetString("password")
+		if username != "" && password != "" {
+			static := &http_proxy.StaticAuthenticator{Username: username, Password: password}
+			authenticator, digestCredentials = static, static
+		}
+	}
+
+	tokens, tokenTasks, err := newTokenAuthenticators(flags, reload)
+	if err != nil {
+		return nil, nil, err
+	}
+	tasks = append(tasks, tokenTasks...)
+	if authenticator == nil && len(tokens) == 0 {
+		return nil, nil, nil
+	}
+
+	if size, _ := flags.GetInt("auth-cache-size"); size > 0 && authenticator != nil {
+		ttl, _ := flags.GetDuration("auth-cache-ttl")
+		cache := http_proxy.NewIdentityCache(size, ttl)
+		if fileAuth != nil {
+			// 认证文件变更后缓存的身份可能已失效
+			fileAuth.OnReload = cache.Purge
+		}
+		tasks = append(tasks, cache.Run)
+		authenticator = &http_proxy.CachedAuthenticator{Authenticator: authenticator, Cache: cache}
+	}
+
+	guard := http_proxy.NewAuthGuard()
+	guard.MaxFailures, _ = flags.GetInt("auth-max-failures")
+	guard.BaseDelay, _ = flags.GetDuration("auth-backoff")
+	guard.MaxDelay, _ = flags.GetDuration("auth-max-backoff")
+	guard.BanDuration, _ = flags.GetDuration("auth-ban-duration")
+	guard.FailureWindow, _ = flags.GetDuration("auth-failure-window")
+	guard.AuditLog = audit
+
+	auth = &http_proxy.ProxyAuth{Guard: guard}
+	auth.Realm, _ = flags.GetString("auth-realm")
+	schemes, _ := flags.GetStringSlice("auth-schemes")
+	if !flags.Changed("auth-schemes") {
+		// 未指定认证方案时按已配置的凭据来源启用
+		schemes = nil
+		if authenticator != nil {
+			schemes = append(schemes, "basic")
+		}
+		if len(tokens) > 0 {
+			schemes = append(schemes, "bearer")
+		}
+	}
+	for _, scheme := range schemes {
+		switch strings.ToLower(scheme) {
+		case "basic", "digest":
+			if authenticator == nil {
+				return nil, nil, fmt.Errorf("认证方案 %s 需要 --auth-file 或 --username 和 --password", scheme)
+			}
+			if strings.EqualFold(scheme, "basic") {
+				auth.Authenticator = authenticator
+			} else if digestCredentials == nil {
+				return nil, nil, fmt.Errorf("LDAP 认证不支持 digest 认证方案")
+			} else {
+				auth.Digest = http_proxy.NewDigestAuth(digestCredentials)
+			}
+		case "bearer":
+			if len(tokens) == 0 {
+				return nil, nil, fmt.Errorf("认证方案 bearer 需要 --api-keys 或 --jwks")
+			}
+			auth.Tokens = tokens
+		default:
+			return nil, nil, fmt.Errorf("无效的认证方案: %s", scheme)
+		}
+	}
+	if auth.Authenticator == nil && auth.Digest == nil && len(auth.Tokens) == 0 {
+		return nil, nil, fmt.Errorf("至少需要启用一种认证方案")
+	}
+	run = func(ctx context.Context) {
+		var wg sync.WaitGroup
+		for _, task := range tasks {
+			wg.Add(1)
+			go func(task func(context.Context)) {
+				defer wg.Done()
+				task(ctx)
+			}(task)
+		}
+		wg.Wait()
+	}
+	return auth, run, nil
+}
+
+// newLDAPAuthenticator 根据 --ldap-* 标志创建 LDAP 认证
+func newLDAPAuthenticator(flags *pflag.FlagSet, urls []string) (*http_proxy.LDAPAuthenticator, error) {
+	a := http_proxy.NewLDAPAuthenticator(urls...)
+	a.StartTLS, _ = flags.GetBool("ldap-starttls")
+	a.Timeout, _ = flags.GetDuration("ldap-timeout")
+	a.BindDN, _ = flags.GetString("ldap-bind-dn")
+	a.SearchDN, _ = flags.GetString("ldap-search-dn")
+	a.SearchPassword, _ = flags.GetString("ldap-search-password")
+	a.BaseDN, _ = flags.GetString("ldap-base-dn")
+	a.UserFilter, _ = flags.GetString("ldap-user-filter")
+	a.GroupAttribute, _ = flags.GetString("ldap-group-attribute")
+	a.GroupBaseDN, _ = flags.GetString("ldap-group-base-dn")
+	a.GroupFilter, _ = flags.GetString("ldap-group-filter")
+	a.GroupNameAttribute, _ = flags.GetString("ldap-group-name-attribute")
+	if a.BindDN == "" && a.BaseDN == "" {
+		return nil, fmt.Errorf("LDAP 认证需要 --ldap-bind-dn 或 --ldap-base-dn")
+	}
+
+	if caFile, _ := flags.GetString("ldap-ca-file"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 LDAP CA 证书失败: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("无效的 LDAP CA 证书: %s", caFile)
+		}
+		a.TLSConfig = &tls.Config{RootCAs: roots}
+	}
+
+	a.Fallback, _ = flags.GetString("ldap-fallback")
+	switch a.Fallback {
+	case http_proxy.LDAPFallbackDeny:
+	case http_proxy.LDAPFallbackCache:
+		ttl, _ := flags.GetDuration("ldap-fallback-ttl")
+		size, _ := flags.GetInt("auth-cache-size")
+		a.FallbackCache = http_proxy.NewIdentityCache(size, ttl)
+	default:
+		return nil, fmt.Errorf("无效的 LDAP 不可用策略: %s", a.Fallback)
+	}
+	return a, nil
+}
+
+// newTokenAuthenticators 根据 --api-keys 和 --jwks 创建 Bearer 令牌校验器
+func newTokenAuthenticators(flags *pflag.FlagSet, reload time.Duration) (tokens []http_proxy.TokenAuthenticator, tasks []func(ctx context.Context), err error) {
+	if path, _ := flags.GetString("api-keys"); path != "" {
+		keys, err := http_proxy.NewAPIKeyAuthenticator(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("api keys: loaded %d keys", keys.Len())
+		if reload > 0 {
+			tasks = append(tasks, func(ctx context.Context) { keys.Run(ctx, reload) })
+		}
+		tokens = append(tokens, keys)
+	}
+
+	if path, _ := flags.GetString("jwks"); path != "" {
+		jwt, err := http_proxy.NewJWTAuthenticator(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		jwt.Issuer, _ = flags.GetString("jwt-issuer")
+		jwt.Audience, _ = flags.GetString("jwt-audience")
+		jwt.UserClaim, _ = flags.GetString("jwt-user-claim")
+		jwt.GroupsClaim, _ = flags.GetString("jwt-groups-claim")
+		log.Printf("jwks: loaded %d keys", jwt.Len())
+		if reload > 0 {
+			tasks = append(tasks, func(ctx context.Context) { jwt.Run(ctx, reload) })
+		}
+		tokens = append(tokens, jwt)
+	}
+	return tokens, tasks, nil
+}
+
+// registerAuthAPI 注册认证封禁列表的管理接口
+//
+//	GET    /api/bans            列出当前的封禁
+//	DELETE /api/bans?key=ip:x   解除指定封禁
+//	DELETE /api/bans            解除所有封禁
+func registerAuthAPI(api *adminAPI, guard *http_proxy.AuthGuard) {
+	api.handle("/api/bans", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, guard.Bans())
+		case http.MethodDelete:
+			key := r.URL.Query().Get("key")
+			if key == "" {
+				writeJSON(w, http.StatusOK, map[string]int{"cleared": guard.Clear()})
+				return
+			}
+			if !guard.Unban(key) {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]int{"cleared": 1})
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+var bansFlags = struct {
+	adminURL string
+	token    string
+}{}
+
+var bansCmd = &cobra.Command{
+	Use:   "bans",
+	Short: "查看因认证失败被封禁的客户端IP和用户名",
+	Run: func(cmd *cobra.Command, args []string) {
+		var bans []http_proxy.AuthBan
+		if err := adminRequest(http.MethodGet, "/api/bans", &bans); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tFAILURES\tUNTIL")
+		for _, ban := range bans {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", ban.Key, ban.Failures, ban.Until.Local().Format("2006-01-02 15:04:05"))
+		}
+		w.Flush()
+	},
+}
+
+var bansClearCmd = &cobra.Command{
+	Use:   "clear [ip:地址|user:用户名]...",
+	Short: "解除封禁，不指定参数时解除所有封禁",
+	Run: func(cmd *cobra.Command, args []string) {
+		paths := []string{"/api/bans"}
+		if len(args) > 0 {
+			paths = paths[:0]
+			for _, key := range args {
+				if !strings.Contains(key, ":") {
+					key = http_proxy.AuthGuardIP + ":" + key
+				}
+				paths = append(paths, "/api/bans?key="+url.QueryEscape(key))
+			}
+		}
+
+		failed := false
+		for _, path := range paths {
+			var result struct {
+				Cleared int `json:"cleared"`
+			}
+			if err := adminRequest(http.MethodDelete, path, &result); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("已解除 %d 个封禁\n", result.Cleared)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bansCmd)
+	bansCmd.AddCommand(bansClearCmd)
+	bansCmd.PersistentFlags().StringVar(&bansFlags.adminURL, "admin-url", "http://127.0.0.1:12829", "管理接口地址")
+	bansCmd.PersistentFlags().StringVar(&bansFlags.token, "admin-token", "", "管理接口的访问令牌")
+}
+
+// adminRequest 请求运行中的代理服务器的管理接口，并将 JSON 响应解码到 v
+func adminRequest(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(bansFlags.adminURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if bansFlags.token != "" {
+		req.Header.Set("Authorization", "Bearer "+bansFlags.token)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求管理接口失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("管理接口返回错误: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
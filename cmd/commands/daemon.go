@@ -1,30 +1,56 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/utils"
 )
 
 var (
 	pidFile     = "/var/run/zaproxy.pid"
 	logFile     = "/var/log/zaproxy.log"
 	daemonFlags = struct {
-		pidFile string
-		logFile string
+		pidFile         string
+		logFile         string
+		supervise       bool
+		restartDelay    time.Duration
+		restartMaxDelay time.Duration
+		stopTimeout     time.Duration
 	}{}
 )
 
+// daemonOnlyFlags 是守护进程自身的标志，不转发给 http 服务进程
+var daemonOnlyFlags = map[string]bool{
+	"daemon":            true,
+	"pid-file":          true,
+	"log-file":          true,
+	"supervise":         true,
+	"restart-delay":     true,
+	"restart-max-delay": true,
+	"stop-timeout":      true,
+}
+
+// supervisorStableTime 子进程运行超过该时间后重置重启退避时间
+const supervisorStableTime = time.Minute
+
 var daemonCmd = &cobra.Command{
 	Use:   "daemon",
 	Short: "以守护进程模式运行zaproxy",
-	Long:  `以守护进程模式运行zaproxy，支持start、stop、restart和status操作`,
+	Long: `以守护进程模式运行zaproxy，支持start、stop、restart和status操作
+
+http 命令的所有参数都可以传给 daemon 命令，并转发给守护进程，例如：
+  zaproxy daemon start -P 8080 -u admin -p secret --supervise`,
 }
 
 var startCmd = &cobra.Command{
@@ -35,7 +61,7 @@ var startCmd = &cobra.Command{
 			fmt.Println("zaproxy守护进程已经在运行")
 			return
 		}
-		startDaemon()
+		startDaemon(cmd)
 	},
 }
 
@@ -51,8 +77,10 @@ var restartCmd = &cobra.Command{
 	Use:   "restart",
 	Short: "重启zaproxy守护进程",
 	Run: func(cmd *cobra.Command, args []string) {
-		stopDaemon()
-		startDaemon()
+		if isRunning() && !stopDaemon() {
+			return
+		}
+		startDaemon(cmd)
 	},
 }
 
@@ -64,16 +92,51 @@ var statusCmd = &cobra.Command{
 	},
 }
 
+// runCmd 是守护进程实际执行的命令，由 start 在后台启动
+var runCmd = &cobra.Command{
+	Use:    "run",
+	Short:  "在前台运行守护进程（由start内部调用）",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDaemon(cmd)
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(daemonCmd)
-	daemonCmd.AddCommand(startCmd, stopCmd, restartCmd, statusCmd)
+	daemonCmd.AddCommand(startCmd, stopCmd, restartCmd, statusCmd, runCmd)
 
 	// 添加守护进程相关的标志
 	daemonCmd.PersistentFlags().StringVar(&daemonFlags.pidFile, "pid-file", pidFile, "PID文件路径")
 	daemonCmd.PersistentFlags().StringVar(&daemonFlags.logFile, "log-file", logFile, "日志文件路径")
+	daemonCmd.PersistentFlags().BoolVar(&daemonFlags.supervise, "supervise", false, "监控服务进程，崩溃后自动重启")
+	daemonCmd.PersistentFlags().DurationVar(&daemonFlags.restartDelay, "restart-delay", time.Second, "自动重启的初始等待时间")
+	daemonCmd.PersistentFlags().DurationVar(&daemonFlags.restartMaxDelay, "restart-max-delay", time.Minute, "自动重启的最大等待时间")
+	daemonCmd.PersistentFlags().DurationVar(&daemonFlags.stopTimeout, "stop-timeout", 10*time.Second, "停止时等待进程退出的时间，超时后强制终止")
+
+	// 守护进程接受 http 命令的全部参数
+	addServerFlags(daemonCmd.PersistentFlags())
+}
+
+// forwardFlags 将命令行中显式设置的标志转换为参数列表，用于转发给子进程
+func forwardFlags(cmd *cobra.Command, skip map[string]bool) []string {
+	var args []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range sv.GetSlice() {
+				args = append(args, "--"+f.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
 }
 
-func startDaemon() {
+func startDaemon(cmd *cobra.Command) {
 	// 获取当前可执行文件的路径
 	executable, err := os.Executable()
 	if err != nil {
@@ -93,72 +156,219 @@ func startDaemon() {
 	if err != nil {
 		log.Fatalf("无法创建日志文件: %v", err)
 	}
+	defer logFd.Close()
 
-	// 准备子进程
-	cmd := exec.Command(executable, "http")
-	cmd.Stdout = logFd
-	cmd.Stderr = logFd
-	cmd.Stdin = nil
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setsid: true, // 创建新的会话
-	}
+	// 准备子进程，转发全部配置
+	args := append([]string{"daemon", "run"}, forwardFlags(cmd, map[string]bool{"daemon": true})...)
+	args = appendDefaultFlag(cmd, args, "pid-file", daemonFlags.pidFile)
+	args = appendDefaultFlag(cmd, args, "log-file", daemonFlags.logFile)
+	child := exec.Command(executable, args...)
+	child.Stdout = logFd
+	child.Stderr = logFd
+	child.Stdin = nil
+	child.SysProcAttr = detachedProcAttr()
 
 	// 启动子进程
-	if err := cmd.Start(); err != nil {
+	if err := child.Start(); err != nil {
 		log.Fatalf("启动守护进程失败: %v", err)
 	}
 
-	// 写入PID文件
-	if err := os.WriteFile(daemonFlags.pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
-		log.Printf("无法写入PID文件: %v", err)
-		cmd.Process.Kill()
+	// 等待子进程锁定PID文件，确认启动成功
+	exited := make(chan error, 1)
+	go func() {
+		exited <- child.Wait()
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-exited:
+			fmt.Printf("zaproxy守护进程启动失败: %v，详见日志 %s\n", err, daemonFlags.logFile)
+			return
+		case <-timeout:
+			fmt.Printf("zaproxy守护进程已启动，但未能确认其状态，PID: %d\n", child.Process.Pid)
+			return
+		case <-ticker.C:
+			pid, err := utils.ReadPIDFile(daemonFlags.pidFile)
+			if err == nil && pid == child.Process.Pid && utils.IsPIDFileLocked(daemonFlags.pidFile) {
+				fmt.Printf("zaproxy守护进程已启动，PID: %d\n", pid)
+				return
+			}
+		}
+	}
+}
+
+// appendDefaultFlag 当标志未显式设置时，将其当前值追加到参数列表
+func appendDefaultFlag(cmd *cobra.Command, args []string, name, value string) []string {
+	if cmd.Flags().Changed(name) {
+		return args
+	}
+	return append(args, "--"+name+"="+value)
+}
+
+// runDaemon 锁定PID文件并运行服务，PID文件锁在进程退出时释放
+func runDaemon(cmd *cobra.Command) {
+	lock, err := utils.LockPIDFile(daemonFlags.pidFile)
+	if err != nil {
+		if errors.Is(err, utils.ErrPIDFileLocked) {
+			log.Fatalf("zaproxy守护进程已经在运行: %s", daemonFlags.pidFile)
+		}
+		log.Fatalf("无法锁定PID文件: %v", err)
+	}
+	defer utils.ReleasePIDFile(lock, daemonFlags.pidFile)
+
+	// 未指定错误日志时写入守护进程日志文件，由服务进程负责轮转
+	if !cmd.Flags().Changed("error-log") {
+		cmd.Flags().Set("error-log", daemonFlags.logFile)
+	}
+
+	if daemonFlags.supervise {
+		superviseServer(forwardFlags(cmd, daemonOnlyFlags))
 		return
 	}
+	startServer(cmd)
+}
 
-	fmt.Printf("zaproxy守护进程已启动，PID: %d\n", cmd.Process.Pid)
+// superviseServer 以子进程方式运行 http 服务，异常退出时按指数退避自动重启
+func superviseServer(args []string) {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("无法获取可执行文件路径: %v", err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	// 将 SIGUSR1（重新打开日志文件）转发给当前的服务进程
+	var current atomic.Pointer[os.Process]
+	usr1 := make(chan os.Signal, 1)
+	notifyReopen(usr1)
+	defer signal.Stop(usr1)
+	go func() {
+		for sig := range usr1 {
+			if p := current.Load(); p != nil {
+				p.Signal(sig)
+			}
+		}
+	}()
+
+	delay := daemonFlags.restartDelay
+	for {
+		child := exec.Command(executable, append([]string{"http"}, args...)...)
+		child.Stdout = os.Stdout
+		child.Stderr = os.Stderr
+
+		started := time.Now()
+		if err := child.Start(); err != nil {
+			log.Printf("启动服务进程失败: %v", err)
+		} else {
+			log.Printf("服务进程已启动，PID: %d", child.Process.Pid)
+			current.Store(child.Process)
+			exited := make(chan error, 1)
+			go func() {
+				exited <- child.Wait()
+			}()
+
+			select {
+			case sig := <-sigs:
+				log.Printf("收到信号 %v，停止服务进程", sig)
+				child.Process.Signal(syscall.SIGTERM)
+				select {
+				case <-exited:
+				case <-time.After(daemonFlags.stopTimeout):
+					log.Printf("服务进程未在 %v 内退出，强制终止", daemonFlags.stopTimeout)
+					child.Process.Kill()
+					<-exited
+				}
+				return
+			case err := <-exited:
+				if err == nil {
+					log.Printf("服务进程已正常退出")
+					return
+				}
+				log.Printf("服务进程异常退出: %v", err)
+			}
+		}
+
+		// 运行足够长时间后重置退避时间
+		if time.Since(started) > supervisorStableTime {
+			delay = daemonFlags.restartDelay
+		}
+
+		log.Printf("%v 后重启服务进程", delay)
+		select {
+		case <-sigs:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > daemonFlags.restartMaxDelay {
+			delay = daemonFlags.restartMaxDelay
+		}
+	}
 }
 
-func stopDaemon() {
+// stopDaemon 停止守护进程并等待其退出，返回是否成功停止
+func stopDaemon() bool {
 	pid, err := readPIDFile()
 	if err != nil {
 		fmt.Printf("读取PID文件失败: %v\n", err)
-		return
+		return false
 	}
 
 	process, err := os.FindProcess(pid)
 	if err != nil {
 		fmt.Printf("查找进程失败: %v\n", err)
-		return
+		return false
 	}
 
 	// 发送SIGTERM信号
 	if err := process.Signal(syscall.SIGTERM); err != nil {
 		fmt.Printf("停止进程失败: %v\n", err)
-		return
+		return false
+	}
+
+	// 等待进程退出，超时后向整个进程组发送SIGKILL，
+	// 守护进程是新会话的首进程，--supervise 启动的服务进程在同一进程组中
+	if !utils.WaitForExit(pid, daemonFlags.stopTimeout) {
+		fmt.Printf("进程未在 %v 内退出，强制终止\n", daemonFlags.stopTimeout)
+		if err := killProcessGroup(pid); err != nil {
+			fmt.Printf("强制终止进程失败: %v\n", err)
+			return false
+		}
+		if !utils.WaitForExit(pid, 5*time.Second) {
+			fmt.Printf("进程 %d 仍未退出\n", pid)
+			return false
+		}
 	}
 
-	// 删除PID文件
-	if err := os.Remove(daemonFlags.pidFile); err != nil {
-		fmt.Printf("删除PID文件失败: %v\n", err)
+	// 进程正常退出时会自行删除PID文件，被强制终止时删除残留的文件；
+	// 文件已被新启动的进程锁定时保留
+	if !utils.IsPIDFileLocked(daemonFlags.pidFile) {
+		if err := utils.RemoveFileIfExists(daemonFlags.pidFile); err != nil {
+			fmt.Printf("删除PID文件失败: %v\n", err)
+		}
 	}
 
 	fmt.Println("zaproxy守护进程已停止")
+	return true
 }
 
 func isRunning() bool {
-	pid, err := readPIDFile()
-	if err != nil {
-		return false
+	// PID文件被锁定说明守护进程正在运行
+	if utils.IsPIDFileLocked(daemonFlags.pidFile) {
+		return true
 	}
 
-	process, err := os.FindProcess(pid)
+	pid, err := readPIDFile()
 	if err != nil {
 		return false
 	}
-
-	// 在Unix系统中，FindProcess总是成功的，所以我们需要发送信号0来检查进程是否真的存在
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
+	return utils.IsRunning(pid)
 }
 
 func checkStatus() {
@@ -172,24 +382,10 @@ func checkStatus() {
 }
 
 func readPIDFile() (int, error) {
-	data, err := os.ReadFile(daemonFlags.pidFile)
-	if err != nil {
-		return 0, fmt.Errorf("读取PID文件失败: %v", err)
-	}
-
-	pid, err := strconv.Atoi(string(data))
-	if err != nil {
-		return 0, fmt.Errorf("解析PID失败: %v", err)
-	}
-
-	return pid, nil
+	return utils.ReadPIDFile(daemonFlags.pidFile)
 }
 
 // 确保目录存在
 func ensureDir(path string) error {
-	dir := filepath.Dir(path)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return os.MkdirAll(dir, 0755)
-	}
-	return nil
+	return utils.EnsureDir(filepath.Dir(path))
 }
@@ -8,20 +8,38 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/sevlyar/go-daemon"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/zapj/zaproxy/http_proxy"
+	"github.com/zapj/zaproxy/utils"
 )
 
 func init() {
-	httpCmd.PersistentFlags().IntP("port", "P", 12828, "Proxy Server Port")
-	httpCmd.PersistentFlags().StringP("username", "u", "zaproxy", "username")
-	httpCmd.PersistentFlags().StringP("password", "p", "zaproxy", "password")
+	addServerFlags(httpCmd.PersistentFlags())
 	rootCmd.AddCommand(httpCmd)
 }
 
+// addServerFlags 注册代理服务器的标志，http 命令和 daemon 命令共用
+func addServerFlags(flags *pflag.FlagSet) {
+	flags.IntP("port", "P", 12828, "Proxy Server Port")
+	flags.StringP("username", "u", "zaproxy", "username")
+	flags.StringP("password", "p", "zaproxy", "password")
+	addAuthFlags(flags)
+	addACLFlags(flags)
+	addRewriteFlags(flags)
+	addLimitFlags(flags)
+	addQuotaFlags(flags)
+	addSecurityFlags(flags)
+	addLogFlags(flags)
+	addAdminFlags(flags)
+}
+
 var httpCmd = &cobra.Command{
 	Use:   "http",
 	Short: "start http proxy, default port : 12828",
@@ -57,25 +75,131 @@ func startServer(cmd *cobra.Command) {
 	if err != nil {
 		port = 12828
 	}
-	username, err := cmd.Flags().GetString("username")
+	// serverCtx 在服务器退出时取消，用于结束后台任务
+	serverCtx, stopServerCtx := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	defer background.Wait()
+	defer stopServerCtx()
+
+	logs, err := openServerLogs(cmd)
 	if err != nil {
-		username = "zaproxy"
+		log.Fatal(err)
 	}
-	password, err := cmd.Flags().GetString("password")
+	defer logs.Close()
+	stopReopen := logs.reopenOnSignal()
+	defer stopReopen()
+
+	rateLimiter, err := newRateLimiter(cmd.Flags())
 	if err != nil {
-		password = "zaproxy"
+		log.Fatal(err)
 	}
-	proxyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 认证检查
-		if username != "" && password != "" {
-			lname, lpass, ok := http_proxy.GetBasicAuth(r)
-			if !ok || username != lname || password != lpass {
-				w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+	bandwidthLimiter, err := newBandwidthLimiter(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	connLimiter := newConnLimiter(cmd.Flags())
+
+	destinationPolicy, err := newDestinationPolicy(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientPolicy, err := newClientPolicy(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	portPolicy, err := newPortPolicy(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	forwarding, err := newForwardingPolicy(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	blocklist, err := newBlocklist(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if blocklist != nil {
+		log.Printf("blocklist: loaded %d rules", blocklist.Len())
+		if interval, _ := cmd.Flags().GetDuration("blocklist-reload"); interval > 0 {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				blocklist.Run(serverCtx, interval)
+			}()
 		}
+	}
+
+	adminToken, _ := cmd.Flags().GetString("admin-token")
+	admin := newAdminAPI(adminToken)
 
+	auth, runAuth, err := newProxyAuth(cmd.Flags(), logs.audit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auth != nil {
+		registerAuthAPI(admin, auth.Guard)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runAuth(serverCtx)
+		}()
+	}
+
+	acl, err := newACL(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if acl != nil {
+		log.Printf("acl: loaded %d rules", acl.Len())
+		if interval, _ := cmd.Flags().GetDuration("acl-reload"); interval > 0 {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				acl.Run(serverCtx, interval)
+			}()
+		}
+	}
+
+	rewrite, err := newRewriteRules(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rewrite != nil {
+		log.Printf("rewrite: loaded %d rules", rewrite.Len())
+		if interval, _ := cmd.Flags().GetDuration("rewrite-reload"); interval > 0 {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				rewrite.Run(serverCtx, interval)
+			}()
+		}
+	}
+
+	quota, err := newQuotaManager(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if quota != nil {
+		registerQuotaAPI(admin, quota)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			quota.Run(serverCtx)
+		}()
+	}
+
+	adminAddr, _ := cmd.Flags().GetString("admin-listen")
+	stopAdmin, err := admin.start(adminAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopAdmin()
+
+	// 监听器创建后再设置，识别目标为代理自身的请求
+	var loopDetector *http_proxy.LoopDetector
+	proxyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// URL解析
 		path, err := url.Parse("http://" + r.Host)
 		if err != nil {
@@ -84,23 +208,66 @@ func startServer(cmd *cobra.Command) {
 			return
 		}
 		proxy := http_proxy.NewReverseProxy(path)
+		proxy.AccessLog = logs.access
+		proxy.ClientPolicy = clientPolicy
+		proxy.Auth = auth
+		proxy.ACL = acl
+		proxy.RateLimiter = rateLimiter
+		proxy.BandwidthLimiter = bandwidthLimiter
+		proxy.Quota = quota
+		proxy.ConnLimiter = connLimiter
+		proxy.DestinationPolicy = destinationPolicy
+		proxy.ConnectPorts = portPolicy
+		proxy.Forwarding = forwarding
+		proxy.LoopDetector = loopDetector
+		proxy.Rewrite = rewrite
+		proxy.Blocklist = blocklist
 		proxy.ServeHTTP(w, r)
 	})
 
-	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: proxyHandler}
-	log.Printf("server start : %s", server.Addr)
+	stats := &connStats{}
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: proxyHandler, ConnState: stats.track}
+	listener, err := proxyListener(server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if listener, err = proxyProtocolListener(cmd.Flags(), listener); err != nil {
+		log.Fatal(err)
+	}
+	if maxClientConns, _ := cmd.Flags().GetInt("max-client-conns"); maxClientConns > 0 {
+		listener = utils.LimitListener(listener, maxClientConns)
+	}
+	loopDetector = http_proxy.NewLoopDetector(listener.Addr())
+	log.Printf("server start : %s", listener.Addr())
+
+	var serving atomic.Bool
+	serving.Store(true)
+	serverErr := make(chan error, 1)
 	go func() {
-		err := server.ListenAndServe()
-		if err != nil {
-			log.Fatal(err)
-		}
+		err := server.Serve(listener)
+		serving.Store(false)
+		serverErr <- err
 	}()
+
+	// 通知 systemd 服务已就绪（Type=notify）
+	notifySystemd(utils.SdNotifyReady)
+	utils.SdNotifyStatus("%s", stats)
+	stopWatchdog := startWatchdog(stats, serving.Load)
+	defer stopWatchdog()
+
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-	// Waiting for SIGINT (kill -2)
-	<-stop
+	// Waiting for SIGINT (kill -2) or SIGTERM
+	select {
+	case <-stop:
+	case err := <-serverErr:
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}
 
+	notifySystemd(utils.SdNotifyStopping)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/http_proxy"
+	"github.com/zapj/zaproxy/utils"
+)
+
+// addLimitFlags 注册限流和带宽限制相关的标志
+func addLimitFlags(flags *pflag.FlagSet) {
+	flags.Float64("rate-limit", 0, "每秒允许的请求数，0表示不限制")
+	flags.Int("rate-burst", 0, "请求的突发容量，默认等于rate-limit")
+	flags.Float64("tunnel-rate-limit", 0, "每秒允许新建的CONNECT隧道数，0表示不限制")
+	flags.Int("tunnel-burst", 0, "隧道的突发容量，默认等于tunnel-rate-limit")
+	flags.String("rate-limit-key", "user", "限流维度 (user, ip, host)")
+
+	flags.String("bandwidth", "", "全局带宽上限，格式：上传:下载，如 10M:50M，0表示不限制")
+	flags.StringArray("bandwidth-user", nil, "用户带宽上限，格式：用户名=上传:下载，用户名为*时作为默认值，可重复指定")
+	flags.StringArray("bandwidth-host", nil, "目标主机带宽上限，格式：主机模式=上传:下载，如 *.example.com=0:2M，可重复指定")
+
+	flags.Int("max-client-conns", 0, "同时接受的客户端TCP连接上限，0表示不限制")
+	flags.Int("max-conns", 0, "全局并发请求和隧道上限，0表示不限制")
+	flags.Int("max-tunnels", 0, "同时打开的CONNECT隧道上限，0表示不限制")
+	flags.Int("max-conns-per-user", 0, "每个用户的并发请求和隧道上限，0表示不限制")
+	flags.Int("max-conns-per-ip", 0, "每个客户端IP的并发请求和隧道上限，0表示不限制")
+	flags.Int("max-conns-per-host", 0, "每个目标主机的并发请求和隧道上限，0表示不限制")
+	flags.Duration("conn-queue-timeout", 0, "达到并发上限时排队等待的最长时间，0表示立即拒绝")
+}
+
+// newConnLimiter 根据命令行标志创建并发连接限制器，未启用时返回 nil
+func newConnLimiter(flags *pflag.FlagSet) *http_proxy.ConnLimiter {
+	l := &http_proxy.ConnLimiter{}
+	l.Global, _ = flags.GetInt("max-conns")
+	l.Tunnels, _ = flags.GetInt("max-tunnels")
+	l.PerUser, _ = flags.GetInt("max-conns-per-user")
+	l.PerIP, _ = flags.GetInt("max-conns-per-ip")
+	l.PerHost, _ = flags.GetInt("max-conns-per-host")
+	l.QueueTimeout, _ = flags.GetDuration("conn-queue-timeout")
+	if l.Global <= 0 && l.Tunnels <= 0 && l.PerUser <= 0 && l.PerIP <= 0 && l.PerHost <= 0 {
+		return nil
+	}
+	return l
+}
+
+// newRateLimiter 根据命令行标志创建限流器，未启用限流时返回 nil
+func newRateLimiter(flags *pflag.FlagSet) (*http_proxy.RateLimiter, error) {
+	rate, _ := flags.GetFloat64("rate-limit")
+	burst, _ := flags.GetInt("rate-burst")
+	tunnelRate, _ := flags.GetFloat64("tunnel-rate-limit")
+	tunnelBurst, _ := flags.GetInt("tunnel-burst")
+	if rate <= 0 && tunnelRate <= 0 {
+		return nil, nil
+	}
+
+	keyStr, _ := flags.GetString("rate-limit-key")
+	key, err := http_proxy.ParseRateLimitKey(keyStr)
+	if err != nil {
+		return nil, err
+	}
+	return http_proxy.NewRateLimiter(rate, burst, tunnelRate, tunnelBurst, key), nil
+}
+
+// newBandwidthLimiter 根据命令行标志创建带宽限制器，未启用时返回 nil
+func newBandwidthLimiter(flags *pflag.FlagSet) (*http_proxy.BandwidthLimiter, error) {
+	limiter := &http_proxy.BandwidthLimiter{Users: make(map[string]http_proxy.BandwidthLimit)}
+	enabled := false
+
+	if s, _ := flags.GetString("bandwidth"); s != "" {
+		limit, err := parseBandwidthLimit(s)
+		if err != nil {
+			return nil, err
+		}
+		limiter.Global = limit
+		enabled = true
+	}
+
+	users, _ := flags.GetStringArray("bandwidth-user")
+	for _, s := range users {
+		name, limit, err := parseNamedBandwidthLimit(s)
+		if err != nil {
+			return nil, err
+		}
+		if name == "*" {
+			limiter.PerUser = limit
+		} else {
+			limiter.Users[name] = limit
+		}
+		enabled = true
+	}
+
+	hosts, _ := flags.GetStringArray("bandwidth-host")
+	for _, s := range hosts {
+		pattern, limit, err := parseNamedBandwidthLimit(s)
+		if err != nil {
+			return nil, err
+		}
+		limiter.Rules = append(limiter.Rules, http_proxy.BandwidthRule{Pattern: pattern, BandwidthLimit: limit})
+		enabled = true
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+	return limiter, nil
+}
+
+// parseNamedBandwidthLimit 解析 名称=上传:下载 格式的带宽上限
+func parseNamedBandwidthLimit(s string) (string, http_proxy.BandwidthLimit, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return "", http_proxy.BandwidthLimit{}, fmt.Errorf("无效的带宽配置: %s", s)
+	}
+	limit, err := parseBandwidthLimit(value)
+	return name, limit, err
+}
+
+// parseBandwidthLimit 解析 上传:下载 格式的带宽上限，只有一个值时上传和下载相同
+func parseBandwidthLimit(s string) (http_proxy.BandwidthLimit, error) {
+	upStr, downStr, ok := strings.Cut(s, ":")
+	if !ok {
+		downStr = upStr
+	}
+
+	up, err := utils.ParseByteSize(upStr)
+	if err != nil {
+		return http_proxy.BandwidthLimit{}, fmt.Errorf("无效的带宽配置 %s: %w", s, err)
+	}
+	down, err := utils.ParseByteSize(downStr)
+	if err != nil {
+		return http_proxy.BandwidthLimit{}, fmt.Errorf("无效的带宽配置 %s: %w", s, err)
+	}
+	return http_proxy.BandwidthLimit{Upload: up, Download: down}, nil
+}
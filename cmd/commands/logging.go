@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/utils"
+)
+
+// addLogFlags 注册日志和日志轮转相关的标志
+func addLogFlags(flags *pflag.FlagSet) {
+	flags.String("access-log", "", "访问日志文件路径，为空则不记录访问日志")
+	flags.String("error-log", "", "错误日志文件路径，为空则输出到标准错误")
+	flags.String("audit-log", "", "审计日志文件路径，记录认证失败和封禁事件，为空则写入错误日志")
+	flags.Int("log-max-size", 100, "单个日志文件的最大大小(MB)，超过后轮转，0表示不按大小轮转")
+	flags.Duration("log-rotate-interval", 0, "按时间轮转日志的间隔，如24h，0表示不按时间轮转")
+	flags.Int("log-max-files", 7, "保留的已轮转日志文件个数，0表示不删除旧文件")
+	flags.Bool("log-compress", false, "使用gzip压缩已轮转的日志文件")
+}
+
+// serverLogs 管理代理服务器的访问日志和错误日志文件
+type serverLogs struct {
+	files  []*utils.RotatingFile
+	access *log.Logger
+	audit  *log.Logger
+}
+
+// openServerLogs 根据命令行标志打开日志文件，并将标准日志重定向到错误日志
+func openServerLogs(cmd *cobra.Command) (*serverLogs, error) {
+	flags := cmd.Flags()
+	maxSize, _ := flags.GetInt("log-max-size")
+	interval, _ := flags.GetDuration("log-rotate-interval")
+	maxFiles, _ := flags.GetInt("log-max-files")
+	compress, _ := flags.GetBool("log-compress")
+
+	logs := &serverLogs{}
+	open := func(path string) (*utils.RotatingFile, error) {
+		f, err := utils.OpenRotatingFile(path, int64(maxSize)*1024*1024, interval, maxFiles, compress)
+		if err != nil {
+			return nil, err
+		}
+		logs.files = append(logs.files, f)
+		return f, nil
+	}
+
+	if path, _ := flags.GetString("error-log"); path != "" {
+		f, err := open(path)
+		if err != nil {
+			return nil, fmt.Errorf("打开错误日志失败: %w", err)
+		}
+		log.SetOutput(f)
+	}
+
+	if path, _ := flags.GetString("access-log"); path != "" {
+		f, err := open(path)
+		if err != nil {
+			logs.Close()
+			return nil, fmt.Errorf("打开访问日志失败: %w", err)
+		}
+		logs.access = log.New(f, "", 0)
+	}
+
+	if path, _ := flags.GetString("audit-log"); path != "" {
+		f, err := open(path)
+		if err != nil {
+			logs.Close()
+			return nil, fmt.Errorf("打开审计日志失败: %w", err)
+		}
+		logs.audit = log.New(f, "", log.LstdFlags)
+	}
+
+	return logs, nil
+}
+
+// reopenOnSignal 收到 SIGUSR1 时重新打开日志文件，配合外部 logrotate 使用
+func (l *serverLogs) reopenOnSignal() (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	notifyReopen(sigs)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigs:
+				for _, f := range l.files {
+					if err := f.Reopen(); err != nil {
+						log.Printf("reopen log file error: %v", err)
+					}
+				}
+				log.Printf("log files reopened")
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// Close 关闭所有日志文件
+func (l *serverLogs) Close() {
+	log.SetOutput(os.Stderr)
+	for _, f := range l.files {
+		f.Close()
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package commands
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr 在非 Unix 平台上不设置进程属性
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// killProcessGroup 在非 Unix 平台上没有进程组，只终止 pid 进程
+func killProcessGroup(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
+
+// notifyReopen 在非 Unix 平台上没有 SIGUSR1，为空操作
+func notifyReopen(c chan<- os.Signal) {}
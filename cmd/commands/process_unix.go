@@ -0,0 +1,26 @@
+//go:build unix
+
+package commands
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// detachedProcAttr 返回守护进程子进程的属性，创建新的会话以脱离终端
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setsid: true, // 创建新的会话
+	}
+}
+
+// killProcessGroup 向以 pid 为首进程的整个进程组发送 SIGKILL
+func killProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// notifyReopen 在收到 SIGUSR1（重新打开日志文件）时通知 c
+func notifyReopen(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,219 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/http_proxy"
+	"github.com/zapj/zaproxy/utils"
+)
+
+// defaultQuotaFile 是默认的用量存储文件
+const defaultQuotaFile = "/var/lib/zaproxy/usage.json"
+
+// addQuotaFlags 注册流量配额相关的标志
+func addQuotaFlags(flags *pflag.FlagSet) {
+	flags.String("quota-file", defaultQuotaFile, "用量存储文件路径")
+	flags.String("quota-daily-bytes", "", "每个用户每日的流量配额，如 10G")
+	flags.Int64("quota-daily-requests", 0, "每个用户每日的请求数配额")
+	flags.String("quota-monthly-bytes", "", "每个用户每月的流量配额，如 200G")
+	flags.Int64("quota-monthly-requests", 0, "每个用户每月的请求数配额")
+	flags.StringArray("quota-user", nil, "用户配额，格式：用户名=monthly-bytes:100G,daily-requests:5000，可重复指定")
+	flags.Float64("quota-warn", 0.8, "用量达到配额的该比例时在管理接口中标记警告")
+}
+
+// newQuotaManager 根据命令行标志创建配额管理器
+// 未设置任何配额且未指定 --quota-file 时返回 nil
+func newQuotaManager(flags *pflag.FlagSet) (*http_proxy.QuotaManager, error) {
+	var defaults http_proxy.QuotaLimits
+	enabled := flags.Changed("quota-file")
+
+	for _, name := range []string{"daily-bytes", "daily-requests", "monthly-bytes", "monthly-requests"} {
+		value := flags.Lookup("quota-" + name).Value.String()
+		if value == "" || value == "0" {
+			continue
+		}
+		if err := setQuotaField(&defaults, name, value); err != nil {
+			return nil, err
+		}
+		enabled = true
+	}
+
+	users := make(map[string]http_proxy.QuotaLimits)
+	specs, _ := flags.GetStringArray("quota-user")
+	for _, spec := range specs {
+		name, fields, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("无效的用户配额: %s", spec)
+		}
+		limits := defaults
+		for _, field := range strings.Split(fields, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(field), ":")
+			if !ok {
+				return nil, fmt.Errorf("无效的用户配额: %s", spec)
+			}
+			if err := setQuotaField(&limits, key, value); err != nil {
+				return nil, err
+			}
+		}
+		users[name] = limits
+		enabled = true
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	path, _ := flags.GetString("quota-file")
+	q, err := http_proxy.NewQuotaManager(path, defaults, users)
+	if err != nil {
+		return nil, err
+	}
+	q.WarnThreshold, _ = flags.GetFloat64("quota-warn")
+	return q, nil
+}
+
+// setQuotaField 设置配额的一个字段，name 为 daily-bytes、daily-requests、monthly-bytes 或 monthly-requests
+func setQuotaField(limits *http_proxy.QuotaLimits, name, value string) error {
+	period, kind, _ := strings.Cut(name, "-")
+
+	var quota *http_proxy.Quota
+	switch period {
+	case http_proxy.QuotaDaily:
+		quota = &limits.Daily
+	case http_proxy.QuotaMonthly:
+		quota = &limits.Monthly
+	default:
+		return fmt.Errorf("无效的配额字段: %s", name)
+	}
+
+	switch kind {
+	case "bytes":
+		n, err := utils.ParseByteSize(value)
+		if err != nil {
+			return err
+		}
+		quota.Bytes = n
+	case "requests":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("无效的请求数配额: %s", value)
+		}
+		quota.Requests = n
+	default:
+		return fmt.Errorf("无效的配额字段: %s", name)
+	}
+	return nil
+}
+
+// registerQuotaAPI 注册配额查询的管理接口
+func registerQuotaAPI(api *adminAPI, quota *http_proxy.QuotaManager) {
+	api.handle("/api/quota", func(w http.ResponseWriter, r *http.Request) {
+		status := quota.Status()
+		if user := r.URL.Query().Get("user"); user != "" {
+			filtered := status[:0]
+			for _, st := range status {
+				if st.User == user {
+					filtered = append(filtered, st)
+				}
+			}
+			status = filtered
+		}
+		if r.URL.Query().Get("warning") != "" {
+			filtered := status[:0]
+			for _, st := range status {
+				if st.Warning {
+					filtered = append(filtered, st)
+				}
+			}
+			status = filtered
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+}
+
+var usageFlags = struct {
+	file   string
+	user   string
+	period string
+	key    string
+}{}
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "查看用户的流量和请求数用量",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := printUsage(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+	usageCmd.Flags().StringVar(&usageFlags.file, "quota-file", defaultQuotaFile, "用量存储文件路径")
+	usageCmd.Flags().StringVar(&usageFlags.user, "user", "", "只显示指定用户")
+	usageCmd.Flags().StringVar(&usageFlags.period, "period", http_proxy.QuotaMonthly, "统计周期 (daily, monthly)")
+	usageCmd.Flags().StringVar(&usageFlags.key, "date", "", "日期(2006-01-02)或月份(2006-01)，默认为当前周期，all表示全部")
+}
+
+// printUsage 读取用量存储文件并按用户输出用量
+func printUsage() error {
+	users, err := http_proxy.LoadUsage(usageFlags.file)
+	if err != nil {
+		return err
+	}
+
+	key := usageFlags.key
+	switch usageFlags.period {
+	case http_proxy.QuotaDaily:
+		if key == "" {
+			key = time.Now().Format("2006-01-02")
+		}
+	case http_proxy.QuotaMonthly:
+		if key == "" {
+			key = time.Now().Format("2006-01")
+		}
+	default:
+		return fmt.Errorf("无效的统计周期: %s", usageFlags.period)
+	}
+
+	names := make([]string, 0, len(users))
+	for name := range users {
+		if usageFlags.user == "" || usageFlags.user == name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tPERIOD\tBYTES\tREQUESTS")
+	for _, name := range names {
+		periods := users[name].Monthly
+		if usageFlags.period == http_proxy.QuotaDaily {
+			periods = users[name].Daily
+		}
+
+		keys := make([]string, 0, len(periods))
+		for k := range periods {
+			if key == "all" || key == k {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			u := periods[k]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", name, k, utils.FormatByteSize(u.Bytes), u.Requests)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/http_proxy"
+)
+
+// addRewriteFlags 注册重写规则相关的标志
+func addRewriteFlags(flags *pflag.FlagSet) {
+	flags.String("rewrite-file", "", "请求和响应的头部与URL重写规则文件，按顺序应用所有匹配的规则")
+	flags.Duration("rewrite-reload", time.Minute, "检查重写规则文件修改并重新加载的间隔，0表示不重新加载")
+}
+
+// newRewriteRules 根据命令行标志加载重写规则，未指定规则文件时返回 nil
+func newRewriteRules(flags *pflag.FlagSet) (*http_proxy.RewriteRules, error) {
+	path, _ := flags.GetString("rewrite-file")
+	if path == "" {
+		return nil, nil
+	}
+	return http_proxy.NewRewriteRules(path)
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/zapj/zaproxy/http_proxy"
+)
+
+// addSecurityFlags 注册访问控制相关的标志
+func addSecurityFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("deny-cidr", nil, "禁止代理访问的目标网段，默认已禁止本机回环、链路本地和云主机元数据地址")
+	flags.StringSlice("allow-cidr", nil, "允许代理访问的目标网段，优先于禁止规则")
+	flags.Bool("deny-private", false, "禁止代理访问内网地址（10/8、172.16/12、192.168/16 等）")
+	flags.Bool("disable-destination-policy", false, "关闭目标地址检查（不推荐）")
+	flags.String("connect-ports", http_proxy.DefaultConnectPorts.String(), "允许CONNECT的目标端口，如 443,8443,10000-10100，*表示不限制")
+	flags.StringArray("connect-ports-user", nil, "用户允许CONNECT的目标端口，格式：用户名=端口列表，可重复指定")
+	flags.StringSlice("client-allow", nil, "允许使用代理的客户端网段，未设置时不限制")
+	flags.StringSlice("client-deny", nil, "禁止使用代理的客户端网段，优先于 --client-allow")
+	flags.StringSlice("auth-exempt", nil, "无需认证即可使用代理的客户端网段")
+	flags.String("forwarding", string(http_proxy.ForwardingTransparent), "转发请求时传递客户端地址的方式：transparent（X-Forwarded-For）、anonymous（删除所有转发头）或 forwarded（RFC 7239 Forwarded 头）")
+	flags.String("via", "", "Via 头中代理的名称，同时用于检测转发环路，串联的代理应使用不同的名称，默认不添加 Via 头")
+	flags.StringSlice("proxy-protocol-trusted", nil, "允许发送 PROXY 协议头（v1/v2）的负载均衡网段，未设置时不解析 PROXY 头")
+	flags.Duration("proxy-protocol-timeout", http_proxy.DefaultProxyHeaderTimeout, "读取 PROXY 协议头的超时时间")
+	flags.StringSlice("blocklist", nil, "域名黑名单文件，支持 hosts、每行一个域名和 Adblock Plus 格式")
+	flags.Duration("blocklist-reload", 5*time.Minute, "检查黑名单文件修改并重新加载的间隔，0 表示不重新加载")
+	flags.String("block-page", "", "拦截页面的HTML模板文件，可使用 {{.Host}}、{{.URL}} 和 {{.Source}}")
+}
+
+// newBlocklist 根据命令行标志加载域名黑名单，未指定黑名单文件时返回 nil
+func newBlocklist(flags *pflag.FlagSet) (*http_proxy.Blocklist, error) {
+	files, _ := flags.GetStringSlice("blocklist")
+	if len(files) == 0 {
+		return nil, nil
+	}
+	blocklist, err := http_proxy.NewBlocklist(files...)
+	if err != nil {
+		return nil, err
+	}
+
+	if pagePath, _ := flags.GetString("block-page"); pagePath != "" {
+		page, err := template.ParseFiles(pagePath)
+		if err != nil {
+			return nil, fmt.Errorf("读取拦截页面失败: %w", err)
+		}
+		blocklist.BlockPage = page
+	}
+	return blocklist, nil
+}
+
+// newClientPolicy 根据命令行标志创建客户端策略，未指定任何网段时返回 nil
+func newClientPolicy(flags *pflag.FlagSet) (*http_proxy.ClientPolicy, error) {
+	allow, _ := flags.GetStringSlice("client-allow")
+	deny, _ := flags.GetStringSlice("client-deny")
+	exempt, _ := flags.GetStringSlice("auth-exempt")
+	if len(allow) == 0 && len(deny) == 0 && len(exempt) == 0 {
+		return nil, nil
+	}
+	return http_proxy.NewClientPolicy(allow, deny, exempt)
+}
+
+// newForwardingPolicy 根据命令行标志创建转发头策略
+func newForwardingPolicy(flags *pflag.FlagSet) (*http_proxy.ForwardingPolicy, error) {
+	mode, _ := flags.GetString("forwarding")
+	via, _ := flags.GetString("via")
+	policy, err := http_proxy.NewForwardingPolicy(mode, via)
+	if err != nil {
+		return nil, fmt.Errorf("无效的转发头配置: %w", err)
+	}
+	return policy, nil
+}
+
+// proxyProtocolListener 根据命令行标志为监听器启用 PROXY 协议，未指定可信网段时原样返回
+func proxyProtocolListener(flags *pflag.FlagSet, l net.Listener) (net.Listener, error) {
+	trusted, _ := flags.GetStringSlice("proxy-protocol-trusted")
+	if len(trusted) == 0 {
+		return l, nil
+	}
+	nets, err := http_proxy.ParseCIDRs(trusted)
+	if err != nil {
+		return nil, err
+	}
+	pl := http_proxy.NewProxyProtocolListener(l, nets)
+	pl.HeaderTimeout, _ = flags.GetDuration("proxy-protocol-timeout")
+	return pl, nil
+}
+
+// newPortPolicy 根据命令行标志创建 CONNECT 端口策略
+func newPortPolicy(flags *pflag.FlagSet) (*http_proxy.PortPolicy, error) {
+	portsStr, _ := flags.GetString("connect-ports")
+	ports, err := http_proxy.ParsePortList(portsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &http_proxy.PortPolicy{Ports: ports, Users: make(map[string]http_proxy.PortList)}
+	specs, _ := flags.GetStringArray("connect-ports-user")
+	for _, spec := range specs {
+		name, list, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("无效的用户端口配置: %s", spec)
+		}
+		userPorts, err := http_proxy.ParsePortList(list)
+		if err != nil {
+			return nil, err
+		}
+		policy.Users[name] = userPorts
+	}
+	return policy, nil
+}
+
+// newDestinationPolicy 根据命令行标志创建目标地址策略，关闭检查时返回 nil
+func newDestinationPolicy(flags *pflag.FlagSet) (*http_proxy.DestinationPolicy, error) {
+	if disabled, _ := flags.GetBool("disable-destination-policy"); disabled {
+		return nil, nil
+	}
+
+	deny := append([]string{}, http_proxy.DefaultDeniedNetworks...)
+	if denyPrivate, _ := flags.GetBool("deny-private"); denyPrivate {
+		deny = append(deny, http_proxy.PrivateNetworks...)
+	}
+	extraDeny, _ := flags.GetStringSlice("deny-cidr")
+	deny = append(deny, extraDeny...)
+	allow, _ := flags.GetStringSlice("allow-cidr")
+
+	return http_proxy.NewDestinationPolicy(allow, deny)
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/zapj/zaproxy/utils"
+)
+
+// connStats 统计服务器连接数，用于 systemd STATUS 通知
+type connStats struct {
+	active atomic.Int64
+	total  atomic.Int64
+}
+
+// track 作为 http.Server.ConnState 回调使用
+func (s *connStats) track(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.active.Add(1)
+		s.total.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		// 被劫持的连接（CONNECT 隧道）不再由 http.Server 管理
+		s.active.Add(-1)
+	}
+}
+
+func (s *connStats) String() string {
+	return fmt.Sprintf("Serving %d active connections (%d total)", s.active.Load(), s.total.Load())
+}
+
+// proxyListener 返回代理服务器使用的监听器
+// 如果由 systemd 套接字激活启动，使用 systemd 传递的第一个监听器
+func proxyListener(addr string) (net.Listener, error) {
+	listeners, err := utils.SdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		// 只使用第一个监听器，关闭多余的
+		for _, l := range listeners[1:] {
+			l.Close()
+		}
+		log.Printf("using systemd socket activation: %s", listeners[0].Addr())
+		return listeners[0], nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// notifySystemd 发送 systemd 通知，忽略非 systemd 环境
+func notifySystemd(state string) {
+	if _, err := utils.SdNotify(state); err != nil {
+		log.Printf("systemd notify error: %v", err)
+	}
+}
+
+// startWatchdog 启动健康检查循环，定期向 systemd 发送看门狗心跳和状态
+// healthy 返回 false 时不发送心跳，由 systemd 判定服务失效并重启
+func startWatchdog(stats *connStats, healthy func() bool) (stop func()) {
+	interval, err := utils.SdWatchdogEnabled()
+	if err != nil {
+		log.Printf("systemd watchdog error: %v", err)
+	}
+
+	// 未启用看门狗时仍然定期更新状态
+	tick := interval / 2
+	if tick <= 0 {
+		tick = 30 * time.Second
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if !healthy() {
+					log.Printf("health check failed, skipping watchdog ping")
+					continue
+				}
+				if interval > 0 {
+					notifySystemd(utils.SdNotifyWatchdog)
+				}
+				if _, err := utils.SdNotifyStatus("%s", stats); err != nil {
+					log.Printf("systemd notify error: %v", err)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
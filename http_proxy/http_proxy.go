@@ -2,11 +2,13 @@ package http_proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"strings"
@@ -68,6 +70,102 @@ type ReverseProxy struct {
 
 	// OnProxyError is an optional function that is called when a proxy error occurs
 	OnProxyError func(*http.Request, error)
+
+	// OnProxyComplete is an optional function that is called once a plain
+	// request or CONNECT tunnel handled by ProxyHTTP or ProxyHTTPS has
+	// finished, with its status, byte counts, upstream timings and the
+	// first error that occurred.
+	OnProxyComplete func(*ProxyStats)
+
+	// AccessLog specifies an optional logger for access log entries,
+	// one line per request in Combined Log Format.
+	// If nil, no access log is written.
+	AccessLog *log.Logger
+
+	// RateLimiter is an optional limiter applied to requests and new
+	// CONNECT tunnels before they are proxied. Requests over the limit
+	// are refused with 429 Too Many Requests.
+	RateLimiter *RateLimiter
+
+	// BandwidthLimiter is an optional limiter that shapes the upload
+	// and download bandwidth of tunnels and plain responses.
+	BandwidthLimiter *BandwidthLimiter
+
+	// Quota is an optional manager that accounts the bytes and requests
+	// of each user and refuses requests once a daily or monthly quota
+	// is exceeded.
+	Quota *QuotaManager
+
+	// ConnLimiter is an optional limiter on the number of simultaneous
+	// requests and tunnels, globally and per user, client IP and
+	// destination host.
+	ConnLimiter *ConnLimiter
+
+	// DestinationPolicy is an optional policy checked against the
+	// resolved IP address of every upstream connection, both for CONNECT
+	// tunnels and for plain requests when Transport is nil. A custom
+	// Transport must apply the policy itself, e.g. via its DialContext.
+	DestinationPolicy *DestinationPolicy
+
+	// ConnectPorts optionally restricts the destination ports allowed
+	// for CONNECT tunnels. Disallowed ports are refused with 403.
+	ConnectPorts *PortPolicy
+
+	// Blocklist optionally refuses requests and tunnels to blocked
+	// domains with 403.
+	Blocklist *Blocklist
+
+	// ClientPolicy optionally restricts which client addresses may use
+	// the proxy and which may skip authentication. It is checked before
+	// Auth.
+	ClientPolicy *ClientPolicy
+
+	// Auth optionally requires clients to authenticate before any
+	// request is proxied.
+	Auth *ProxyAuth
+
+	// ACL optionally authorizes requests and tunnels by user, group
+	// and destination after authentication.
+	ACL *ACL
+
+	// Forwarding controls the X-Forwarded-For, Forwarded and Via headers
+	// added to proxied requests, and refuses requests that already passed
+	// through this proxy with 508 Loop Detected. If nil, the client
+	// address is appended to X-Forwarded-For.
+	Forwarding *ForwardingPolicy
+
+	// LoopDetector optionally refuses requests and tunnels whose target
+	// is one of the proxy's own listen addresses with 508 Loop Detected.
+	LoopDetector *LoopDetector
+
+	// Rewrite optionally applies header and URL rewrite rules to plain
+	// requests after Director, and to their responses before
+	// ModifyResponse. Request rules may also answer the client directly
+	// with a redirect or a fixed status.
+	Rewrite *RewriteRules
+
+	// RequestFilters and ResponseFilters optionally transform plain
+	// request and response bodies as they are streamed. See
+	// FilterRequestBody and FilterResponseBody.
+	RequestFilters  []*BodyFilter
+	ResponseFilters []*BodyFilter
+
+	// RequestInterceptors are called in order for plain requests after
+	// Director and the request rewrite rules, before the request is sent
+	// upstream. An interceptor may answer the client directly by returning
+	// a response, in which case the remaining request interceptors, the
+	// upstream and the response interceptors are skipped.
+	RequestInterceptors []RequestInterceptor
+
+	// ResponseInterceptors are called in order for upstream responses
+	// after the response rewrite rules and body filters, before
+	// ModifyResponse.
+	ResponseInterceptors []ResponseInterceptor
+
+	// TunnelInterceptors are called in order for CONNECT requests before
+	// the upstream connection is dialed. An interceptor may refuse the
+	// tunnel by returning a response, or change its target.
+	TunnelInterceptors []TunnelInterceptor
 }
 
 type requestCanceler interface {
@@ -239,6 +337,17 @@ func addXForwardedForHeader(req *http.Request) {
 }
 
 func (p *ReverseProxy) ProxyHTTP(rw http.ResponseWriter, req *http.Request) {
+	req, pc := withProxyContext(req, time.Now())
+
+	// 统计请求的状态码、字节数和上游连接的耗时
+	var trace *proxyTrace
+	if p.OnProxyComplete != nil {
+		trace = &proxyTrace{}
+		rec := &responseRecorder{ResponseWriter: rw}
+		rw = rec
+		defer p.complete(pc, trace, rec)
+	}
+
 	// 通知连接建立（如果设置了回调）
 	if p.OnProxyConnect != nil {
 		p.OnProxyConnect(req)
@@ -247,11 +356,17 @@ func (p *ReverseProxy) ProxyHTTP(rw http.ResponseWriter, req *http.Request) {
 	transport := p.Transport
 	if transport == nil {
 		transport = http.DefaultTransport
+		if p.DestinationPolicy != nil {
+			transport = p.DestinationPolicy.Transport()
+		}
 	}
 
 	outreq := new(http.Request)
 	// Shallow copies of maps, like header
 	*outreq = *req
+	if trace != nil {
+		outreq = outreq.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+	}
 
 	// 使用请求上下文，确保请求可以被取消
 	if cn, ok := rw.(http.CloseNotifier); ok {
@@ -264,9 +379,7 @@ func (p *ReverseProxy) ProxyHTTP(rw http.ResponseWriter, req *http.Request) {
 				select {
 				case <-clientGone:
 					requestCanceler.CancelRequest(outreq)
-					if p.OnProxyError != nil {
-						p.OnProxyError(req, fmt.Errorf("client connection closed"))
-					}
+					p.proxyError(pc, req, fmt.Errorf("client connection closed"))
 				case <-reqDone:
 				}
 			}()
@@ -277,38 +390,111 @@ func (p *ReverseProxy) ProxyHTTP(rw http.ResponseWriter, req *http.Request) {
 	p.Director(outreq)
 	outreq.Close = false
 
+	// 对请求体应用带宽限制和流量配额
+	if (p.Quota != nil || p.BandwidthLimiter != nil) && outreq.Body != nil && outreq.Body != http.NoBody {
+		outreq.Body = &bandwidthReadCloser{Reader: p.wrapReader(req, outreq.Body, true), Closer: outreq.Body}
+	}
+	if trace != nil && outreq.Body != nil && outreq.Body != http.NoBody {
+		outreq.Body = &bandwidthReadCloser{Reader: &countingReader{r: outreq.Body, n: &trace.received}, Closer: outreq.Body}
+	}
+
 	// 复制并修改请求头
 	outreq.Header = make(http.Header)
 	copyHeader(outreq.Header, req.Header)
 	removeHeaders(outreq.Header)
-	addXForwardedForHeader(outreq)
+	p.Forwarding.apply(outreq, req)
 
 	// 记录代理请求信息
 	if p.ErrorLog != nil {
 		p.logf("http: proxy request: %s %s", outreq.Method, outreq.URL)
 	}
 
+	// 应用请求重写规则，规则可能直接响应客户端，此时不再应用响应重写规则；
+	// 规则改变目标时重新检查
+	var res *http.Response
+	var err error
+	answered := false
+	if p.Rewrite != nil {
+		var rule *RewriteRule
+		host := outreq.URL.Host
+		if res, rule, err = p.Rewrite.rewriteRequest(outreq); err != nil {
+			p.logf("http: proxy %v", err)
+			p.proxyError(pc, req, err)
+			http.Error(rw, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		answered = res != nil
+		if answered && p.ErrorLog != nil {
+			p.logf("http: proxy rewrite: %s %s answered with %d by rule at line %d", outreq.Method, outreq.URL, res.StatusCode, rule.Line)
+		}
+		if !answered && outreq.URL.Host != host && !p.checkTarget(rw, outreq) {
+			return
+		}
+	}
+
+	// 应用请求拦截器，拦截器同样可以直接响应客户端，改变目标时重新检查
+	if res == nil && len(p.RequestInterceptors) > 0 {
+		host := outreq.URL.Host
+		if res, err = p.interceptRequest(pc, outreq); err != nil {
+			p.logf("http: proxy request interceptor error: %v", err)
+			p.proxyError(pc, req, err)
+			http.Error(rw, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		answered = res != nil
+		if !answered && outreq.URL.Host != host && !p.checkTarget(rw, outreq) {
+			return
+		}
+	}
+
 	// 发送请求到目标服务器
-	res, err := transport.RoundTrip(outreq)
+	if res == nil {
+		FilterRequestBody(outreq, p.RequestFilters...)
+		// 可能过滤响应体时只接受可以解压的编码
+		if len(p.ResponseFilters) > 0 || p.Rewrite.filtersResponse(outreq) {
+			restrictAcceptEncoding(outreq.Header)
+		}
+		pc.Timings.Upstream = time.Now()
+		res, err = transport.RoundTrip(outreq)
+		if err == nil {
+			pc.Timings.FirstByte = time.Now()
+		}
+	}
 	if err != nil {
-		p.logf("http: proxy error: %v", err)
-		if p.OnProxyError != nil {
-			p.OnProxyError(req, err)
+		p.proxyError(pc, req, err)
+		var denied *DestinationDeniedError
+		if errors.As(err, &denied) {
+			p.logf("http: proxy destination denied: %s %s from %s: %v", req.Method, req.URL, req.RemoteAddr, denied)
+			http.Error(rw, "Forbidden", http.StatusForbidden)
+			return
 		}
+		p.logf("http: proxy error: %v", err)
 		http.Error(rw, "Bad Gateway", http.StatusBadGateway)
 		return
 	}
 
 	// 处理响应
 	removeHeaders(res.Header)
+	p.Forwarding.applyResponse(res)
+	if !answered {
+		if p.Rewrite != nil {
+			p.Rewrite.RewriteResponse(res)
+		}
+		FilterResponseBody(res, p.ResponseFilters...)
+		if err := p.interceptResponse(pc, res); err != nil {
+			p.logf("http: proxy response interceptor error: %v", err)
+			p.proxyError(pc, req, err)
+			res.Body.Close()
+			http.Error(rw, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+	}
 
 	// 应用ModifyResponse函数
 	if p.ModifyResponse != nil {
 		if err := p.ModifyResponse(res); err != nil {
 			p.logf("http: proxy modify response error: %v", err)
-			if p.OnProxyError != nil {
-				p.OnProxyError(req, err)
-			}
+			p.proxyError(pc, req, err)
 			http.Error(rw, "Bad Gateway", http.StatusBadGateway)
 			return
 		}
@@ -360,13 +546,14 @@ func (p *ReverseProxy) ProxyHTTP(rw http.ResponseWriter, req *http.Request) {
 			bufSize = p.BufferSize
 		}
 
+		// 对响应体应用带宽限制和流量配额
+		body := p.wrapReader(req, res.Body, false)
+
 		buf := make([]byte, bufSize)
-		_, err := io.CopyBuffer(dst, res.Body, buf)
+		_, err := io.CopyBuffer(dst, body, buf)
 		if err != nil && !isClosedConnError(err) {
 			p.logf("http: proxy error copying response: %v", err)
-			if p.OnProxyError != nil {
-				p.OnProxyError(req, err)
-			}
+			p.proxyError(pc, req, err)
 		}
 
 		// 关闭响应体
@@ -383,6 +570,17 @@ func (p *ReverseProxy) ProxyHTTP(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
+	req, pc := withProxyContext(req, time.Now())
+
+	// 统计隧道的状态码、字节数和上游连接的耗时
+	var trace *proxyTrace
+	if p.OnProxyComplete != nil {
+		trace = &proxyTrace{}
+		rec := &responseRecorder{ResponseWriter: rw}
+		rw = rec
+		defer p.complete(pc, trace, rec)
+	}
+
 	// 如果禁用了HTTPS代理，返回错误
 	if p.DisableHTTPS {
 		p.logf("https: proxy disabled by configuration")
@@ -395,6 +593,29 @@ func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
 		p.OnProxyConnect(req)
 	}
 
+	// 应用隧道拦截器，拦截器修改的是请求的副本，改变目标时按新的目标重新检查
+	target := req.URL.Host
+	if len(p.TunnelInterceptors) > 0 {
+		outreq := *req
+		outURL := *req.URL
+		outreq.URL = &outURL
+		res, err := p.interceptTunnel(pc, &outreq)
+		if err != nil {
+			p.logf("http: proxy tunnel interceptor error: %v", err)
+			p.proxyError(pc, req, err)
+			http.Error(rw, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		if res != nil {
+			writeResponse(rw, res)
+			return
+		}
+		if outreq.URL.Host != target && !p.checkTarget(rw, &outreq) {
+			return
+		}
+		target = outreq.URL.Host
+	}
+
 	hij, ok := rw.(http.Hijacker)
 	if !ok {
 		p.logf("http server does not support hijacker")
@@ -406,26 +627,28 @@ func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		p.logf("http: proxy hijack error: %v", err)
 		http.Error(rw, "Proxy Server Error", http.StatusServiceUnavailable)
-		if p.OnProxyError != nil {
-			p.OnProxyError(req, err)
-		}
+		p.proxyError(pc, req, err)
 		return
 	}
 
+	// 状态码直接写入被劫持的连接，同时记录到统计和访问日志中
+	setStatus := func(code int) {
+		trace.setStatus(code)
+		setHijackedStatus(rw, code)
+	}
+
 	// 使用defer和recover来确保连接总是被关闭
 	defer func() {
 		if r := recover(); r != nil {
 			p.logf("http: proxy panic: %v", r)
-			if p.OnProxyError != nil {
-				var err error
-				switch e := r.(type) {
-				case error:
-					err = e
-				default:
-					err = fmt.Errorf("%v", r)
-				}
-				p.OnProxyError(req, err)
+			var err error
+			switch e := r.(type) {
+			case error:
+				err = e
+			default:
+				err = fmt.Errorf("%v", r)
 			}
+			p.proxyError(pc, req, err)
 		}
 		clientConn.Close()
 	}()
@@ -442,15 +665,36 @@ func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
 		KeepAlive: 60 * time.Second, // 增加保活时间到60秒
 		DualStack: true,             // 启用双栈支持
 	}
+	if p.DestinationPolicy != nil {
+		// 在DNS解析之后检查实际连接的IP地址
+		dialer.Control = p.DestinationPolicy.Control
+	}
 
 	// 尝试建立到目标服务器的连接
-	proxyConn, err := dialer.Dial("tcp", req.URL.Host)
+	pc.Timings.Upstream = time.Now()
+	if trace != nil {
+		dialer.Control = trace.control(pc.Timings.Upstream, dialer.Control)
+	}
+	proxyConn, err := dialer.Dial("tcp", target)
+	if trace != nil {
+		addr := ""
+		if err == nil {
+			addr = proxyConn.RemoteAddr().String()
+		}
+		trace.dialed(pc.Timings.Upstream, addr)
+	}
 	if err != nil {
-		p.logf("http: proxy dial error: %v", err)
-		clientConn.Write([]byte("HTTP/1.1 504 Gateway Timeout\r\n\r\n"))
-		if p.OnProxyError != nil {
-			p.OnProxyError(req, err)
+		var denied *DestinationDeniedError
+		if errors.As(err, &denied) {
+			p.logf("http: proxy destination denied: %s %s from %s: %v", req.Method, target, req.RemoteAddr, denied)
+			clientConn.Write([]byte("HTTP/1.1 403 Forbidden\r\n\r\n"))
+			setStatus(http.StatusForbidden)
+		} else {
+			p.logf("http: proxy dial error: %v", err)
+			clientConn.Write([]byte("HTTP/1.1 504 Gateway Timeout\r\n\r\n"))
+			setStatus(http.StatusGatewayTimeout)
 		}
+		p.proxyError(pc, req, err)
 		return
 	}
 	defer proxyConn.Close()
@@ -459,27 +703,24 @@ func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
 	deadline := time.Now().Add(timeout)
 	if err = clientConn.SetDeadline(deadline); err != nil {
 		p.logf("http: proxy error setting client deadline: %v", err)
-		if p.OnProxyError != nil {
-			p.OnProxyError(req, err)
-		}
+		p.proxyError(pc, req, err)
 		return
 	}
 	if err = proxyConn.SetDeadline(deadline); err != nil {
 		p.logf("http: proxy error setting server deadline: %v", err)
-		if p.OnProxyError != nil {
-			p.OnProxyError(req, err)
-		}
+		p.proxyError(pc, req, err)
 		return
 	}
 
+	pc.Timings.FirstByte = time.Now()
+
 	// 发送连接成功响应
 	if _, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
 		p.logf("http: proxy error writing response: %v", err)
-		if p.OnProxyError != nil {
-			p.OnProxyError(req, err)
-		}
+		p.proxyError(pc, req, err)
 		return
 	}
+	setStatus(http.StatusOK)
 
 	// 使用配置的缓冲区大小或默认值
 	bufSize := 64 * 1024 // 默认64KB缓冲区
@@ -487,18 +728,24 @@ func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
 		bufSize = p.BufferSize
 	}
 
+	// 对隧道两个方向应用带宽限制和流量配额
+	upstream := p.wrapReader(req, clientConn, true)
+	downstream := p.wrapReader(req, proxyConn, false)
+	if trace != nil {
+		upstream = &countingReader{r: upstream, n: &trace.received}
+		downstream = &countingReader{r: downstream, n: &trace.sent}
+	}
+
 	// 使用错误通道
 	errChan := make(chan error, 2)
 
 	// 客户端到服务器
 	go func() {
 		buf := make([]byte, bufSize)
-		_, err := io.CopyBuffer(proxyConn, clientConn, buf)
+		_, err := io.CopyBuffer(proxyConn, upstream, buf)
 		if err != nil && !isClosedConnError(err) {
 			p.logf("http: proxy error copying to server: %v", err)
-			if p.OnProxyError != nil {
-				p.OnProxyError(req, err)
-			}
+			p.proxyError(pc, req, err)
 			errChan <- err
 		} else {
 			// 尝试优雅关闭连接
@@ -512,12 +759,10 @@ func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
 	// 服务器到客户端
 	go func() {
 		buf := make([]byte, bufSize)
-		_, err := io.CopyBuffer(clientConn, proxyConn, buf)
+		_, err := io.CopyBuffer(clientConn, downstream, buf)
 		if err != nil && !isClosedConnError(err) {
 			p.logf("http: proxy error copying to client: %v", err)
-			if p.OnProxyError != nil {
-				p.OnProxyError(req, err)
-			}
+			p.proxyError(pc, req, err)
 			errChan <- err
 		} else {
 			// 尝试优雅关闭连接
@@ -536,6 +781,17 @@ func (p *ReverseProxy) ProxyHTTPS(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// wrapReader 对复制的数据应用带宽限制和流量配额
+func (p *ReverseProxy) wrapReader(req *http.Request, r io.Reader, upload bool) io.Reader {
+	if p.Quota != nil {
+		r = &quotaReader{r: r, quota: p.Quota, user: quotaUser(req)}
+	}
+	if p.BandwidthLimiter != nil {
+		r = p.BandwidthLimiter.limitReader(req.Context(), req, r, upload)
+	}
+	return r
+}
+
 // 判断是否是连接关闭错误
 func isClosedConnError(err error) bool {
 	if err == nil {
@@ -601,6 +857,17 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	// 设置请求开始时间（用于记录请求处理时间）
 	start := time.Now()
 
+	// 创建拦截器共享的请求上下文
+	req, pc := withProxyContext(req, start)
+
+	// 记录访问日志
+	if p.AccessLog != nil {
+		rec := &responseRecorder{ResponseWriter: rw}
+		rw = rec
+		// 使用闭包以便记录认证后的用户身份
+		defer func() { p.logAccess(req, rec, start) }()
+	}
+
 	// 设置请求上下文超时
 	timeout := defaultTimeout
 	if p.Timeout > 0 {
@@ -615,8 +882,66 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		p.logf("http: proxy received request: %s %s %s", req.Method, req.URL, req.Proto)
 	}
 
+	// Via 头环路检查，只比较请求头，在认证之前进行
+	if p.Forwarding != nil && !p.checkVia(rw, req) {
+		return
+	}
+
+	// 客户端地址检查
+	if p.ClientPolicy != nil && !p.checkClient(rw, req) {
+		return
+	}
+
+	// 代理认证，免认证网段的客户端跳过
+	if p.Auth != nil && (p.ClientPolicy == nil || !p.ClientPolicy.AuthExempted(remoteIP(req))) {
+		authReq, ok := p.authenticate(rw, req)
+		if !ok {
+			return
+		}
+		req = authReq
+		pc.Identity, _ = IdentityFromContext(req.Context())
+	}
+
+	// 目标为代理自身的环路检查，可能需要解析域名，在客户端检查和认证之后进行
+	if p.LoopDetector != nil && !p.checkSelf(rw, req) {
+		return
+	}
+
+	// 访问控制检查
+	if p.ACL != nil && !p.checkACL(rw, req) {
+		return
+	}
+
+	// CONNECT 目标端口检查
+	if req.Method == http.MethodConnect && p.ConnectPorts != nil && !p.checkConnectPort(rw, req) {
+		return
+	}
+
+	// 域名黑名单检查
+	if p.Blocklist != nil && !p.checkBlocklist(rw, req) {
+		return
+	}
+
+	// 限流检查
+	if p.RateLimiter != nil && !p.checkRateLimit(rw, req) {
+		return
+	}
+
+	// 流量配额检查
+	if p.Quota != nil && !p.checkQuota(rw, req) {
+		return
+	}
+
+	// 并发连接数检查，名额在请求或隧道结束后释放
+	if p.ConnLimiter != nil {
+		release, ok := p.acquireConn(rw, req)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
 	// 根据请求方法选择处理方式
-	var err error
 	switch req.Method {
 	case "CONNECT":
 		p.ProxyHTTPS(rw, req)
@@ -631,6 +956,7 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	// 记录请求处理时间和结果
 	if p.ErrorLog != nil {
 		duration := time.Since(start)
+		err := pc.failure()
 		if err != nil {
 			p.logf("http: proxy completed request with error: %s %s %v (took %v)",
 				req.Method, req.URL, err, duration)
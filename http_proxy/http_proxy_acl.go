@@ -0,0 +1,534 @@
+package http_proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACL 规则的动作
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// ACLRequest 是访问控制检查的对象
+type ACLRequest struct {
+	// Identity 用户身份，未认证时为 nil
+	Identity *Identity
+	Method   string
+	Host     string
+	Port     int
+	Time     time.Time
+}
+
+// NewACLRequest 根据代理请求创建 ACLRequest
+func NewACLRequest(req *http.Request) *ACLRequest {
+	r := &ACLRequest{
+		Method: req.Method,
+		Host:   strings.ToLower(strings.TrimSuffix(requestHostname(req), ".")),
+		Port:   requestPort(req),
+		Time:   time.Now(),
+	}
+	if id, ok := IdentityFromContext(req.Context()); ok {
+		r.Identity = id
+	}
+	return r
+}
+
+// requestPort 返回请求的目标端口，未指定时按协议取默认端口
+func requestPort(req *http.Request) int {
+	if req.Method == http.MethodConnect {
+		return connectPort(req)
+	}
+	if port := req.URL.Port(); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	if req.URL.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+// ACLRule 是一条访问控制规则，所有条件都满足时规则匹配，未设置的条件不限制
+type ACLRule struct {
+	// Line 规则在文件中的行号
+	Line int
+	// Text 规则原文
+	Text string
+	// Action 为 ACLAllow 或 ACLDeny
+	Action string
+
+	// AnyUser 为 true 时匹配所有请求（包括未认证的请求）
+	AnyUser bool
+	Users   []string
+	Groups  []string
+
+	// Domains 目标域名，*.example.com 匹配 example.com 的所有子域名
+	Domains []string
+	// CIDRs 目标地址，域名目标按解析后的地址匹配
+	CIDRs   []*net.IPNet
+	Ports   PortList
+	Methods []string
+	// Days 允许的星期，为 nil 时不限制
+	Days *[7]bool
+	// From 和 To 是一天内的时间窗口（分钟），From 大于 To 时跨越午夜，都为 0 时不限制
+	From, To int
+}
+
+// ACLDecision 是访问控制检查的结果
+type ACLDecision struct {
+	Allowed bool
+	// Rule 匹配的规则，为 nil 时表示使用默认策略
+	Rule *ACLRule
+}
+
+// ACLTrace 记录规则不匹配的原因，用于解释检查结果
+type ACLTrace struct {
+	Rule   *ACLRule
+	Reason string
+}
+
+// ACL 按顺序检查访问控制规则，第一条匹配的规则决定是否允许，都不匹配时使用默认策略
+//
+// 规则文件每行一条，# 开头的行为注释：
+//
+//	group  admins alice,bob
+//	default deny
+//	allow  group:admins
+//	deny   *            domain=*.facebook.com
+//	allow  group:dev    domain=*.example.com port=443 method=CONNECT days=Mon-Fri hours=09:00-18:00
+//	allow  user:ci-bot  cidr=10.0.0.0/8
+//
+// group 行定义组的成员，与认证后端（如 LDAP 和 JWT）提供的用户组合并。
+type ACL struct {
+	// Path ACL 文件路径
+	Path string
+
+	// Resolver 解析域名以匹配 cidr 条件，为 nil 时使用 net.DefaultResolver。
+	// 检查到 cidr 条件时域名解析失败，请求被拒绝
+	Resolver *net.Resolver
+
+	mu           sync.RWMutex
+	rules        []*ACLRule
+	members      map[string][]string // 用户名到组
+	defaultAllow bool
+	modTime      time.Time
+}
+
+// NewACL 创建 ACL 并加载规则文件
+func NewACL(path string) (*ACL, error) {
+	a := &ACL{Path: path}
+	if err := a.Load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Load 重新加载规则文件，加载失败时保留原有规则
+func (a *ACL) Load() error {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return fmt.Errorf("acl: %w", err)
+	}
+	defer f.Close()
+
+	var modTime time.Time
+	if info, err := f.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	rules, members, defaultAllow, err := parseACL(f)
+	if err != nil {
+		return fmt.Errorf("acl: %s:%w", a.Path, err)
+	}
+
+	a.mu.Lock()
+	a.rules, a.members, a.defaultAllow, a.modTime = rules, members, defaultAllow, modTime
+	a.mu.Unlock()
+	return nil
+}
+
+// Len 返回规则数
+func (a *ACL) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.rules)
+}
+
+// parseACL 解析规则文件，错误信息以行号开头
+func parseACL(r io.Reader) (rules []*ACLRule, members map[string][]string, defaultAllow bool, err error) {
+	members = make(map[string][]string)
+	defaultAllow = false
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "group":
+			if len(fields) != 3 {
+				return nil, nil, false, fmt.Errorf("%d: group requires a name and a member list", lineNo)
+			}
+			for _, user := range strings.Split(fields[2], ",") {
+				if user != "" {
+					members[user] = append(members[user], fields[1])
+				}
+			}
+		case "default":
+			if len(fields) != 2 || (fields[1] != ACLAllow && fields[1] != ACLDeny) {
+				return nil, nil, false, fmt.Errorf("%d: default must be allow or deny", lineNo)
+			}
+			defaultAllow = fields[1] == ACLAllow
+		case ACLAllow, ACLDeny:
+			rule, err := parseACLRule(fields)
+			if err != nil {
+				return nil, nil, false, fmt.Errorf("%d: %w", lineNo, err)
+			}
+			rule.Line, rule.Text = lineNo, strings.Join(fields, " ")
+			rules = append(rules, rule)
+		default:
+			return nil, nil, false, fmt.Errorf("%d: unknown directive %q", lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, false, err
+	}
+	return rules, members, defaultAllow, nil
+}
+
+var aclWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseACLRule(fields []string) (*ACLRule, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("%s rule requires a subject", fields[0])
+	}
+	rule := &ACLRule{Action: strings.ToLower(fields[0])}
+
+	for _, subject := range strings.Split(fields[1], ",") {
+		kind, name, _ := strings.Cut(subject, ":")
+		switch {
+		case subject == "*":
+			rule.AnyUser = true
+		case kind == "user" && name != "":
+			rule.Users = append(rule.Users, name)
+		case kind == "group" && name != "":
+			rule.Groups = append(rule.Groups, name)
+		default:
+			return nil, fmt.Errorf("invalid subject %q", subject)
+		}
+	}
+
+	for _, cond := range fields[2:] {
+		key, value, ok := strings.Cut(cond, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid condition %q", cond)
+		}
+		switch strings.ToLower(key) {
+		case "domain":
+			for _, d := range strings.Split(strings.ToLower(value), ",") {
+				rule.Domains = append(rule.Domains, strings.TrimSuffix(d, "."))
+			}
+		case "cidr":
+			nets, err := ParseCIDRs(strings.Split(value, ","))
+			if err != nil {
+				return nil, err
+			}
+			rule.CIDRs = nets
+		case "port":
+			ports, err := ParsePortList(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.Ports = ports
+		case "method":
+			rule.Methods = strings.Split(strings.ToUpper(value), ",")
+		case "days":
+			days, err := parseACLDays(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.Days = days
+		case "hours":
+			from, to, err := parseACLHours(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.From, rule.To = from, to
+		default:
+			return nil, fmt.Errorf("unknown condition %q", key)
+		}
+	}
+	return rule, nil
+}
+
+// parseACLDays 解析星期列表，如 Mon-Fri,Sun
+func parseACLDays(s string) (*[7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		fromStr, toStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			toStr = fromStr
+		}
+		from, ok1 := aclWeekdays[fromStr]
+		to, ok2 := aclWeekdays[toStr]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid days %q", part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return &days, nil
+}
+
+// parseACLHours 解析时间窗口，如 09:00-18:00 或跨越午夜的 22:00-06:00
+func parseACLHours(s string) (from, to int, err error) {
+	fromStr, toStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid hours %q", s)
+	}
+	parse := func(v string) (int, error) {
+		t, err := time.Parse("15:04", v)
+		if err != nil {
+			if v == "24:00" {
+				return 24 * 60, nil
+			}
+			return 0, fmt.Errorf("invalid hours %q", s)
+		}
+		return t.Hour()*60 + t.Minute(), nil
+	}
+	if from, err = parse(fromStr); err != nil {
+		return 0, 0, err
+	}
+	if to, err = parse(toStr); err != nil {
+		return 0, 0, err
+	}
+	if from == to {
+		return 0, 0, fmt.Errorf("empty hours %q", s)
+	}
+	return from, to, nil
+}
+
+// groups 返回用户所属的组：认证后端提供的组和 ACL 文件中定义的组
+func (a *ACL) groups(id *Identity) []string {
+	if id == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append(append([]string(nil), id.Groups...), a.members[id.Username]...)
+}
+
+// Check 检查请求是否允许
+func (a *ACL) Check(ctx context.Context, r *ACLRequest) ACLDecision {
+	decision, _ := a.evaluate(ctx, r, false)
+	return decision
+}
+
+// Explain 检查请求并返回之前每条规则不匹配的原因
+func (a *ACL) Explain(ctx context.Context, r *ACLRequest) (ACLDecision, []ACLTrace) {
+	return a.evaluate(ctx, r, true)
+}
+
+func (a *ACL) evaluate(ctx context.Context, r *ACLRequest, explain bool) (ACLDecision, []ACLTrace) {
+	a.mu.RLock()
+	rules, defaultAllow := a.rules, a.defaultAllow
+	a.mu.RUnlock()
+
+	m := &aclMatcher{acl: a, ctx: ctx, req: r, groups: a.groups(r.Identity)}
+	var trace []ACLTrace
+	for _, rule := range rules {
+		reason := m.mismatch(rule)
+		if m.lookupErr != nil {
+			// 无法解析目标域名时不能判断 cidr 条件，拒绝请求
+			if explain {
+				trace = append(trace, ACLTrace{Rule: rule, Reason: "cidr lookup failed"})
+			}
+			return ACLDecision{Allowed: false, Rule: rule}, trace
+		}
+		if reason == "" {
+			return ACLDecision{Allowed: rule.Action == ACLAllow, Rule: rule}, trace
+		}
+		if explain {
+			trace = append(trace, ACLTrace{Rule: rule, Reason: reason})
+		}
+	}
+	return ACLDecision{Allowed: defaultAllow}, trace
+}
+
+// aclMatcher 在一次检查中缓存用户组和域名解析结果
+type aclMatcher struct {
+	acl    *ACL
+	ctx    context.Context
+	req    *ACLRequest
+	groups []string
+	ips    []net.IP
+	looked bool
+	// lookupErr 解析目标域名的错误，非 nil 时拒绝请求
+	lookupErr error
+}
+
+// mismatch 返回规则不匹配的原因，规则匹配时返回空字符串
+func (m *aclMatcher) mismatch(rule *ACLRule) string {
+	r := m.req
+	if !m.subjectMatches(rule) {
+		return "user"
+	}
+	if rule.Methods != nil && !containsString(rule.Methods, r.Method) {
+		return "method"
+	}
+	if rule.Ports != nil && !rule.Ports.Contains(r.Port) {
+		return "port"
+	}
+	if rule.Domains != nil && !aclDomainMatches(rule.Domains, r.Host) {
+		return "domain"
+	}
+	if rule.CIDRs != nil && !m.cidrMatches(rule.CIDRs) {
+		return "cidr"
+	}
+	now := r.Time
+	if rule.Days != nil && !rule.Days[now.Weekday()] {
+		return "days"
+	}
+	if rule.From != rule.To {
+		minute := now.Hour()*60 + now.Minute()
+		in := minute >= rule.From && minute < rule.To
+		if rule.From > rule.To {
+			in = minute >= rule.From || minute < rule.To
+		}
+		if !in {
+			return "hours"
+		}
+	}
+	return ""
+}
+
+func (m *aclMatcher) subjectMatches(rule *ACLRule) bool {
+	if rule.AnyUser {
+		return true
+	}
+	id := m.req.Identity
+	if id == nil {
+		return false
+	}
+	if containsString(rule.Users, id.Username) {
+		return true
+	}
+	for _, g := range m.groups {
+		if containsString(rule.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *aclMatcher) cidrMatches(nets []*net.IPNet) bool {
+	if ip := net.ParseIP(m.req.Host); ip != nil {
+		return containsIP(nets, ip)
+	}
+	if !m.looked {
+		m.looked = true
+		resolver := m.acl.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		addrs, err := resolver.LookupIPAddr(m.ctx, m.req.Host)
+		if err != nil {
+			log.Printf("acl: cannot resolve %s: %v", m.req.Host, err)
+			m.lookupErr = err
+		}
+		for _, addr := range addrs {
+			m.ips = append(m.ips, addr.IP)
+		}
+	}
+	for _, ip := range m.ips {
+		if containsIP(nets, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclDomainMatches 判断域名是否匹配，*.example.com 匹配 example.com 及其子域名
+func aclDomainMatches(domains []string, host string) bool {
+	for _, d := range domains {
+		if suffix, ok := strings.CutPrefix(d, "*."); ok {
+			if host == suffix || strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if d == "*" || host == d {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// changed 判断规则文件自上次加载后是否被修改
+func (a *ACL) changed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return fileModified(a.Path, a.modTime)
+}
+
+// Run 每隔 interval 检查规则文件，有修改时重新加载，直到 ctx 结束
+func (a *ACL) Run(ctx context.Context, interval time.Duration) {
+	runReload(ctx, interval, a, "acl", "rules")
+}
+
+// checkACL 按 ACL 检查请求，拒绝时写入 403 响应并返回 false
+func (p *ReverseProxy) checkACL(rw http.ResponseWriter, req *http.Request) bool {
+	r := NewACLRequest(req)
+	decision := p.ACL.Check(req.Context(), r)
+	if decision.Allowed {
+		return true
+	}
+
+	user := "-"
+	if r.Identity != nil {
+		user = r.Identity.Username
+	}
+	rule := "default policy"
+	if decision.Rule != nil {
+		rule = "rule at line " + strconv.Itoa(decision.Rule.Line)
+	}
+	p.logf("http: proxy ACL denied: %s %s:%d from %s (user %s, %s)", req.Method, r.Host, r.Port, req.RemoteAddr, user, rule)
+	if req.Method == http.MethodConnect {
+		rw.Header().Set("Connection", "close")
+	}
+	http.Error(rw, "Forbidden: access denied by policy", http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,201 @@
+package http_proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testACL = `
+# 组定义
+group admins alice
+group dev    bob,carol
+
+default deny
+
+allow group:admins
+deny  *            domain=*.facebook.com
+allow group:dev    domain=*.example.com,api.example.org port=443,8443 method=CONNECT,GET days=Mon-Fri hours=09:00-18:00
+allow user:ci-bot  cidr=10.0.0.0/8
+allow group:night  hours=22:00-06:00
+allow *            domain=public.example.net
+`
+
+func writeACL(t *testing.T, content string) *ACL {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := NewACL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acl
+}
+
+func TestACL_Check(t *testing.T) {
+	acl := writeACL(t, testACL)
+	if acl.Len() != 6 {
+		t.Fatalf("Len() = %d, want 6", acl.Len())
+	}
+
+	// 2026-10-19 是星期一
+	workday := time.Date(2026, 10, 19, 10, 0, 0, 0, time.Local)
+	evening := time.Date(2026, 10, 19, 20, 0, 0, 0, time.Local)
+	weekend := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local)
+	night := time.Date(2026, 10, 19, 2, 30, 0, 0, time.Local)
+
+	user := func(name string, groups ...string) *Identity {
+		return &Identity{Username: name, Groups: groups}
+	}
+	tests := []struct {
+		name  string
+		req   ACLRequest
+		allow bool
+		line  int // 0 表示默认策略
+	}{
+		{"admin anywhere", ACLRequest{user("alice"), "GET", "www.facebook.com", 80, workday}, true, 8},
+		{"blocked domain", ACLRequest{user("bob"), "CONNECT", "www.facebook.com", 443, workday}, false, 9},
+		{"dev in window", ACLRequest{user("bob"), "CONNECT", "git.example.com", 443, workday}, true, 10},
+		{"dev apex domain", ACLRequest{user("carol"), "GET", "example.com", 8443, workday}, true, 10},
+		{"dev exact domain", ACLRequest{user("carol"), "GET", "api.example.org", 443, workday}, true, 10},
+		{"dev other subdomain", ACLRequest{user("carol"), "GET", "www.api.example.org", 443, workday}, false, 0},
+		{"dev wrong port", ACLRequest{user("bob"), "CONNECT", "git.example.com", 22, workday}, false, 0},
+		{"dev wrong method", ACLRequest{user("bob"), "POST", "git.example.com", 443, workday}, false, 0},
+		{"dev after hours", ACLRequest{user("bob"), "CONNECT", "git.example.com", 443, evening}, false, 0},
+		{"dev weekend", ACLRequest{user("bob"), "CONNECT", "git.example.com", 443, weekend}, false, 0},
+		{"group from identity", ACLRequest{user("dave", "dev"), "CONNECT", "git.example.com", 443, workday}, true, 10},
+		{"cidr literal", ACLRequest{user("ci-bot"), "GET", "10.1.2.3", 80, workday}, true, 11},
+		{"cidr outside", ACLRequest{user("ci-bot"), "GET", "192.168.1.1", 80, workday}, false, 0},
+		{"window across midnight", ACLRequest{user("eve", "night"), "GET", "a.test", 80, night}, true, 12},
+		{"outside midnight window", ACLRequest{user("eve", "night"), "GET", "a.test", 80, workday}, false, 0},
+		{"anonymous", ACLRequest{nil, "GET", "public.example.net", 80, workday}, true, 13},
+		{"anonymous denied", ACLRequest{nil, "GET", "git.example.com", 80, workday}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := acl.Check(context.Background(), &tt.req)
+			line := 0
+			if d.Rule != nil {
+				line = d.Rule.Line
+			}
+			if d.Allowed != tt.allow || line != tt.line {
+				t.Errorf("Check() = %v (line %d), want %v (line %d)", d.Allowed, line, tt.allow, tt.line)
+			}
+		})
+	}
+}
+
+func TestACL_Explain(t *testing.T) {
+	acl := writeACL(t, testACL)
+	req := &ACLRequest{&Identity{Username: "bob"}, "CONNECT", "git.example.com", 22,
+		time.Date(2026, 10, 19, 10, 0, 0, 0, time.Local)}
+	d, trace := acl.Explain(context.Background(), req)
+	if d.Allowed || d.Rule != nil {
+		t.Fatalf("decision = %+v, want default deny", d)
+	}
+	want := []string{"user", "domain", "port", "user", "user", "domain"}
+	if len(trace) != len(want) {
+		t.Fatalf("trace length = %d, want %d", len(trace), len(want))
+	}
+	for i, tr := range trace {
+		if tr.Reason != want[i] {
+			t.Errorf("trace[%d] = %s, want %s", i, tr.Reason, want[i])
+		}
+	}
+}
+
+func TestACL_LookupFailure(t *testing.T) {
+	acl := writeACL(t, "default allow\ndeny * cidr=10.0.0.0/8\n")
+	acl.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("dns unavailable")
+		},
+	}
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.Local)
+
+	// 无法判断目标是否在被拒绝的网段内时不能放行
+	d, trace := acl.Explain(context.Background(), &ACLRequest{nil, "GET", "internal.example.com", 80, now})
+	if d.Allowed || d.Rule == nil || d.Rule.Line != 2 {
+		t.Errorf("decision = %+v, want denied by line 2", d)
+	}
+	if len(trace) != 1 || trace[0].Reason != "cidr lookup failed" {
+		t.Errorf("trace = %+v", trace)
+	}
+
+	// IP 地址不需要解析
+	if d := acl.Check(context.Background(), &ACLRequest{nil, "GET", "192.0.2.1", 80, now}); !d.Allowed {
+		t.Errorf("decision = %+v, want allowed", d)
+	}
+}
+
+func TestACL_Invalid(t *testing.T) {
+	tests := []string{
+		"allow",
+		"allow someone",
+		"allow * domain",
+		"allow * port=0",
+		"allow * cidr=10.0.0.0/33",
+		"allow * days=Someday",
+		"allow * hours=9-18",
+		"allow * hours=09:00-09:00",
+		"allow * color=red",
+		"default maybe",
+		"group admins",
+		"permit *",
+	}
+	for _, content := range tests {
+		if _, _, _, err := parseACL(strings.NewReader(content)); err == nil {
+			t.Errorf("parseACL(%q) expected error", content)
+		}
+	}
+
+	// 格式错误时保留原有规则
+	acl := writeACL(t, "allow *\n")
+	if err := os.WriteFile(acl.Path, []byte("allow\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Load(); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("Load() error = %v, want error with line number", err)
+	}
+	if acl.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", acl.Len())
+	}
+}
+
+func TestReverseProxy_ACL(t *testing.T) {
+	acl := writeACL(t, "default deny\nallow user:alice domain=*.example.com\n")
+	proxy := &ReverseProxy{
+		Auth: &ProxyAuth{Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"}},
+		ACL:  acl,
+		// 允许的 CONNECT 请求会被端口策略以 403 拒绝，据此与 ACL 区分
+		ConnectPorts: &PortPolicy{Ports: PortList{}},
+	}
+
+	tests := []struct {
+		method, target string
+		want           string
+	}{
+		{http.MethodConnect, "www.example.com:443", "CONNECT to this port is not allowed"},
+		{http.MethodConnect, "www.example.org:443", "access denied by policy"},
+		{http.MethodGet, "http://www.example.org/", "access denied by policy"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth("alice", "secret"))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s %s: status = %d, body = %q, want 403 %q", tt.method, tt.target, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
@@ -1,63 +1,204 @@
 package http_proxy
 
 import (
+	"context"
 	"crypto/subtle"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
-	"sync"
-	"time"
 )
 
-// AuthCache 用于缓存认证结果
-type AuthCache struct {
-	cache map[string]authEntry
-	mu    sync.RWMutex
+// Identity 表示通过认证的用户身份
+type Identity struct {
+	// Username 用户名
+	Username string
+	// Groups 用户所属的组
+	Groups []string
 }
 
-type authEntry struct {
-	valid    bool
+type identityKey struct{}
+
+// WithIdentity 返回携带用户身份的上下文
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext 从上下文中获取用户身份
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// ErrInvalidCredentials 表示用户名或密码错误
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator 校验代理认证的用户名和密码
+type Authenticator interface {
+	// Authenticate 凭据正确时返回用户身份，错误时返回 ErrInvalidCredentials，
+	// 其他错误表示无法完成校验（如认证后端不可用）
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+}
+
+// StaticAuthenticator 使用固定的用户名和密码认证
+type StaticAuthenticator struct {
+	Username string
+	Password string
+}
+
+// Authenticate 实现 Authenticator
+func (a *StaticAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	if !CompareCredentials(username, password, a.Username, a.Password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: username}, nil
+}
+
+// ProxyAuth 是代理认证配置
+type ProxyAuth struct {
+	// Realm 在 Proxy-Authenticate 中返回的认证域，为空时使用 "restricted"
+	Realm string
+
+	// Authenticator 校验 Basic 认证的用户名和密码，为 nil 时不接受 Basic 认证
+	Authenticator Authenticator
+
+	// Digest 启用 Digest 认证，为 nil 时不接受 Digest 认证
+	Digest *DigestAuth
+
+	// Tokens 依次校验 Bearer 令牌，为空时不接受 Bearer 认证
+	Tokens []TokenAuthenticator
+
+	// Guard 限制认证失败的次数，为 nil 时不限制
+	Guard *AuthGuard
+}
+
+func (a *ProxyAuth) realm() string {
+	if a.Realm == "" {
+		return "restricted"
+	}
+	return a.Realm
+}
+
+// challenge 要求客户端提供代理认证凭据（RFC 9110 第 11.7.1 节），
+// 按安全性从高到低列出支持的认证方案，stale 表示 Digest nonce 已过期
+func (a *ProxyAuth) challenge(rw http.ResponseWriter, stale bool) {
+	h := rw.Header()
+	h.Del("Proxy-Authenticate")
+	if a.Digest != nil {
+		for _, c := range a.Digest.challenges(a.realm(), stale) {
+			h.Add("Proxy-Authenticate", c)
+		}
+	}
+	if len(a.Tokens) > 0 {
+		h.Add("Proxy-Authenticate", fmt.Sprintf(`Bearer realm=%q`, a.realm()))
+	}
+	if a.Authenticator != nil {
+		h.Add("Proxy-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.realm()))
+	}
+	http.Error(rw, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+}
+
+// proxyCredentials 是从 Proxy-Authorization 中解析出、尚未校验的凭据
+type proxyCredentials struct {
 	username string
 	password string
-	expireAt time.Time
+	digest   *digestResponse
+	token    string
 }
 
-var (
-	authCache = &AuthCache{
-		cache: make(map[string]authEntry),
+// credentials 解析请求中已启用的认证方案的凭据
+func (a *ProxyAuth) credentials(req *http.Request) (*proxyCredentials, bool) {
+	header := req.Header.Get("Proxy-Authorization")
+	if header == "" {
+		return nil, false
 	}
-	cacheDuration = 5 * time.Minute // 缓存有效期
-)
+	if a.Authenticator != nil {
+		if username, password, ok := parseBasicAuth(header); ok {
+			return &proxyCredentials{username: username, password: password}, true
+		}
+	}
+	if a.Digest != nil {
+		if r, ok := parseDigestResponse(header); ok {
+			return &proxyCredentials{username: r.username, digest: r}, true
+		}
+	}
+	if len(a.Tokens) > 0 {
+		if token, ok := parseBearerToken(header); ok {
+			return &proxyCredentials{token: token}, true
+		}
+	}
+	return nil, false
+}
 
-// GetBasicAuth 从请求中提取基本认证信息
-func GetBasicAuth(r *http.Request) (username, password string, ok bool) {
-	auth := r.Header.Get("Proxy-Authorization")
-	if auth == "" {
-		return "", "", false
+// verify 校验凭据
+func (a *ProxyAuth) verify(req *http.Request, creds *proxyCredentials) (*Identity, error) {
+	if creds.digest != nil {
+		return a.Digest.verify(req, a.realm(), creds.digest)
+	}
+	if creds.token != "" {
+		return a.verifyToken(req.Context(), creds.token)
 	}
+	return a.Authenticator.Authenticate(req.Context(), creds.username, creds.password)
+}
 
-	// 检查缓存
-	authCache.mu.RLock()
-	if entry, exists := authCache.cache[auth]; exists && time.Now().Before(entry.expireAt) {
-		authCache.mu.RUnlock()
-		if entry.valid {
-			return entry.username, entry.password, true
+// authenticate 校验请求的认证凭据，成功时返回携带用户身份的请求，
+// 失败时写入响应并返回 false
+func (p *ReverseProxy) authenticate(rw http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	auth := p.Auth
+	ip := clientIP(req)
+	creds, ok := auth.credentials(req)
+	username := ""
+	if ok {
+		username = creds.username
+	}
+
+	if auth.Guard != nil {
+		if err := auth.Guard.Check(ip, username); err != nil {
+			p.logf("http: proxy auth blocked: %s %s from %s: %v", req.Method, req.URL, req.RemoteAddr, err)
+			auth.Guard.reject(rw, req, err)
+			return nil, false
 		}
-		return "", "", false
 	}
-	authCache.mu.RUnlock()
 
-	// 解析认证信息
-	username, password, ok = parseBasicAuth(auth)
+	if !ok {
+		auth.challenge(rw, false)
+		return nil, false
+	}
 
-	// 缓存结果
-	if ok {
-		cacheAuthResult(auth, username, password, true)
-	} else {
-		cacheAuthResult(auth, "", "", false)
+	id, err := auth.verify(req, creds)
+	if err != nil {
+		if errors.Is(err, errStaleNonce) {
+			auth.challenge(rw, true)
+			return nil, false
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			p.logf("http: proxy auth error: %v", err)
+			http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+			return nil, false
+		}
+		if auth.Guard != nil {
+			auth.Guard.Failure(ip, username)
+		}
+		auth.challenge(rw, false)
+		return nil, false
 	}
 
-	return username, password, ok
+	if auth.Guard != nil {
+		auth.Guard.Success(ip, username)
+	}
+	// 凭据校验通过后才移除，之后的处理环节和上游服务器都看不到凭据
+	req.Header.Del("Proxy-Authorization")
+	return req.WithContext(WithIdentity(req.Context(), id)), true
+}
+
+// GetBasicAuth 从请求的 Proxy-Authorization 头中提取基本认证信息
+func GetBasicAuth(r *http.Request) (username, password string, ok bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return "", "", false
+	}
+	return parseBasicAuth(auth)
 }
 
 // BasicAuth 生成基本认证字符串
@@ -102,19 +243,6 @@ func isValidCredentials(username, password string) bool {
 		!strings.ContainsAny(password, "\x00\n\r")
 }
 
-// cacheAuthResult 缓存认证结果
-func cacheAuthResult(auth, username, password string, valid bool) {
-	authCache.mu.Lock()
-	defer authCache.mu.Unlock()
-
-	authCache.cache[auth] = authEntry{
-		valid:    valid,
-		username: username,
-		password: password,
-		expireAt: time.Now().Add(cacheDuration),
-	}
-}
-
 // CompareCredentials 安全地比较用户名和密码
 func CompareCredentials(inputUser, inputPass, expectedUser, expectedPass string) bool {
 	// 使用 subtle.ConstantTimeCompare 来防止时序攻击
@@ -122,23 +250,3 @@ func CompareCredentials(inputUser, inputPass, expectedUser, expectedPass string)
 	passMatch := subtle.ConstantTimeCompare([]byte(inputPass), []byte(expectedPass)) == 1
 	return userMatch && passMatch
 }
-
-// InitAuthCache 初始化认证缓存并启动清理任务
-func InitAuthCache() {
-	// 启动定期清理过期缓存的goroutine
-	go func() {
-		ticker := time.NewTicker(time.Minute)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			now := time.Now()
-			authCache.mu.Lock()
-			for k, v := range authCache.cache {
-				if now.After(v.expireAt) {
-					delete(authCache.cache, k)
-				}
-			}
-			authCache.mu.Unlock()
-		}
-	}()
-}
@@ -0,0 +1,91 @@
+package http_proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGetBasicAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		user   string
+		pass   string
+		ok     bool
+	}{
+		{"valid", "Basic " + BasicAuth("alice", "secret"), "alice", "secret", true},
+		{"password with colon", "Basic " + BasicAuth("alice", "a:b"), "alice", "a:b", true},
+		{"lower case scheme", "basic " + BasicAuth("alice", "secret"), "alice", "secret", true},
+		{"empty", "", "", "", false},
+		{"bearer", "Bearer token", "", "", false},
+		{"invalid base64", "Basic !!!", "", "", false},
+		{"empty username", "Basic " + BasicAuth("", "secret"), "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			if tt.header != "" {
+				req.Header.Set("Proxy-Authorization", tt.header)
+			}
+			user, pass, ok := GetBasicAuth(req)
+			if user != tt.user || pass != tt.pass || ok != tt.ok {
+				t.Errorf("GetBasicAuth() = %q, %q, %v, want %q, %q, %v", user, pass, ok, tt.user, tt.pass, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReverseProxy_ProxyAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "authorization=%q", r.Header.Get("Proxy-Authorization"))
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	newProxy := func() *ReverseProxy {
+		proxy := NewReverseProxy(target)
+		proxy.Auth = &ProxyAuth{
+			Realm:         "corp proxy",
+			Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"},
+		}
+		return proxy
+	}
+
+	for _, method := range []string{"GET", http.MethodConnect} {
+		t.Run(method+" challenge", func(t *testing.T) {
+			req := httptest.NewRequest(method, backend.URL, nil)
+			req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth("alice", "wrong"))
+			w := httptest.NewRecorder()
+			newProxy().ServeHTTP(w, req)
+
+			if w.Code != http.StatusProxyAuthRequired {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+			}
+			want := `Basic realm="corp proxy", charset="UTF-8"`
+			if got := w.Header().Get("Proxy-Authenticate"); got != want {
+				t.Errorf("Proxy-Authenticate = %q, want %q", got, want)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != "" {
+				t.Errorf("unexpected WWW-Authenticate: %q", got)
+			}
+		})
+	}
+
+	t.Run("credentials not forwarded", func(t *testing.T) {
+		req := httptest.NewRequest("GET", backend.URL, nil)
+		req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth("alice", "secret"))
+		w := httptest.NewRecorder()
+		newProxy().ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Body.String(); got != `authorization=""` {
+			t.Errorf("backend received %s", got)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package http_proxy
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// 身份缓存的默认参数
+const (
+	DefaultIdentityCacheSize = 1000
+	DefaultIdentityCacheTTL  = 5 * time.Minute
+)
+
+type identityCacheKey [sha256.Size]byte
+
+type identityCacheEntry struct {
+	key      identityCacheKey
+	id       *Identity
+	expireAt time.Time
+}
+
+// IdentityCache 缓存认证成功的用户身份，避免每个请求都访问认证后端
+//
+// 缓存只保存认证成功的结果，键是用户名和密码的 HMAC-SHA256 摘要，
+// HMAC 密钥在创建缓存时随机生成，内存中不保存明文密码。
+// 条目数超过 Size 时淘汰最久未使用的条目。
+type IdentityCache struct {
+	// Size 最多缓存的身份数
+	Size int
+	// TTL 缓存有效期
+	TTL time.Duration
+
+	mu     sync.Mutex
+	secret []byte
+	ll     *list.List
+	items  map[identityCacheKey]*list.Element
+}
+
+// NewIdentityCache 创建身份缓存，size 或 ttl 不大于 0 时使用默认值
+func NewIdentityCache(size int, ttl time.Duration) *IdentityCache {
+	if size <= 0 {
+		size = DefaultIdentityCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdentityCacheTTL
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("http_proxy: cannot generate identity cache key: " + err.Error())
+	}
+	return &IdentityCache{
+		Size:   size,
+		TTL:    ttl,
+		secret: secret,
+		ll:     list.New(),
+		items:  make(map[identityCacheKey]*list.Element),
+	}
+}
+
+// key 计算凭据的缓存键
+func (c *IdentityCache) key(username, password string) identityCacheKey {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	var k identityCacheKey
+	copy(k[:], mac.Sum(nil))
+	return k
+}
+
+// Get 查找凭据对应的已认证身份
+func (c *IdentityCache) Get(username, password string) (*Identity, bool) {
+	k := c.key(username, password)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*identityCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.id, true
+}
+
+// Add 缓存认证成功的身份
+func (c *IdentityCache) Add(username, password string, id *Identity) {
+	k := c.key(username, password)
+	expireAt := time.Now().Add(c.TTL)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[k]; ok {
+		entry := el.Value.(*identityCacheEntry)
+		entry.id, entry.expireAt = id, expireAt
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[k] = c.ll.PushFront(&identityCacheEntry{key: k, id: id, expireAt: expireAt})
+	for c.ll.Len() > c.Size {
+		c.remove(c.ll.Back())
+	}
+}
+
+// remove 删除条目，调用方需持有锁
+func (c *IdentityCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*identityCacheEntry).key)
+}
+
+// Purge 清空缓存，在凭据变更后调用
+func (c *IdentityCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[identityCacheKey]*list.Element)
+}
+
+// Len 返回缓存的条目数
+func (c *IdentityCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// removeExpired 删除所有过期条目
+func (c *IdentityCache) removeExpired() {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if now.After(el.Value.(*identityCacheEntry).expireAt) {
+			c.remove(el)
+		}
+		el = prev
+	}
+}
+
+// Run 定期清理过期条目，直到 ctx 结束
+func (c *IdentityCache) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.Purge()
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}
+
+// CachedAuthenticator 在 Authenticator 之前查询身份缓存，只缓存认证成功的结果
+type CachedAuthenticator struct {
+	Authenticator Authenticator
+	Cache         *IdentityCache
+}
+
+// Authenticate 实现 Authenticator
+func (a *CachedAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	if id, ok := a.Cache.Get(username, password); ok {
+		return id, nil
+	}
+	id, err := a.Authenticator.Authenticate(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	a.Cache.Add(username, password, id)
+	return id, nil
+}
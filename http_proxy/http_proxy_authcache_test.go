@@ -0,0 +1,142 @@
+package http_proxy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingAuthenticator 记录调用次数
+type countingAuthenticator struct {
+	Authenticator
+	calls int
+}
+
+func (a *countingAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	a.calls++
+	return a.Authenticator.Authenticate(ctx, username, password)
+}
+
+func TestIdentityCache(t *testing.T) {
+	t.Run("lru eviction", func(t *testing.T) {
+		c := NewIdentityCache(2, time.Minute)
+		c.Add("a", "1", &Identity{Username: "a"})
+		c.Add("b", "2", &Identity{Username: "b"})
+		c.Get("a", "1") // a 成为最近使用
+		c.Add("c", "3", &Identity{Username: "c"})
+
+		if _, ok := c.Get("b", "2"); ok {
+			t.Error("expected least recently used entry to be evicted")
+		}
+		if _, ok := c.Get("a", "1"); !ok {
+			t.Error("expected recently used entry to be kept")
+		}
+		if c.Len() != 2 {
+			t.Errorf("Len() = %d, want 2", c.Len())
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := NewIdentityCache(10, 10*time.Millisecond)
+		c.Add("a", "1", &Identity{Username: "a"})
+		time.Sleep(20 * time.Millisecond)
+		if _, ok := c.Get("a", "1"); ok {
+			t.Error("expected expired entry to be missed")
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		c := NewIdentityCache(10, time.Minute)
+		c.Add("a", "1", &Identity{Username: "a"})
+		if _, ok := c.Get("a", "2"); ok {
+			t.Error("expected cache miss for different password")
+		}
+	})
+}
+
+func TestCachedAuthenticator(t *testing.T) {
+	backend := &countingAuthenticator{Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"}}
+	auth := &CachedAuthenticator{Authenticator: backend, Cache: NewIdentityCache(10, time.Minute)}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := auth.Authenticate(ctx, "alice", "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.calls != 1 {
+		t.Errorf("backend calls = %d, want 1", backend.calls)
+	}
+
+	// 失败结果不缓存
+	for i := 0; i < 2; i++ {
+		if _, err := auth.Authenticate(ctx, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+	if backend.calls != 3 {
+		t.Errorf("backend calls = %d, want 3", backend.calls)
+	}
+}
+
+func TestFileAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	content := "# users\nalice:secret\nbob:{SHA256}2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewFileAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewIdentityCache(10, time.Minute)
+	a.OnReload = cache.Purge
+	auth := &CachedAuthenticator{Authenticator: a, Cache: cache}
+	ctx := context.Background()
+
+	tests := []struct {
+		user, pass string
+		want       bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "secret", true},
+		{"bob", "{SHA256}2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", false},
+		{"carol", "", false},
+	}
+	for _, tt := range tests {
+		_, err := auth.Authenticate(ctx, tt.user, tt.pass)
+		if (err == nil) != tt.want {
+			t.Errorf("Authenticate(%s, %s) error = %v, want success %v", tt.user, tt.pass, err, tt.want)
+		}
+	}
+
+	// 修改密码后重新加载，缓存的身份失效
+	if err := os.WriteFile(path, []byte("alice:changed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(ctx, "alice", "secret"); err == nil {
+		t.Error("expected old password to be rejected after reload")
+	}
+	if _, err := auth.Authenticate(ctx, "alice", "changed"); err != nil {
+		t.Errorf("unexpected error for new password: %v", err)
+	}
+
+	// 格式错误时保留原有用户
+	if err := os.WriteFile(path, []byte("invalid line\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Load(); err == nil {
+		t.Error("expected error for invalid file")
+	}
+	if a.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", a.Len())
+	}
+}
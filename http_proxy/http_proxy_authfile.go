@@ -0,0 +1,125 @@
+package http_proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sha256Prefix 是认证文件中 SHA-256 密码摘要的前缀
+const sha256Prefix = "{SHA256}"
+
+// FileAuthenticator 从认证文件读取用户名和密码
+//
+// 文件每行一个用户，格式为 username:password，# 开头的行为注释。
+// 密码可以是明文，也可以是 {SHA256} 加十六进制的 SHA-256 摘要：
+//
+//	alice:secret
+//	bob:{SHA256}2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b
+type FileAuthenticator struct {
+	// Path 认证文件路径
+	Path string
+
+	// OnReload 在认证文件重新加载后调用，用于清空身份缓存
+	OnReload func()
+
+	mu      sync.RWMutex
+	users   map[string]string
+	modTime time.Time
+}
+
+// NewFileAuthenticator 创建 FileAuthenticator 并加载认证文件
+func NewFileAuthenticator(path string) (*FileAuthenticator, error) {
+	a := &FileAuthenticator{Path: path}
+	if err := a.Load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Load 重新加载认证文件，加载失败时保留原有用户
+func (a *FileAuthenticator) Load() error {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return fmt.Errorf("auth file: %w", err)
+	}
+	defer f.Close()
+
+	var modTime time.Time
+	if info, err := f.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		username, password, ok := strings.Cut(line, ":")
+		if !ok || !isValidCredentials(username, password) {
+			return fmt.Errorf("auth file: %s:%d: invalid entry", a.Path, lineNo)
+		}
+		users[username] = password
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("auth file: %w", err)
+	}
+
+	a.mu.Lock()
+	a.users, a.modTime = users, modTime
+	a.mu.Unlock()
+
+	if a.OnReload != nil {
+		a.OnReload()
+	}
+	return nil
+}
+
+// Len 返回用户数
+func (a *FileAuthenticator) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.users)
+}
+
+// Authenticate 实现 Authenticator
+func (a *FileAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	a.mu.RLock()
+	expected, ok := a.users[username]
+	a.mu.RUnlock()
+
+	if !checkPassword(password, expected) || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: username}, nil
+}
+
+// checkPassword 比较密码，expected 可以是明文或 {SHA256} 摘要
+func checkPassword(password, expected string) bool {
+	if digest, ok := strings.CutPrefix(expected, sha256Prefix); ok {
+		sum := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(digest))) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+// changed 判断认证文件自上次加载后是否被修改
+func (a *FileAuthenticator) changed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return fileModified(a.Path, a.modTime)
+}
+
+// Run 每隔 interval 检查认证文件，有修改时重新加载，直到 ctx 结束
+func (a *FileAuthenticator) Run(ctx context.Context, interval time.Duration) {
+	runReload(ctx, interval, a, "auth file", "users")
+}
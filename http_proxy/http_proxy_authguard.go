@@ -0,0 +1,260 @@
+package http_proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 认证失败记录的范围
+const (
+	AuthGuardIP   = "ip"
+	AuthGuardUser = "user"
+)
+
+const authGuardSweepInterval = time.Minute
+
+// AuthBlockedError 表示客户端IP或用户名因认证失败次数过多而被暂时拒绝
+type AuthBlockedError struct {
+	// Key 被拒绝的记录，如 "ip:10.0.0.1" 或 "user:alice"
+	Key string
+	// Until 解除拒绝的时间
+	Until time.Time
+	// Banned 为 true 表示已被封禁，否则处于退避等待中
+	Banned bool
+}
+
+func (e *AuthBlockedError) Error() string {
+	if e.Banned {
+		return fmt.Sprintf("%s banned until %s", e.Key, e.Until.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s backing off until %s", e.Key, e.Until.Format(time.RFC3339))
+}
+
+// AuthBan 是一条封禁记录
+type AuthBan struct {
+	Key      string    `json:"key"`
+	Scope    string    `json:"scope"`
+	Value    string    `json:"value"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// authFailures 记录一个IP或用户名的连续认证失败
+type authFailures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+	banned       bool
+}
+
+// AuthGuard 按客户端IP和用户名统计认证失败次数，防止暴力破解
+//
+// 每次失败后需要等待的时间从 BaseDelay 开始翻倍，最多为 MaxDelay，
+// 等待期间的认证请求直接被拒绝；连续失败达到 MaxFailures 次后封禁 BanDuration。
+type AuthGuard struct {
+	// MaxFailures 封禁前允许的连续失败次数
+	MaxFailures int
+	// BaseDelay 第一次失败后的等待时间，0 表示不退避
+	BaseDelay time.Duration
+	// MaxDelay 退避等待时间的上限
+	MaxDelay time.Duration
+	// BanDuration 封禁时长
+	BanDuration time.Duration
+	// FailureWindow 失败记录的保留时间，超过该时间没有再次失败则重新计数
+	FailureWindow time.Duration
+
+	// AuditLog 记录认证失败和封禁事件，为 nil 时使用标准日志
+	AuditLog *log.Logger
+
+	mu        sync.Mutex
+	entries   map[string]*authFailures
+	lastSweep time.Time
+}
+
+// NewAuthGuard 使用默认参数创建 AuthGuard：
+// 失败后等待 1s 起翻倍，最多 1m，连续失败 10 次封禁 15m
+func NewAuthGuard() *AuthGuard {
+	return &AuthGuard{
+		MaxFailures:   10,
+		BaseDelay:     time.Second,
+		MaxDelay:      time.Minute,
+		BanDuration:   15 * time.Minute,
+		FailureWindow: 15 * time.Minute,
+	}
+}
+
+func (g *AuthGuard) audit(format string, args ...interface{}) {
+	if g.AuditLog != nil {
+		g.AuditLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// guardKeys 返回IP和用户名对应的记录键
+func guardKeys(ip, username string) []string {
+	keys := []string{AuthGuardIP + ":" + ip}
+	if username != "" {
+		keys = append(keys, AuthGuardUser+":"+username)
+	}
+	return keys
+}
+
+// Check 检查IP和用户名当前是否允许尝试认证，被拒绝时返回 *AuthBlockedError
+func (g *AuthGuard) Check(ip, username string) error {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, key := range guardKeys(ip, username) {
+		e := g.entries[key]
+		if e != nil && now.Before(e.blockedUntil) {
+			return &AuthBlockedError{Key: key, Until: e.blockedUntil, Banned: e.banned}
+		}
+	}
+	return nil
+}
+
+// Failure 记录一次认证失败
+func (g *AuthGuard) Failure(ip, username string) {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.entries == nil {
+		g.entries = make(map[string]*authFailures)
+		g.lastSweep = now
+	}
+	if now.Sub(g.lastSweep) > authGuardSweepInterval {
+		g.sweep(now)
+	}
+
+	g.audit("auth: failure ip=%s user=%q", ip, username)
+	for _, key := range guardKeys(ip, username) {
+		e := g.entries[key]
+		if e == nil || g.expired(e, now) {
+			e = &authFailures{}
+			g.entries[key] = e
+		}
+		e.count++
+		e.last = now
+
+		if g.MaxFailures > 0 && e.count >= g.MaxFailures {
+			e.banned = true
+			e.blockedUntil = now.Add(g.BanDuration)
+			g.audit("auth: banned %s until %s after %d failures", key, e.blockedUntil.Format(time.RFC3339), e.count)
+			continue
+		}
+		if g.BaseDelay > 0 {
+			e.blockedUntil = now.Add(g.backoff(e.count))
+		}
+	}
+}
+
+// backoff 返回第 n 次失败后的等待时间
+func (g *AuthGuard) backoff(n int) time.Duration {
+	delay := g.BaseDelay
+	for i := 1; i < n; i++ {
+		delay *= 2
+		if g.MaxDelay > 0 && delay >= g.MaxDelay {
+			return g.MaxDelay
+		}
+	}
+	if g.MaxDelay > 0 && delay > g.MaxDelay {
+		return g.MaxDelay
+	}
+	return delay
+}
+
+// Success 记录一次认证成功，清除该用户名的失败记录
+// IP的失败记录不清除，防止攻击者用一个有效账号重置计数后继续猜测其他账号
+func (g *AuthGuard) Success(ip, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, AuthGuardUser+":"+username)
+}
+
+// expired 判断失败记录是否已过期，调用方需持有锁
+func (g *AuthGuard) expired(e *authFailures, now time.Time) bool {
+	return !now.Before(e.blockedUntil) && now.Sub(e.last) > g.FailureWindow
+}
+
+// sweep 删除过期的失败记录，调用方需持有锁
+func (g *AuthGuard) sweep(now time.Time) {
+	for key, e := range g.entries {
+		if g.expired(e, now) {
+			delete(g.entries, key)
+		}
+	}
+	g.lastSweep = now
+}
+
+// Bans 返回当前生效的封禁记录，按解除时间排序
+func (g *AuthGuard) Bans() []AuthBan {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	bans := make([]AuthBan, 0)
+	for key, e := range g.entries {
+		if !e.banned || !now.Before(e.blockedUntil) {
+			continue
+		}
+		scope, value, _ := strings.Cut(key, ":")
+		bans = append(bans, AuthBan{Key: key, Scope: scope, Value: value, Failures: e.count, Until: e.blockedUntil})
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// Unban 解除封禁并清除失败记录，key 形如 "ip:10.0.0.1" 或 "user:alice"
+func (g *AuthGuard) Unban(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.entries[key]; !ok {
+		return false
+	}
+	delete(g.entries, key)
+	g.audit("auth: unbanned %s", key)
+	return true
+}
+
+// Clear 清除所有封禁和失败记录，返回清除的封禁数
+func (g *AuthGuard) Clear() int {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := 0
+	for _, e := range g.entries {
+		if e.banned && now.Before(e.blockedUntil) {
+			n++
+		}
+	}
+	g.entries = nil
+	g.audit("auth: cleared %d bans", n)
+	return n
+}
+
+// reject 拒绝被封禁或处于退避等待中的客户端
+func (g *AuthGuard) reject(rw http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusTooManyRequests
+	if blocked, ok := err.(*AuthBlockedError); ok {
+		retry := int(time.Until(blocked.Until).Seconds()) + 1
+		rw.Header().Set("Retry-After", strconv.Itoa(retry))
+		if blocked.Banned {
+			status = http.StatusForbidden
+		}
+	}
+	if req.Method == http.MethodConnect {
+		rw.Header().Set("Connection", "close")
+	}
+	http.Error(rw, http.StatusText(status), status)
+}
@@ -0,0 +1,139 @@
+package http_proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthGuard_Backoff(t *testing.T) {
+	g := &AuthGuard{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := g.backoff(tt.n); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestAuthGuard_Ban(t *testing.T) {
+	g := &AuthGuard{
+		MaxFailures:   3,
+		BaseDelay:     time.Millisecond,
+		MaxDelay:      time.Millisecond,
+		BanDuration:   time.Hour,
+		FailureWindow: time.Hour,
+		AuditLog:      log.New(io.Discard, "", 0),
+	}
+
+	g.Failure("10.0.0.1", "alice")
+	var blocked *AuthBlockedError
+	if err := g.Check("10.0.0.1", ""); !errors.As(err, &blocked) || blocked.Banned {
+		t.Fatalf("expected back-off after first failure, got %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := g.Check("10.0.0.1", "alice"); err != nil {
+		t.Fatalf("expected back-off to expire, got %v", err)
+	}
+
+	g.Failure("10.0.0.1", "alice")
+	g.Failure("10.0.0.2", "alice")
+	time.Sleep(5 * time.Millisecond)
+
+	// 用户名达到失败次数被封禁，其他IP使用该用户名同样被拒绝
+	if err := g.Check("10.0.0.3", "alice"); !errors.As(err, &blocked) || !blocked.Banned || blocked.Key != "user:alice" {
+		t.Fatalf("expected user ban, got %v", err)
+	}
+	// 10.0.0.1 只失败了两次
+	if err := g.Check("10.0.0.1", "bob"); err != nil {
+		t.Errorf("unexpected error for ip: %v", err)
+	}
+
+	bans := g.Bans()
+	if len(bans) != 1 || bans[0].Scope != AuthGuardUser || bans[0].Value != "alice" || bans[0].Failures != 3 {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+
+	if !g.Unban("user:alice") {
+		t.Fatal("Unban returned false")
+	}
+	if err := g.Check("10.0.0.3", "alice"); err != nil {
+		t.Errorf("unexpected error after unban: %v", err)
+	}
+	if g.Unban("user:alice") {
+		t.Error("expected second Unban to return false")
+	}
+}
+
+func TestAuthGuard_Success(t *testing.T) {
+	g := &AuthGuard{MaxFailures: 2, BanDuration: time.Hour, FailureWindow: time.Hour, AuditLog: log.New(io.Discard, "", 0)}
+
+	g.Failure("10.0.0.1", "alice")
+	g.Success("10.0.0.1", "alice")
+	g.Failure("10.0.0.2", "alice")
+	if err := g.Check("10.0.0.3", "alice"); err != nil {
+		t.Errorf("expected success to reset user failures, got %v", err)
+	}
+
+	// 成功不清除IP的失败记录
+	g.Failure("10.0.0.1", "bob")
+	if err := g.Check("10.0.0.1", ""); err == nil {
+		t.Error("expected ip ban")
+	}
+	if n := g.Clear(); n != 1 {
+		t.Errorf("Clear() = %d, want 1", n)
+	}
+	if err := g.Check("10.0.0.1", ""); err != nil {
+		t.Errorf("unexpected error after clear: %v", err)
+	}
+}
+
+func TestReverseProxy_AuthGuard(t *testing.T) {
+	guard := &AuthGuard{MaxFailures: 2, BanDuration: time.Hour, FailureWindow: time.Hour, AuditLog: log.New(io.Discard, "", 0)}
+	proxy := &ReverseProxy{Auth: &ProxyAuth{
+		Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"},
+		Guard:         guard,
+	}}
+
+	serve := func(user, pass string) int {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if user != "" {
+			req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth(user, pass))
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 没有提供凭据不计为失败
+	for i := 0; i < 3; i++ {
+		if code := serve("", ""); code != http.StatusProxyAuthRequired {
+			t.Fatalf("status = %d, want %d", code, http.StatusProxyAuthRequired)
+		}
+	}
+	if code := serve("alice", "wrong1"); code != http.StatusProxyAuthRequired {
+		t.Fatalf("status = %d, want %d", code, http.StatusProxyAuthRequired)
+	}
+	if code := serve("mallory", "wrong2"); code != http.StatusProxyAuthRequired {
+		t.Fatalf("status = %d, want %d", code, http.StatusProxyAuthRequired)
+	}
+	// 封禁后即使凭据正确也被拒绝
+	if code := serve("alice", "secret"); code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,272 @@
+package http_proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minBandwidthChunk 限速读取时单次读取的最小字节数
+const minBandwidthChunk = 4 * 1024
+
+// BandwidthLimit 指定上传和下载的带宽上限（字节/秒），0 表示不限制
+type BandwidthLimit struct {
+	Upload   int64
+	Download int64
+}
+
+// BandwidthRule 按目标主机限制带宽，所有匹配该规则的流量共享带宽
+type BandwidthRule struct {
+	// Pattern 目标主机名的匹配模式，支持 path.Match 通配符，如 *.example.com
+	Pattern string
+	BandwidthLimit
+}
+
+// sharedBucket 是可在多个连接之间共享的令牌桶，用于带宽限制
+type sharedBucket struct {
+	mu     sync.Mutex
+	bucket *tokenBucket
+
+	// refs 正在使用该令牌桶的读取器个数，由 BandwidthLimiter.mu 保护
+	refs int
+}
+
+func newSharedBucket(bytesPerSecond int64) *sharedBucket {
+	return &sharedBucket{bucket: newTokenBucket(float64(bytesPerSecond), int(bytesPerSecond), time.Now())}
+}
+
+// reserve 预留 n 个令牌，返回需要等待的时间
+// 令牌不足时允许透支，后续调用者会等待更长时间，从而在连接之间公平分配带宽
+func (b *sharedBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.bucket.refill(now)
+	b.bucket.tokens -= float64(n)
+	if b.bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.bucket.tokens / b.bucket.rate * float64(time.Second))
+}
+
+func (b *sharedBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bucket.full(now)
+}
+
+// BandwidthLimiter 对隧道和普通响应的数据复制进行带宽整形
+type BandwidthLimiter struct {
+	// Global 全局带宽上限，所有流量共享
+	Global BandwidthLimit
+
+	// PerUser 每个用户的默认带宽上限，未认证的请求按客户端IP计算
+	PerUser BandwidthLimit
+
+	// Users 为指定用户覆盖 PerUser
+	Users map[string]BandwidthLimit
+
+	// Rules 按目标主机限制带宽，使用第一条匹配的规则
+	Rules []BandwidthRule
+
+	once      sync.Once
+	globalUp  *sharedBucket
+	globalDn  *sharedBucket
+	ruleUp    []*sharedBucket
+	ruleDn    []*sharedBucket
+	mu        sync.Mutex
+	usersUp   map[string]*sharedBucket
+	usersDn   map[string]*sharedBucket
+	lastSweep time.Time
+}
+
+func (l *BandwidthLimiter) init() {
+	l.once.Do(func() {
+		if l.Global.Upload > 0 {
+			l.globalUp = newSharedBucket(l.Global.Upload)
+		}
+		if l.Global.Download > 0 {
+			l.globalDn = newSharedBucket(l.Global.Download)
+		}
+		l.ruleUp = make([]*sharedBucket, len(l.Rules))
+		l.ruleDn = make([]*sharedBucket, len(l.Rules))
+		for i, r := range l.Rules {
+			if r.Upload > 0 {
+				l.ruleUp[i] = newSharedBucket(r.Upload)
+			}
+			if r.Download > 0 {
+				l.ruleDn[i] = newSharedBucket(r.Download)
+			}
+		}
+		l.usersUp = make(map[string]*sharedBucket)
+		l.usersDn = make(map[string]*sharedBucket)
+		l.lastSweep = time.Now()
+	})
+}
+
+// buckets 返回请求适用的上传和下载令牌桶，用户令牌桶的引用计数加一，
+// 使用完毕后需要调用 release，否则该令牌桶不会被清理
+func (l *BandwidthLimiter) buckets(req *http.Request) (up, down []*sharedBucket) {
+	l.init()
+
+	if l.globalUp != nil {
+		up = append(up, l.globalUp)
+	}
+	if l.globalDn != nil {
+		down = append(down, l.globalDn)
+	}
+
+	host := strings.ToLower(requestHostname(req))
+	for i, r := range l.Rules {
+		if matched, _ := path.Match(strings.ToLower(r.Pattern), host); matched {
+			if l.ruleUp[i] != nil {
+				up = append(up, l.ruleUp[i])
+			}
+			if l.ruleDn[i] != nil {
+				down = append(down, l.ruleDn[i])
+			}
+			break
+		}
+	}
+
+	key, limit := l.userLimit(req)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	if limit.Upload > 0 {
+		b, ok := l.usersUp[key]
+		if !ok {
+			b = newSharedBucket(limit.Upload)
+			l.usersUp[key] = b
+		}
+		b.refs++
+		up = append(up, b)
+	}
+	if limit.Download > 0 {
+		b, ok := l.usersDn[key]
+		if !ok {
+			b = newSharedBucket(limit.Download)
+			l.usersDn[key] = b
+		}
+		b.refs++
+		down = append(down, b)
+	}
+	return up, down
+}
+
+// userLimit 返回请求对应的用户键和带宽上限
+func (l *BandwidthLimiter) userLimit(req *http.Request) (string, BandwidthLimit) {
+	if id, ok := IdentityFromContext(req.Context()); ok && id.Username != "" {
+		if limit, ok := l.Users[id.Username]; ok {
+			return "user:" + id.Username, limit
+		}
+		return "user:" + id.Username, l.PerUser
+	}
+	return "ip:" + clientIP(req), l.PerUser
+}
+
+// release 减少用户令牌桶的引用计数
+func (l *BandwidthLimiter) release(buckets []*sharedBucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range buckets {
+		if b.refs > 0 {
+			b.refs--
+		}
+	}
+}
+
+// sweep 删除没有读取器使用且已补满的用户令牌桶，调用方需持有锁；
+// 空闲隧道仍在使用的令牌桶是满的，删除后同一用户的新连接会得到新的令牌桶，使总带宽超过上限
+func (l *BandwidthLimiter) sweep(now time.Time) {
+	for _, buckets := range []map[string]*sharedBucket{l.usersUp, l.usersDn} {
+		for k, b := range buckets {
+			if b.refs == 0 && b.full(now) {
+				delete(buckets, k)
+			}
+		}
+	}
+	l.lastSweep = now
+}
+
+// limitReader 返回按上传或下载带宽限速的 Reader，
+// 读取结束或 ctx 结束时释放对用户令牌桶的引用
+func (l *BandwidthLimiter) limitReader(ctx context.Context, req *http.Request, r io.Reader, upload bool) io.Reader {
+	up, down := l.buckets(req)
+	buckets, unused := down, up
+	if upload {
+		buckets, unused = up, down
+	}
+	l.release(unused)
+	if len(buckets) == 0 {
+		return r
+	}
+
+	// 单次读取不超过最小的桶容量，使流量更平滑
+	chunk := 0
+	for _, b := range buckets {
+		if burst := int(b.bucket.burst); chunk == 0 || burst < chunk {
+			chunk = burst
+		}
+	}
+	if chunk < minBandwidthChunk {
+		chunk = minBandwidthChunk
+	}
+
+	br := &bandwidthReader{ctx: ctx, r: r, buckets: buckets, chunk: chunk}
+	br.release = sync.OnceFunc(func() { l.release(buckets) })
+	// 客户端中止时可能不会再读取到错误，请求结束时同样释放
+	context.AfterFunc(ctx, br.release)
+	return br
+}
+
+// bandwidthReader 按令牌桶限速读取数据
+type bandwidthReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*sharedBucket
+	chunk   int
+	release func()
+}
+
+func (br *bandwidthReader) Read(p []byte) (int, error) {
+	if len(p) > br.chunk {
+		p = p[:br.chunk]
+	}
+	n, err := br.r.Read(p)
+	if err != nil {
+		br.release()
+	}
+	if n <= 0 {
+		return n, err
+	}
+
+	var wait time.Duration
+	for _, b := range br.buckets {
+		if d := b.reserve(n); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-br.ctx.Done():
+			t.Stop()
+			return n, br.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// bandwidthReadCloser 为请求体保留 Close 方法
+type bandwidthReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,135 @@
+package http_proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseProxy_BandwidthLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 96*1024)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewReverseProxy(backendURL)
+	proxy.BandwidthLimiter = &BandwidthLimiter{
+		Global: BandwidthLimit{Download: 64 * 1024},
+	}
+
+	// 桶容量为64KB，剩余32KB需要等待约0.5秒
+	start := time.Now()
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	elapsed := time.Since(start)
+
+	if w.Body.Len() != len(payload) {
+		t.Fatalf("body length = %d, want %d", w.Body.Len(), len(payload))
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("download took %v, expected bandwidth limit to slow it down", elapsed)
+	}
+}
+
+func TestBandwidthLimiter_Buckets(t *testing.T) {
+	l := &BandwidthLimiter{
+		PerUser: BandwidthLimit{Upload: 1024, Download: 1024},
+		Users:   map[string]BandwidthLimit{"vip": {}},
+		Rules: []BandwidthRule{
+			{Pattern: "*.example.com", BandwidthLimit: BandwidthLimit{Download: 2048}},
+		},
+	}
+
+	newReq := func(target, user string) *http.Request {
+		req := httptest.NewRequest("GET", target, nil)
+		return req.WithContext(WithIdentity(req.Context(), &Identity{Username: user}))
+	}
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		wantUp   int
+		wantDown int
+	}{
+		{"default user", newReq("http://other.org/", "alice"), 1, 1},
+		{"matching host", newReq("http://cdn.example.com/", "alice"), 1, 2},
+		{"unlimited user", newReq("http://other.org/", "vip"), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down := l.buckets(tt.req)
+			if len(up) != tt.wantUp || len(down) != tt.wantDown {
+				t.Errorf("buckets = %d/%d, want %d/%d", len(up), len(down), tt.wantUp, tt.wantDown)
+			}
+		})
+	}
+
+	// 同一用户共享令牌桶
+	_, a := l.buckets(newReq("http://other.org/", "alice"))
+	_, b := l.buckets(newReq("http://other.org/x", "alice"))
+	if a[0] != b[0] {
+		t.Error("expected requests of the same user to share a bucket")
+	}
+}
+
+func TestBandwidthLimiter_SweepKeepsHeldBuckets(t *testing.T) {
+	l := &BandwidthLimiter{PerUser: BandwidthLimit{Upload: 1024, Download: 1024}}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req = req.WithContext(WithIdentity(req.Context(), &Identity{Username: "alice"}))
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	// 空闲隧道持有的令牌桶是满的，但不能被清理
+	r := l.limitReader(ctx, req, strings.NewReader("data"), false)
+	sweep := func() {
+		l.mu.Lock()
+		l.sweep(time.Now().Add(time.Hour))
+		l.mu.Unlock()
+	}
+	sweep()
+	if _, ok := l.usersDn["user:alice"]; !ok {
+		t.Fatal("bucket held by an open reader was swept")
+	}
+	if _, ok := l.usersUp["user:alice"]; ok {
+		t.Error("unused upload bucket was not swept")
+	}
+
+	// 读取结束后释放
+	io.ReadAll(r)
+	sweep()
+	if _, ok := l.usersDn["user:alice"]; ok {
+		t.Error("bucket was not swept after the reader finished")
+	}
+
+	// 请求结束时也会释放
+	l.limitReader(ctx, req, strings.NewReader("data"), false)
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		sweep()
+		l.mu.Lock()
+		_, ok := l.usersDn["user:alice"]
+		l.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bucket was not swept after the request ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,301 @@
+package http_proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// domainTrie 是按域名标签倒序存储的后缀树，匹配域名本身及其所有子域名
+type domainTrie struct {
+	children map[string]*domainTrie
+	// source 非空表示该节点对应的域名被列入，值为来源文件
+	source string
+}
+
+// insert 加入一个域名
+func (t *domainTrie) insert(domain, source string) {
+	node := t
+	labels := strings.Split(domain, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		if node.children == nil {
+			node.children = make(map[string]*domainTrie)
+		}
+		child, ok := node.children[labels[i]]
+		if !ok {
+			child = &domainTrie{}
+			node.children[labels[i]] = child
+		}
+		node = child
+	}
+	if node.source == "" {
+		node.source = source
+	}
+}
+
+// match 查找域名或其任一上级域名，返回来源文件
+func (t *domainTrie) match(host string) (string, bool) {
+	node := t
+	labels := strings.Split(host, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = node.children[labels[i]]
+		if node == nil {
+			return "", false
+		}
+		if node.source != "" {
+			return node.source, true
+		}
+	}
+	return "", false
+}
+
+// Blocklist 从本地文件加载域名黑名单，拦截对广告、跟踪和恶意域名的访问
+//
+// 支持以下格式，可以在同一个文件中混用：
+//
+//	0.0.0.0 ads.example.com        # hosts 文件
+//	tracker.example.com            # 每行一个域名
+//	||malware.example.com^         # Adblock Plus 域名规则
+//	@@||cdn.example.com^           # Adblock Plus 例外规则
+//
+// 列入的域名同时拦截其所有子域名。Adblock Plus 的路径规则和元素隐藏规则会被忽略。
+type Blocklist struct {
+	// Files 黑名单文件路径
+	Files []string
+
+	// BlockPage 拦截普通HTTP请求时返回的页面，为 nil 时使用内置页面，
+	// 模板数据为 BlockPageData
+	BlockPage *template.Template
+
+	mu      sync.RWMutex
+	block   *domainTrie
+	allow   *domainTrie
+	count   int
+	modTime map[string]time.Time
+}
+
+// BlockPageData 是拦截页面模板的数据
+type BlockPageData struct {
+	Host   string
+	URL    string
+	Source string
+}
+
+var defaultBlockPage = template.Must(template.New("block").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Blocked</title></head>
+<body>
+<h1>Access blocked</h1>
+<p>The domain <b>{{.Host}}</b> is blocked by the proxy.</p>
+</body>
+</html>
+`))
+
+// NewBlocklist 创建黑名单并加载文件
+func NewBlocklist(files ...string) (*Blocklist, error) {
+	b := &Blocklist{Files: files}
+	if err := b.Load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Load 重新加载所有黑名单文件，任一文件加载失败时保留原有规则
+func (b *Blocklist) Load() error {
+	block, allow := &domainTrie{}, &domainTrie{}
+	count := 0
+	modTime := make(map[string]time.Time, len(b.Files))
+
+	for _, path := range b.Files {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("blocklist: %w", err)
+		}
+		if info, err := f.Stat(); err == nil {
+			modTime[path] = info.ModTime()
+		}
+		n, err := parseBlocklist(f, path, block, allow)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("blocklist: %s: %w", path, err)
+		}
+		count += n
+	}
+
+	b.mu.Lock()
+	b.block, b.allow, b.count, b.modTime = block, allow, count, modTime
+	b.mu.Unlock()
+	return nil
+}
+
+// parseBlocklist 解析一个黑名单文件，返回加入的规则数
+func parseBlocklist(r io.Reader, source string, block, allow *domainTrie) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+
+		// Adblock Plus 规则
+		if strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@||") {
+			target := block
+			if strings.HasPrefix(line, "@@") {
+				target, line = allow, line[2:]
+			}
+			domain, ok := adblockDomain(line[2:])
+			if ok {
+				target.insert(domain, source)
+				count++
+			}
+			continue
+		}
+		// 先去掉行尾注释，注释中的字符不影响规则的判断
+		line = stripComment(line)
+		// 其他 Adblock Plus 规则（路径、元素隐藏等）无法在域名层面处理
+		if strings.ContainsAny(line, "/^$|@#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// hosts 文件格式：IP 后跟一个或多个域名
+		if net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		} else {
+			fields = fields[:1]
+		}
+		for _, field := range fields {
+			domain := normalizeDomain(strings.TrimPrefix(field, "*."))
+			if domain == "" || isLocalhostName(domain) {
+				continue
+			}
+			block.insert(domain, source)
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// stripComment 去掉行尾以空白加 # 开始的注释，
+// 元素隐藏规则（example.com##.ad）中紧跟域名的 # 不是注释
+func stripComment(line string) string {
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// adblockDomain 从 "example.com^" 形式的规则中提取域名，带路径或选项的规则返回 false
+func adblockDomain(rule string) (string, bool) {
+	end := strings.IndexAny(rule, "^/$*|")
+	if end < 0 {
+		end = len(rule)
+	}
+	rest := rule[end:]
+	if rest != "" && rest != "^" && rest != "^|" {
+		return "", false
+	}
+	domain := normalizeDomain(rule[:end])
+	return domain, domain != ""
+}
+
+// normalizeDomain 转为小写并去掉末尾的点，不是合法域名时返回空字符串
+func normalizeDomain(s string) string {
+	s = strings.TrimSuffix(strings.ToLower(s), ".")
+	if s == "" || strings.ContainsAny(s, " :/\\") || strings.Contains(s, "..") {
+		return ""
+	}
+	return s
+}
+
+func isLocalhostName(s string) bool {
+	switch s {
+	case "localhost", "localhost.localdomain", "local", "broadcasthost", "ip6-localhost", "ip6-loopback":
+		return true
+	}
+	return false
+}
+
+// Blocked 判断主机名是否被列入黑名单，返回列入该域名的文件
+func (b *Blocklist) Blocked(host string) (string, bool) {
+	host = normalizeDomain(host)
+	if host == "" {
+		return "", false
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.block == nil {
+		return "", false
+	}
+	if _, ok := b.allow.match(host); ok {
+		return "", false
+	}
+	return b.block.match(host)
+}
+
+// Len 返回已加载的规则数
+func (b *Blocklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.count
+}
+
+// changed 判断黑名单文件自上次加载后是否被修改
+func (b *Blocklist) changed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, path := range b.Files {
+		if fileModified(path, b.modTime[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run 每隔 interval 检查黑名单文件，有修改时重新加载，直到 ctx 结束
+func (b *Blocklist) Run(ctx context.Context, interval time.Duration) {
+	runReload(ctx, interval, b, "blocklist", "rules")
+}
+
+// checkBlocklist 检查请求的目标域名，被拦截时写入 403 响应并返回 false
+func (p *ReverseProxy) checkBlocklist(rw http.ResponseWriter, req *http.Request) bool {
+	host := requestHostname(req)
+	source, blocked := p.Blocklist.Blocked(host)
+	if !blocked {
+		return true
+	}
+
+	p.logf("http: proxy blocked domain: %s %s from %s (%s)", req.Method, host, req.RemoteAddr, source)
+	if req.Method == http.MethodConnect {
+		rw.Header().Set("Connection", "close")
+		http.Error(rw, "Forbidden: domain is blocked", http.StatusForbidden)
+		return false
+	}
+
+	page := p.Blocklist.BlockPage
+	if page == nil {
+		page = defaultBlockPage
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusForbidden)
+	if err := page.Execute(rw, BlockPageData{Host: host, URL: req.URL.String(), Source: source}); err != nil {
+		p.logf("http: proxy block page error: %v", err)
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package http_proxy
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBlocklist = `# hosts 文件格式
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.net
+::1 ip6-localhost
+
+# 每行一个域名
+Malware.Example.org.
+*.wild.example.com
+0.0.0.0 commented.example.com # 来源 https://example.org/hosts
+spam.example.net	# 2024-01-01 | 手动添加
+
+! Adblock Plus 格式
+[Adblock Plus 2.0]
+||doubleclick.example^
+||analytics.example.com^$third-party
+@@||good.doubleclick.example^
+example.com##.banner
+/ads/*
+`
+
+func writeBlocklist(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBlocklist_Blocked(t *testing.T) {
+	b, err := NewBlocklist(writeBlocklist(t, testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"ads.example.com", true},
+		{"cdn.ads.example.com", true}, // 子域名同样拦截
+		{"example.com", false},
+		{"badads.example.com", false},
+		{"tracker.example.net", true},
+		{"malware.example.org", true},
+		{"MALWARE.EXAMPLE.ORG.", true},
+		{"wild.example.com", true},
+		{"x.wild.example.com", true},
+		{"commented.example.com", true}, // 注释中的字符不影响规则
+		{"spam.example.net", true},
+		{"doubleclick.example", true},
+		{"good.doubleclick.example", false}, // 例外规则
+		{"analytics.example.com", false},    // 带选项的规则被忽略
+		{"localhost", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if _, got := b.Blocked(tt.host); got != tt.want {
+				t.Errorf("Blocked(%s) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlocklist_Run(t *testing.T) {
+	path := writeBlocklist(t, "ads.example.com\n")
+	b, err := NewBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, 10*time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("tracker.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 确保修改时间发生变化
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := b.Blocked("tracker.example.com"); ok {
+			if _, ok := b.Blocked("ads.example.com"); ok {
+				t.Error("expected old rules to be replaced")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("blocklist was not reloaded")
+}
+
+func TestReverseProxy_Blocklist(t *testing.T) {
+	b, err := NewBlocklist(writeBlocklist(t, "ads.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.BlockPage = template.Must(template.New("page").Parse("blocked {{.Host}}"))
+	proxy := &ReverseProxy{Blocklist: b}
+
+	t.Run("http", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://ads.example.com/banner.js", nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+		if got := w.Body.String(); got != "blocked ads.example.com" {
+			t.Errorf("body = %q", got)
+		}
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodConnect, "http://ads.example.com:443", nil)
+		req.URL.Host = "ads.example.com:443"
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+		if strings.Contains(w.Body.String(), "<html>") {
+			t.Error("expected plain text response for CONNECT")
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package http_proxy
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientPolicy 按客户端地址限制代理的使用，在认证之前检查
+//
+// 客户端地址取自 RemoteAddr，监听器启用 PROXY 协议时为 PROXY 头中的源地址。
+type ClientPolicy struct {
+	// Allow 允许使用代理的网段，为空时不限制
+	Allow []*net.IPNet
+
+	// Deny 禁止使用代理的网段，优先于 Allow
+	Deny []*net.IPNet
+
+	// AuthExempt 无需认证即可使用代理的网段，仍然需要通过 Allow 和 Deny 检查
+	AuthExempt []*net.IPNet
+}
+
+// NewClientPolicy 根据网段列表创建客户端策略
+func NewClientPolicy(allow, deny, authExempt []string) (*ClientPolicy, error) {
+	allowNets, err := ParseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := ParseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	exemptNets, err := ParseCIDRs(authExempt)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientPolicy{Allow: allowNets, Deny: denyNets, AuthExempt: exemptNets}, nil
+}
+
+// remoteIP 解析请求的客户端地址
+func remoteIP(req *http.Request) net.IP {
+	return normalizeIP(net.ParseIP(clientIP(req)))
+}
+
+// Allowed 判断是否允许该地址使用代理，无法解析的地址只在未设置 Allow 和 Deny 时允许
+func (p *ClientPolicy) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return len(p.Allow) == 0 && len(p.Deny) == 0
+	}
+	if containsIP(p.Deny, ip) {
+		return false
+	}
+	return len(p.Allow) == 0 || containsIP(p.Allow, ip)
+}
+
+// AuthExempted 判断该地址是否无需认证
+func (p *ClientPolicy) AuthExempted(ip net.IP) bool {
+	return ip != nil && containsIP(p.AuthExempt, ip)
+}
+
+// checkClient 检查客户端地址，拒绝时写入 403 响应并返回 false
+func (p *ReverseProxy) checkClient(rw http.ResponseWriter, req *http.Request) bool {
+	if p.ClientPolicy.Allowed(remoteIP(req)) {
+		return true
+	}
+	p.logf("http: proxy client denied: %s %s from %s", req.Method, req.URL.Host, req.RemoteAddr)
+	rw.Header().Set("Connection", "close")
+	http.Error(rw, "Forbidden: client address is not allowed", http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,82 @@
+package http_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientPolicy_Allowed(t *testing.T) {
+	policy, err := NewClientPolicy([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.6.6.0/24"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		allowed    bool
+		exempt     bool
+	}{
+		{"10.0.0.1:1234", true, false},
+		{"10.1.2.3:1234", true, true},
+		{"10.6.6.6:1234", false, false},
+		{"192.168.1.1:1234", false, false},
+		{"[2001:db8::1]:1234", true, false},
+		{"[::ffff:10.1.2.3]:1234", true, true},
+		{"invalid", false, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		ip := remoteIP(req)
+		if got := policy.Allowed(ip); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.remoteAddr, got, tt.allowed)
+		}
+		if got := policy.AuthExempted(ip); got != tt.exempt {
+			t.Errorf("AuthExempted(%s) = %v, want %v", tt.remoteAddr, got, tt.exempt)
+		}
+	}
+
+	if _, err := NewClientPolicy(nil, []string{"10.0.0.0/40"}, nil); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
+
+func TestReverseProxy_ClientPolicy(t *testing.T) {
+	policy, err := NewClientPolicy([]string{"10.0.0.0/8"}, nil, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 通过检查的 CONNECT 请求会被端口策略以 403 拒绝，据此区分结果
+	proxy := &ReverseProxy{
+		ClientPolicy: policy,
+		Auth:         &ProxyAuth{Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"}},
+		ConnectPorts: &PortPolicy{Ports: PortList{}},
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		auth       bool
+		want       int
+	}{
+		{"allowed without credentials", "10.0.0.1:1234", false, http.StatusProxyAuthRequired},
+		{"allowed with credentials", "10.0.0.1:1234", true, http.StatusForbidden},
+		{"exempt without credentials", "10.1.0.1:1234", false, http.StatusForbidden},
+		{"denied before authentication", "192.168.1.1:1234", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodConnect, "example.com:25", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.auth {
+				req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth("alice", "secret"))
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
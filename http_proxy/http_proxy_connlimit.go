@@ -0,0 +1,210 @@
+package http_proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 并发连接限制的范围
+const (
+	ConnLimitGlobal  = "global"
+	ConnLimitTunnels = "tunnels"
+	ConnLimitUser    = "user"
+	ConnLimitIP      = "ip"
+	ConnLimitHost    = "host"
+)
+
+// ConnLimitError 表示请求因并发连接数达到上限而被拒绝
+type ConnLimitError struct {
+	// Scope 达到上限的范围（global、tunnels、user、ip 或 host）
+	Scope string
+	Limit int
+}
+
+func (e *ConnLimitError) Error() string {
+	return fmt.Sprintf("concurrent connection limit reached: %s (%d)", e.Scope, e.Limit)
+}
+
+// StatusCode 返回拒绝请求时使用的状态码
+// 全局上限说明服务器过载，返回 503；单个用户、IP或主机的上限返回 429
+func (e *ConnLimitError) StatusCode() int {
+	if e.Scope == ConnLimitGlobal || e.Scope == ConnLimitTunnels {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusTooManyRequests
+}
+
+// ConnLimiter 限制同时进行的请求和隧道数量，防止文件描述符耗尽
+type ConnLimiter struct {
+	// Global 全局并发连接（进行中的请求和隧道）上限，0 表示不限制
+	Global int
+	// Tunnels 全局同时打开的 CONNECT 隧道上限，0 表示不限制
+	Tunnels int
+	// PerUser 每个认证用户的并发连接上限，0 表示不限制
+	PerUser int
+	// PerIP 每个客户端IP的并发连接上限，0 表示不限制
+	PerIP int
+	// PerHost 每个目标主机的并发连接上限，0 表示不限制
+	PerHost int
+
+	// QueueTimeout 达到上限时排队等待空闲名额的最长时间，0 表示立即拒绝
+	QueueTimeout time.Duration
+
+	mu      sync.Mutex
+	active  int
+	tunnels int
+	counts  map[string]int
+	waitCh  chan struct{}
+}
+
+// connSlot 是一个请求占用的名额
+type connSlot struct {
+	tunnel bool
+	keys   []string
+}
+
+// slot 返回请求需要占用的名额和对应的上限
+func (l *ConnLimiter) slot(req *http.Request) (connSlot, map[string]int) {
+	s := connSlot{tunnel: req.Method == http.MethodConnect}
+	limits := make(map[string]int, 3)
+	if l.PerUser > 0 {
+		if id, ok := IdentityFromContext(req.Context()); ok && id.Username != "" {
+			key := ConnLimitUser + ":" + id.Username
+			s.keys = append(s.keys, key)
+			limits[key] = l.PerUser
+		}
+	}
+	if l.PerIP > 0 {
+		key := ConnLimitIP + ":" + clientIP(req)
+		s.keys = append(s.keys, key)
+		limits[key] = l.PerIP
+	}
+	if l.PerHost > 0 {
+		key := ConnLimitHost + ":" + requestHostname(req)
+		s.keys = append(s.keys, key)
+		limits[key] = l.PerHost
+	}
+	return s, limits
+}
+
+// tryAcquire 尝试占用名额，失败时返回达到上限的范围，调用方需持有锁
+func (l *ConnLimiter) tryAcquire(s connSlot, limits map[string]int) *ConnLimitError {
+	if l.Global > 0 && l.active >= l.Global {
+		return &ConnLimitError{Scope: ConnLimitGlobal, Limit: l.Global}
+	}
+	if s.tunnel && l.Tunnels > 0 && l.tunnels >= l.Tunnels {
+		return &ConnLimitError{Scope: ConnLimitTunnels, Limit: l.Tunnels}
+	}
+	for _, key := range s.keys {
+		if l.counts[key] >= limits[key] {
+			scope, _, _ := strings.Cut(key, ":")
+			return &ConnLimitError{Scope: scope, Limit: limits[key]}
+		}
+	}
+
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+	l.active++
+	if s.tunnel {
+		l.tunnels++
+	}
+	for _, key := range s.keys {
+		l.counts[key]++
+	}
+	return nil
+}
+
+// release 释放名额并唤醒排队的请求
+func (l *ConnLimiter) release(s connSlot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	if s.tunnel {
+		l.tunnels--
+	}
+	for _, key := range s.keys {
+		if l.counts[key]--; l.counts[key] <= 0 {
+			delete(l.counts, key)
+		}
+	}
+	if l.waitCh != nil {
+		close(l.waitCh)
+		l.waitCh = nil
+	}
+}
+
+// Acquire 为请求占用一个并发名额，达到上限时按 QueueTimeout 排队或立即返回 *ConnLimitError
+// 成功时返回的 release 函数必须在请求结束后调用
+func (l *ConnLimiter) Acquire(ctx context.Context, req *http.Request) (release func(), err error) {
+	s, limits := l.slot(req)
+
+	var timer *time.Timer
+	l.mu.Lock()
+	for {
+		limitErr := l.tryAcquire(s, limits)
+		if limitErr == nil {
+			l.mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			var once sync.Once
+			return func() { once.Do(func() { l.release(s) }) }, nil
+		}
+		if l.QueueTimeout <= 0 {
+			l.mu.Unlock()
+			return nil, limitErr
+		}
+
+		if l.waitCh == nil {
+			l.waitCh = make(chan struct{})
+		}
+		wait := l.waitCh
+		l.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(l.QueueTimeout)
+		}
+		select {
+		case <-wait:
+		case <-timer.C:
+			return nil, limitErr
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		l.mu.Lock()
+	}
+}
+
+// Active 返回当前进行中的连接数和隧道数
+func (l *ConnLimiter) Active() (conns, tunnels int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active, l.tunnels
+}
+
+// acquireConn 为请求占用并发名额，失败时写入错误响应并返回 false
+func (p *ReverseProxy) acquireConn(rw http.ResponseWriter, req *http.Request) (func(), bool) {
+	release, err := p.ConnLimiter.Acquire(req.Context(), req)
+	if err == nil {
+		return release, true
+	}
+
+	p.logf("http: proxy connection limit: %s %s from %s: %v", req.Method, req.URL, req.RemoteAddr, err)
+	status := http.StatusServiceUnavailable
+	if limitErr, ok := err.(*ConnLimitError); ok {
+		status = limitErr.StatusCode()
+	}
+	rw.Header().Set("Retry-After", "1")
+	if req.Method == http.MethodConnect {
+		rw.Header().Set("Connection", "close")
+	}
+	http.Error(rw, http.StatusText(status), status)
+	return nil, false
+}
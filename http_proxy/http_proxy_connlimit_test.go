@@ -0,0 +1,99 @@
+package http_proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter_Acquire(t *testing.T) {
+	newReq := func(method, target, ip string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = ip + ":1234"
+		return req
+	}
+
+	t.Run("per ip", func(t *testing.T) {
+		l := &ConnLimiter{PerIP: 1}
+		release, err := l.Acquire(context.Background(), newReq("GET", "http://a.com/", "10.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = l.Acquire(context.Background(), newReq("GET", "http://b.com/", "10.0.0.1"))
+		var limitErr *ConnLimitError
+		if !errors.As(err, &limitErr) || limitErr.Scope != ConnLimitIP {
+			t.Fatalf("expected ip limit error, got %v", err)
+		}
+		if limitErr.StatusCode() != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want 429", limitErr.StatusCode())
+		}
+
+		// 其他IP不受影响
+		if _, err := l.Acquire(context.Background(), newReq("GET", "http://a.com/", "10.0.0.2")); err != nil {
+			t.Errorf("unexpected error for other ip: %v", err)
+		}
+
+		release()
+		if _, err := l.Acquire(context.Background(), newReq("GET", "http://a.com/", "10.0.0.1")); err != nil {
+			t.Errorf("unexpected error after release: %v", err)
+		}
+	})
+
+	t.Run("tunnels", func(t *testing.T) {
+		l := &ConnLimiter{Tunnels: 1}
+		if _, err := l.Acquire(context.Background(), newReq("CONNECT", "http://a.com:443", "10.0.0.1")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := l.Acquire(context.Background(), newReq("CONNECT", "http://b.com:443", "10.0.0.2"))
+		var limitErr *ConnLimitError
+		if !errors.As(err, &limitErr) || limitErr.StatusCode() != http.StatusServiceUnavailable {
+			t.Fatalf("expected tunnels limit error with 503, got %v", err)
+		}
+		// 普通请求不受隧道上限影响
+		if _, err := l.Acquire(context.Background(), newReq("GET", "http://a.com/", "10.0.0.2")); err != nil {
+			t.Errorf("unexpected error for plain request: %v", err)
+		}
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		l := &ConnLimiter{Global: 1, QueueTimeout: time.Second}
+		release, err := l.Acquire(context.Background(), newReq("GET", "http://a.com/", "10.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(50*time.Millisecond, release)
+
+		start := time.Now()
+		if _, err := l.Acquire(context.Background(), newReq("GET", "http://a.com/", "10.0.0.2")); err != nil {
+			t.Fatalf("queued request failed: %v", err)
+		}
+		if time.Since(start) < 50*time.Millisecond {
+			t.Error("expected queued request to wait for release")
+		}
+
+		l.QueueTimeout = 50 * time.Millisecond
+		if _, err := l.Acquire(context.Background(), newReq("GET", "http://a.com/", "10.0.0.3")); err == nil {
+			t.Error("expected queue timeout error")
+		}
+	})
+}
+
+func TestReverseProxy_ConnLimit(t *testing.T) {
+	proxy := &ReverseProxy{ConnLimiter: &ConnLimiter{Global: 1}}
+	release, err := proxy.ConnLimiter.Acquire(context.Background(), httptest.NewRequest("GET", "http://a.com/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
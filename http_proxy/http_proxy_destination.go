@@ -0,0 +1,161 @@
+package http_proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultDeniedNetworks 是默认禁止访问的目标网段：本机回环、链路本地（含云主机元数据服务）和未指定地址
+var DefaultDeniedNetworks = []string{
+	"127.0.0.0/8",
+	"::1/128",
+	"0.0.0.0/8",
+	"::/128",
+	"169.254.0.0/16", // 链路本地，包括 169.254.169.254 元数据服务
+	"fe80::/10",
+	"fd00:ec2::254/128",  // AWS IPv6 元数据服务
+	"100.100.100.200/32", // 阿里云元数据服务
+}
+
+// PrivateNetworks 是内网地址段（RFC 1918、RFC 6598 和 IPv6 ULA）
+var PrivateNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+}
+
+// DestinationDeniedError 表示目标地址被目标策略禁止
+type DestinationDeniedError struct {
+	Address string
+	IP      net.IP
+}
+
+func (e *DestinationDeniedError) Error() string {
+	return fmt.Sprintf("destination %s (%s) denied by policy", e.Address, e.IP)
+}
+
+// DestinationPolicy 在DNS解析之后检查实际连接的IP地址，防止通过代理访问内网（SSRF），
+// 由于检查发生在建立连接时，DNS重绑定也无法绕过
+type DestinationPolicy struct {
+	// Allow 允许访问的网段，优先于 Deny，用于在禁止的网段中开放例外
+	Allow []*net.IPNet
+
+	// Deny 禁止访问的网段
+	Deny []*net.IPNet
+
+	once      sync.Once
+	transport *http.Transport
+}
+
+// ParseCIDRs 解析网段列表，单个IP地址视为 /32 或 /128
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// NewDestinationPolicy 根据网段列表创建目标策略
+func NewDestinationPolicy(allow, deny []string) (*DestinationPolicy, error) {
+	allowNets, err := ParseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := ParseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &DestinationPolicy{Allow: allowNets, Deny: denyNets}, nil
+}
+
+// DefaultDestinationPolicy 返回禁止访问 DefaultDeniedNetworks 的目标策略
+func DefaultDestinationPolicy() *DestinationPolicy {
+	p, _ := NewDestinationPolicy(nil, DefaultDeniedNetworks)
+	return p
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed 判断是否允许连接到指定IP
+func (p *DestinationPolicy) Allowed(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4 // 统一处理 IPv4 映射的 IPv6 地址
+	}
+	if containsIP(p.Allow, ip) {
+		return true
+	}
+	return !containsIP(p.Deny, ip)
+}
+
+// Control 用作 net.Dialer.Control，在连接建立前检查解析后的目标地址
+func (p *DestinationPolicy) Control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("destination policy: unexpected address %q", address)
+	}
+	if !p.Allowed(ip) {
+		return &DestinationDeniedError{Address: address, IP: ip}
+	}
+	return nil
+}
+
+// Dialer 返回应用目标策略的拨号器
+func (p *DestinationPolicy) Dialer(timeout, keepAlive time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: keepAlive,
+		Control:   p.Control,
+	}
+}
+
+// DialContext 使用目标策略拨号
+func (p *DestinationPolicy) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return p.Dialer(30*time.Second, 30*time.Second).DialContext(ctx, network, address)
+}
+
+// Transport 返回应用目标策略的 http.Transport，基于 http.DefaultTransport 的配置，
+// 同一个策略共享一个 Transport 以复用连接。
+// 不使用 HTTP_PROXY 等环境变量中的代理，否则检查的是代理的地址而不是实际的目标
+func (p *DestinationPolicy) Transport() *http.Transport {
+	p.once.Do(func() {
+		if dt, ok := http.DefaultTransport.(*http.Transport); ok {
+			p.transport = dt.Clone()
+		} else {
+			p.transport = &http.Transport{}
+		}
+		p.transport.Proxy = nil
+		p.transport.DialContext = p.DialContext
+	})
+	return p.transport
+}
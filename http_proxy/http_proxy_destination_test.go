@@ -0,0 +1,116 @@
+package http_proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestDestinationPolicy_Allowed(t *testing.T) {
+	policy, err := NewDestinationPolicy([]string{"10.1.2.3"}, append(DefaultDeniedNetworks, PrivateNetworks...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"10.0.0.1", false},
+		{"192.168.1.1", false},
+		{"10.1.2.3", true}, // 允许规则优先
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := policy.Allowed(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("Allowed(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseProxy_DestinationPolicy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(backendURL.Host)
+
+	// 通过主机名访问时也在DNS解析之后检查
+	viaName, _ := url.Parse("http://localhost:" + port)
+
+	allowLoopback, err := NewDestinationPolicy([]string{"127.0.0.0/8"}, DefaultDeniedNetworks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		target *url.URL
+		policy *DestinationPolicy
+		want   int
+	}{
+		{"no policy", backendURL, nil, http.StatusOK},
+		{"default policy denies loopback", backendURL, DefaultDestinationPolicy(), http.StatusForbidden},
+		{"hostname resolving to loopback", viaName, DefaultDestinationPolicy(), http.StatusForbidden},
+		{"allow overrides deny", backendURL, allowLoopback, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewReverseProxy(tt.target)
+			proxy.DestinationPolicy = tt.policy
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinationPolicy_IgnoresEnvironmentProxy(t *testing.T) {
+	// 环境变量中的代理允许访问，如果经过它转发，检查的将是代理的地址
+	envProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "via environment proxy")
+	}))
+	defer envProxy.Close()
+	t.Setenv("HTTP_PROXY", envProxy.URL)
+	t.Setenv("http_proxy", envProxy.URL)
+
+	policy, err := NewDestinationPolicy([]string{"127.0.0.0/8"}, DefaultDeniedNetworks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Transport().Proxy != nil {
+		t.Fatal("Transport() uses a proxy from the environment")
+	}
+
+	target, _ := url.Parse("http://169.254.169.254/")
+	proxy := NewReverseProxy(target)
+	proxy.DestinationPolicy = policy
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/latest/meta-data/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
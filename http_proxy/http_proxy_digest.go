@@ -0,0 +1,342 @@
+package http_proxy
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest 认证支持的算法
+const (
+	DigestSHA256 = "SHA-256"
+	DigestMD5    = "MD5"
+)
+
+// Digest 认证的默认参数
+const (
+	DefaultDigestNonceTTL  = 5 * time.Minute
+	DefaultDigestMaxNonces = 100000
+)
+
+// errStaleNonce 表示凭据正确但 nonce 已过期，客户端应使用新的 nonce 重试
+var errStaleNonce = errors.New("stale digest nonce")
+
+// DigestCredentials 提供 Digest 认证所需的凭据
+type DigestCredentials interface {
+	// DigestHA1 返回用户的 H(username:realm:password)，用户不存在或无法计算时返回 false
+	DigestHA1(algorithm, username, realm string) (string, bool)
+}
+
+// ComputeDigestHA1 计算 Digest 认证的 H(username:realm:password)
+func ComputeDigestHA1(algorithm, username, realm, password string) string {
+	return digestHash(algorithm, username+":"+realm+":"+password)
+}
+
+// digestHash 使用指定算法计算十六进制摘要
+func digestHash(algorithm, s string) string {
+	var h hash.Hash
+	if algorithm == DigestMD5 {
+		h = md5.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DigestHA1 实现 DigestCredentials
+func (a *StaticAuthenticator) DigestHA1(algorithm, username, realm string) (string, bool) {
+	if subtle.ConstantTimeCompare([]byte(username), []byte(a.Username)) != 1 {
+		return "", false
+	}
+	return ComputeDigestHA1(algorithm, username, realm, a.Password), true
+}
+
+// DigestHA1 实现 DigestCredentials，只有明文密码的用户支持 Digest 认证
+func (a *FileAuthenticator) DigestHA1(algorithm, username, realm string) (string, bool) {
+	a.mu.RLock()
+	password, ok := a.users[username]
+	a.mu.RUnlock()
+	if !ok || strings.HasPrefix(password, sha256Prefix) {
+		return "", false
+	}
+	return ComputeDigestHA1(algorithm, username, realm, password), true
+}
+
+// DigestAuth 实现 RFC 7616 Digest 代理认证（qop=auth）
+//
+// nonce 包含签发时间并由服务器密钥签名，无需保存未使用的 nonce；
+// 已使用的 nonce 记录最大的 nonce-count，重复或回退的 nc 不再接受。
+// nonce 过期或 nc 不能接受时返回 stale=true，客户端无需重新输入密码即可使用新的 nonce 重试。
+type DigestAuth struct {
+	// Credentials 提供用户的 HA1
+	Credentials DigestCredentials
+
+	// Algorithms 按优先顺序提供给客户端的算法，默认为 SHA-256 和 MD5
+	Algorithms []string
+
+	// NonceTTL nonce 的有效期
+	NonceTTL time.Duration
+
+	// MaxNonces 最多跟踪的已使用 nonce 数，超过后所有已使用的 nonce 作废
+	MaxNonces int
+
+	secret []byte
+	opaque string
+
+	mu     sync.Mutex
+	nonces map[string]uint64
+	cutoff time.Time
+}
+
+// NewDigestAuth 使用默认参数创建 DigestAuth
+func NewDigestAuth(credentials DigestCredentials) *DigestAuth {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("http_proxy: cannot generate digest secret: " + err.Error())
+	}
+	opaque := make([]byte, 16)
+	rand.Read(opaque)
+
+	return &DigestAuth{
+		Credentials: credentials,
+		Algorithms:  []string{DigestSHA256, DigestMD5},
+		NonceTTL:    DefaultDigestNonceTTL,
+		MaxNonces:   DefaultDigestMaxNonces,
+		secret:      secret,
+		opaque:      hex.EncodeToString(opaque),
+		nonces:      make(map[string]uint64),
+	}
+}
+
+// newNonce 生成 nonce：签发时间、随机数和 HMAC 签名
+func (d *DigestAuth) newNonce() string {
+	buf := make([]byte, 32)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	rand.Read(buf[8:16])
+	copy(buf[16:], d.sign(buf[:16]))
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (d *DigestAuth) sign(b []byte) []byte {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write(b)
+	return mac.Sum(nil)[:16]
+}
+
+// nonceTime 校验 nonce 的签名并返回签发时间
+func (d *DigestAuth) nonceTime(nonce string) (time.Time, bool) {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != 32 || !hmac.Equal(buf[16:], d.sign(buf[:16])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(buf))), true
+}
+
+// challenges 返回 Proxy-Authenticate 的 Digest 质询，每个算法一个
+func (d *DigestAuth) challenges(realm string, stale bool) []string {
+	nonce := d.newNonce()
+	out := make([]string, 0, len(d.Algorithms))
+	for _, alg := range d.Algorithms {
+		c := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=%s, nonce=%q, opaque=%q`, realm, alg, nonce, d.opaque)
+		if stale {
+			c += ", stale=true"
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// digestResponse 是客户端在 Proxy-Authorization 中发送的 Digest 参数
+type digestResponse struct {
+	username  string
+	realm     string
+	nonce     string
+	uri       string
+	algorithm string
+	response  string
+	qop       string
+	nc        string
+	cnonce    string
+	opaque    string
+}
+
+// parseDigestResponse 解析 "Digest ..." 认证头
+func parseDigestResponse(auth string) (*digestResponse, bool) {
+	const prefix = "Digest "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return nil, false
+	}
+	params := parseAuthParams(auth[len(prefix):])
+	r := &digestResponse{
+		username:  params["username"],
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		uri:       params["uri"],
+		algorithm: params["algorithm"],
+		response:  params["response"],
+		qop:       params["qop"],
+		nc:        params["nc"],
+		cnonce:    params["cnonce"],
+		opaque:    params["opaque"],
+	}
+	if r.algorithm == "" {
+		r.algorithm = DigestMD5
+	}
+	if r.username == "" || r.nonce == "" || r.response == "" {
+		return nil, false
+	}
+	return r, true
+}
+
+// parseAuthParams 解析以逗号分隔的 name=value 或 name="quoted value" 参数
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return params
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[name] = value
+	}
+}
+
+// supports 判断是否提供了指定算法
+func (d *DigestAuth) supports(algorithm string) bool {
+	for _, alg := range d.Algorithms {
+		if strings.EqualFold(alg, algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// verify 校验 Digest 响应，nonce 过期但响应正确时返回 errStaleNonce
+func (d *DigestAuth) verify(req *http.Request, realm string, r *digestResponse) (*Identity, error) {
+	if r.realm != realm || !d.supports(r.algorithm) || r.qop != "auth" || r.opaque != d.opaque {
+		return nil, ErrInvalidCredentials
+	}
+	if !digestURIMatches(req, r.uri) {
+		return nil, ErrInvalidCredentials
+	}
+	nc, err := strconv.ParseUint(r.nc, 16, 64)
+	if err != nil || nc == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	alg := canonicalDigestAlgorithm(r.algorithm)
+	ha1, ok := d.Credentials.DigestHA1(alg, r.username, realm)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	ha2 := digestHash(alg, req.Method+":"+r.uri)
+	expected := digestHash(alg, strings.Join([]string{ha1, r.nonce, r.nc, r.cnonce, r.qop, ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(r.response))) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	// 响应正确但 nonce 不是本服务器签发的（如服务重启前签发），要求客户端使用新的 nonce
+	issued, ok := d.nonceTime(r.nonce)
+	if !ok {
+		return nil, errStaleNonce
+	}
+	if err := d.useNonce(r.nonce, issued, nc); err != nil {
+		return nil, err
+	}
+	return &Identity{Username: r.username}, nil
+}
+
+// digestURIMatches 判断摘要中的 uri 是否与请求目标一致，防止凭据被用于其他请求
+// 代理请求的目标是完整 URL，但部分客户端（如 curl）在摘要中只使用路径部分
+func digestURIMatches(req *http.Request, uri string) bool {
+	if req.RequestURI == "" || uri == req.RequestURI {
+		return true
+	}
+	return req.Method != http.MethodConnect && req.URL != nil && uri == req.URL.RequestURI()
+}
+
+func canonicalDigestAlgorithm(alg string) string {
+	if strings.EqualFold(alg, DigestMD5) {
+		return DigestMD5
+	}
+	return DigestSHA256
+}
+
+// useNonce 检查 nonce 是否过期并记录 nonce-count。
+// 并发请求可能乱序到达，重复或回退的 nc 返回 errStaleNonce 让客户端换新的 nonce，
+// 不计为认证失败；重放者无法为新的 nonce 计算响应
+func (d *DigestAuth) useNonce(nonce string, issued time.Time, nc uint64) error {
+	now := time.Now()
+	if now.Sub(issued) > d.NonceTTL {
+		return errStaleNonce
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last, seen := d.nonces[nonce]
+	if !seen && issued.Before(d.cutoff) {
+		// 跟踪记录已被清空，无法判断是否重放
+		return errStaleNonce
+	}
+	if nc <= last {
+		return errStaleNonce
+	}
+
+	if !seen && len(d.nonces) >= d.MaxNonces {
+		for n := range d.nonces {
+			if t, ok := d.nonceTime(n); !ok || now.Sub(t) > d.NonceTTL {
+				delete(d.nonces, n)
+			}
+		}
+		if len(d.nonces) >= d.MaxNonces {
+			d.nonces = make(map[string]uint64)
+			d.cutoff = now
+			return errStaleNonce
+		}
+	}
+	d.nonces[nonce] = nc
+	return nil
+}
@@ -0,0 +1,182 @@
+package http_proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digestAuthorization 按质询计算客户端的 Proxy-Authorization
+func digestAuthorization(challenge, method, uri, username, password string, nc int) string {
+	params := parseAuthParams(strings.TrimPrefix(challenge, "Digest "))
+	alg := params["algorithm"]
+	ha1 := ComputeDigestHA1(alg, username, params["realm"], password)
+	ha2 := digestHash(alg, method+":"+uri)
+	ncStr := fmt.Sprintf("%08x", nc)
+	cnonce := "0a4f113b"
+	response := digestHash(alg, strings.Join([]string{ha1, params["nonce"], ncStr, cnonce, "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, qop=auth, nc=%s, cnonce=%q, response=%q, opaque=%q`,
+		username, params["realm"], params["nonce"], uri, alg, ncStr, cnonce, response, params["opaque"])
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", ` +
+		`algorithm=SHA-256, nc=00000001, qop=auth, response="a\"b,c"`)
+	want := map[string]string{
+		"username":  "Mufasa",
+		"realm":     "http-auth@example.org",
+		"uri":       "/dir/index.html",
+		"algorithm": "SHA-256",
+		"nc":        "00000001",
+		"qop":       "auth",
+		"response":  `a"b,c`,
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("params[%s] = %q, want %q", k, params[k], v)
+		}
+	}
+}
+
+func TestComputeDigestHA1(t *testing.T) {
+	// RFC 7616 第 3.9.1 节示例
+	ha1 := ComputeDigestHA1(DigestSHA256, "Mufasa", "http-auth@example.org", "Circle of Life")
+	ha2 := digestHash(DigestSHA256, "GET:/dir/index.html")
+	nonce := "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	cnonce := "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	got := digestHash(DigestSHA256, strings.Join([]string{ha1, nonce, "00000001", cnonce, "auth", ha2}, ":"))
+	want := "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"
+	if got != want {
+		t.Errorf("response = %s, want %s", got, want)
+	}
+}
+
+func TestReverseProxy_DigestAuth(t *testing.T) {
+	static := &StaticAuthenticator{Username: "alice", Password: "secret"}
+	digest := NewDigestAuth(static)
+	// 认证通过的 CONNECT 请求会被端口策略以 403 拒绝，据此区分认证结果
+	proxy := &ReverseProxy{
+		Auth:         &ProxyAuth{Realm: "proxy", Digest: digest},
+		ConnectPorts: &PortPolicy{Ports: PortList{}},
+	}
+
+	// 返回 Proxy-Authenticate 中指定算法的质询
+	challengeFor := func(w *httptest.ResponseRecorder, alg string) string {
+		for _, c := range w.Header().Values("Proxy-Authenticate") {
+			if strings.Contains(c, "algorithm="+alg+",") {
+				return c
+			}
+		}
+		t.Fatalf("no %s challenge in %v", alg, w.Header().Values("Proxy-Authenticate"))
+		return ""
+	}
+	serve := func(method, target, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if authorization != "" {
+			req.Header.Set("Proxy-Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodConnect, "example.com:25", "")
+	if w.Code != http.StatusProxyAuthRequired {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+	}
+	if values := w.Header().Values("Proxy-Authenticate"); len(values) != 2 || strings.Contains(strings.Join(values, ","), "Basic") {
+		t.Errorf("unexpected challenges: %v", values)
+	}
+
+	for _, alg := range []string{DigestSHA256, DigestMD5} {
+		t.Run(alg, func(t *testing.T) {
+			challenge := challengeFor(serve(http.MethodConnect, "example.com:25", ""), alg)
+
+			auth := digestAuthorization(challenge, http.MethodConnect, "example.com:25", "alice", "secret", 1)
+			if w := serve(http.MethodConnect, "example.com:25", auth); w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+
+			// 重放同一个 nonce-count，要求客户端使用新的 nonce
+			w := serve(http.MethodConnect, "example.com:25", auth)
+			if w.Code != http.StatusProxyAuthRequired {
+				t.Errorf("replay status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+			} else if c := challengeFor(w, alg); !strings.HasSuffix(c, "stale=true") {
+				t.Errorf("expected stale challenge, got %s", c)
+			}
+
+			// 递增的 nonce-count 可以继续使用
+			auth = digestAuthorization(challenge, http.MethodConnect, "example.com:25", "alice", "secret", 2)
+			if w := serve(http.MethodConnect, "example.com:25", auth); w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+
+			// 错误的密码
+			auth = digestAuthorization(challenge, http.MethodConnect, "example.com:25", "alice", "wrong", 3)
+			if w := serve(http.MethodConnect, "example.com:25", auth); w.Code != http.StatusProxyAuthRequired {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+			}
+
+			// 摘要中的 uri 与请求不一致
+			auth = digestAuthorization(challenge, http.MethodConnect, "example.com:443", "alice", "secret", 4)
+			if w := serve(http.MethodConnect, "example.com:25", auth); w.Code != http.StatusProxyAuthRequired {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+			}
+		})
+	}
+
+	t.Run("out of order", func(t *testing.T) {
+		// 乱序或重复的 nonce-count 不计为认证失败，否则一次失败就会被封禁
+		proxy.Auth.Guard = &AuthGuard{MaxFailures: 1, BanDuration: time.Hour}
+		defer func() { proxy.Auth.Guard = nil }()
+		challenge := challengeFor(serve(http.MethodConnect, "example.com:25", ""), DigestSHA256)
+
+		for _, tt := range []struct {
+			nc    int
+			code  int
+			stale bool
+		}{
+			{2, http.StatusForbidden, false},
+			{1, http.StatusProxyAuthRequired, true},
+			{2, http.StatusProxyAuthRequired, true},
+			{3, http.StatusForbidden, false},
+		} {
+			auth := digestAuthorization(challenge, http.MethodConnect, "example.com:25", "alice", "secret", tt.nc)
+			w := serve(http.MethodConnect, "example.com:25", auth)
+			if w.Code != tt.code {
+				t.Fatalf("nc=%d: status = %d, want %d", tt.nc, w.Code, tt.code)
+			}
+			if tt.stale {
+				if c := challengeFor(w, DigestSHA256); !strings.HasSuffix(c, "stale=true") {
+					t.Errorf("nc=%d: expected stale challenge, got %s", tt.nc, c)
+				}
+			}
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		challenge := challengeFor(serve(http.MethodConnect, "example.com:25", ""), DigestSHA256)
+		digest.NonceTTL = time.Nanosecond
+		defer func() { digest.NonceTTL = DefaultDigestNonceTTL }()
+		time.Sleep(time.Millisecond)
+
+		auth := digestAuthorization(challenge, http.MethodConnect, "example.com:25", "alice", "secret", 1)
+		w := serve(http.MethodConnect, "example.com:25", auth)
+		if w.Code != http.StatusProxyAuthRequired {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+		}
+		if c := challengeFor(w, DigestSHA256); !strings.HasSuffix(c, "stale=true") {
+			t.Errorf("expected stale challenge, got %s", c)
+		}
+
+		// 密码错误时不返回 stale
+		auth = digestAuthorization(challenge, http.MethodConnect, "example.com:25", "alice", "wrong", 1)
+		w = serve(http.MethodConnect, "example.com:25", auth)
+		if c := challengeFor(w, DigestSHA256); strings.Contains(c, "stale") {
+			t.Errorf("unexpected stale challenge: %s", c)
+		}
+	})
+}
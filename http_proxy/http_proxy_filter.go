@@ -0,0 +1,353 @@
+package http_proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultMaxMatchLength 是正则替换时单个匹配的默认最大长度
+const DefaultMaxMatchLength = 4096
+
+// filterFlushSize 是替换时缓冲的数据量，超过后写出已确定不会再匹配的部分
+const filterFlushSize = 32 * 1024
+
+// BodyTransform 流式处理消息体
+type BodyTransform interface {
+	// NewWriter 返回一个写入器，写入的原始内容处理后写入 dst，
+	// Close 时写出剩余的内容并返回处理中的错误，但不关闭 dst。
+	// 读取原始内容出错时不调用 Close，使用后台 goroutine 等资源的写入器
+	// 应实现 CloseWithError(error) error，丢弃剩余的内容并释放资源
+	NewWriter(dst io.Writer) io.WriteCloser
+}
+
+// BodyFilter 对指定媒体类型的消息体应用 Transform
+type BodyFilter struct {
+	// ContentTypes 处理的媒体类型，如 text/html、application/json、text/*，为空时处理所有类型
+	ContentTypes []string
+
+	// Transform 处理消息体
+	Transform BodyTransform
+}
+
+// Matches 判断是否处理该 Content-Type 的消息体
+func (f *BodyFilter) Matches(contentType string) bool {
+	return len(f.ContentTypes) == 0 || matchContentType(f.ContentTypes, contentType)
+}
+
+// matchContentType 判断 Content-Type 是否匹配媒体类型列表，支持 text/* 和 */* 通配
+func matchContentType(patterns []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if prefix == "*" || strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterRequestBody 对请求体应用匹配的过滤器，可在 Director 中使用
+//
+// 请求体在读取时流式处理，gzip、deflate 和 br 编码的请求体先解压，处理后按原编码重新压缩。
+// 处理后的长度无法预先确定，因此删除 Content-Length，请求体以 chunked 编码发送。
+// 没有匹配的过滤器或使用了不支持的编码（如 zstd）时不修改请求，返回 false。
+func FilterRequestBody(req *http.Request, filters ...*BodyFilter) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return false
+	}
+	body, ok := filterBody(req.Header, req.Body, filters)
+	if !ok {
+		return false
+	}
+	req.Body, req.ContentLength, req.GetBody = body, -1, nil
+	return true
+}
+
+// FilterResponseBody 对响应体应用匹配的过滤器，可在 ModifyResponse 中使用
+//
+// 处理方式与 FilterRequestBody 相同，响应以 chunked 编码返回给客户端。
+// HEAD 请求的响应、204、206 和 304 响应不处理。
+func FilterResponseBody(res *http.Response, filters ...*BodyFilter) bool {
+	if res.Body == nil || res.Body == http.NoBody {
+		return false
+	}
+	switch res.StatusCode {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if res.Request != nil && res.Request.Method == http.MethodHead {
+		return false
+	}
+	body, ok := filterBody(res.Header, res.Body, filters)
+	if !ok {
+		return false
+	}
+	res.Body, res.ContentLength = body, -1
+	return true
+}
+
+// filterBody 返回处理后的消息体并更新消息头
+func filterBody(h http.Header, body io.ReadCloser, filters []*BodyFilter) (io.ReadCloser, bool) {
+	var transforms []BodyTransform
+	contentType := h.Get("Content-Type")
+	for _, f := range filters {
+		if f != nil && f.Transform != nil && f.Matches(contentType) {
+			transforms = append(transforms, f.Transform)
+		}
+	}
+	if len(transforms) == 0 {
+		return nil, false
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity", "gzip", "x-gzip", "deflate", "br":
+	default:
+		return nil, false
+	}
+
+	// 内容改变后原有的长度、校验和强校验 ETag 不再有效
+	h.Del("Content-Length")
+	h.Del("Content-Md5")
+	if etag := h.Get("Etag"); strings.HasPrefix(etag, `"`) {
+		h.Set("Etag", "W/"+etag)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := runBodyTransforms(pw, body, encoding, transforms)
+		pw.CloseWithError(err)
+	}()
+	return &filteredBody{PipeReader: pr, src: body}, true
+}
+
+// filteredBody 是处理后的消息体，关闭时同时关闭原始消息体以结束处理
+type filteredBody struct {
+	*io.PipeReader
+	src io.Closer
+}
+
+func (b *filteredBody) Close() error {
+	b.PipeReader.Close()
+	return b.src.Close()
+}
+
+// runBodyTransforms 解压原始内容，依次应用 transforms，再按原编码压缩后写入 dst
+func runBodyTransforms(dst io.Writer, body io.Reader, encoding string, transforms []BodyTransform) error {
+	var src io.Reader = body
+	var out io.Writer = dst
+	var encoder io.WriteCloser
+	switch encoding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("filter: %w", err)
+		}
+		src, encoder = zr, gzip.NewWriter(dst)
+	case "deflate":
+		// HTTP 的 deflate 应为 zlib 格式，但部分服务器发送原始 deflate 数据
+		br := bufio.NewReader(body)
+		if isZlibHeader(br) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return fmt.Errorf("filter: %w", err)
+			}
+			src, encoder = zr, zlib.NewWriter(dst)
+		} else {
+			fw, _ := flate.NewWriter(dst, flate.DefaultCompression)
+			src, encoder = flate.NewReader(br), fw
+		}
+	case "br":
+		src, encoder = brotli.NewReader(body), brotli.NewWriter(dst)
+	}
+	if encoder != nil {
+		out = encoder
+	}
+
+	writers := make([]io.WriteCloser, len(transforms))
+	for i := len(transforms) - 1; i >= 0; i-- {
+		writers[i] = transforms[i].NewWriter(out)
+		out = writers[i]
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		abortWriters(writers, err)
+		return err
+	}
+	// 按写入顺序关闭，每个写入器写出剩余内容后再关闭下一个
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	if encoder != nil {
+		return encoder.Close()
+	}
+	return nil
+}
+
+// abortWriters 在读取原始内容出错时结束实现了 CloseWithError 的写入器（如 JSONReplaceTransform），
+// 释放其后台 goroutine
+func abortWriters(writers []io.WriteCloser, err error) {
+	for _, w := range writers {
+		if a, ok := w.(interface{ CloseWithError(error) error }); ok {
+			a.CloseWithError(err)
+		}
+	}
+}
+
+// isZlibHeader 判断数据是否以 zlib 头开始
+func isZlibHeader(br *bufio.Reader) bool {
+	b, err := br.Peek(2)
+	if err != nil {
+		return false
+	}
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// ReplaceTransform 流式替换消息体中的文本
+//
+// 为了在不缓冲整个消息体的情况下查找跨越读取边界的匹配，替换时保留最后 MaxMatch 字节，
+// 因此长度超过 MaxMatch 的匹配不会被替换；^、$ 和 \b 等断言在缓冲区边界处可能不准确。
+type ReplaceTransform struct {
+	// Pattern 查找的正则表达式
+	Pattern *regexp.Regexp
+
+	// Replacement 替换内容，Literal 为 false 时可以使用 $1、${name} 引用分组
+	Replacement []byte
+
+	// Literal 为 true 时 Replacement 按原样替换
+	Literal bool
+
+	// MaxMatch 单个匹配的最大长度
+	MaxMatch int
+}
+
+// NewLiteralReplace 创建替换固定字符串的 ReplaceTransform
+func NewLiteralReplace(old, new string) (*ReplaceTransform, error) {
+	if old == "" {
+		return nil, fmt.Errorf("filter: empty search string")
+	}
+	return &ReplaceTransform{
+		Pattern:     regexp.MustCompile(regexp.QuoteMeta(old)),
+		Replacement: []byte(new),
+		Literal:     true,
+		MaxMatch:    len(old),
+	}, nil
+}
+
+// NewRegexpReplace 创建按正则表达式替换的 ReplaceTransform，单个匹配最长 DefaultMaxMatchLength 字节
+func NewRegexpReplace(pattern, replacement string) (*ReplaceTransform, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	return &ReplaceTransform{Pattern: re, Replacement: []byte(replacement), MaxMatch: DefaultMaxMatchLength}, nil
+}
+
+// NewWriter 实现 BodyTransform
+func (t *ReplaceTransform) NewWriter(dst io.Writer) io.WriteCloser {
+	n := t.MaxMatch
+	if n <= 0 {
+		n = DefaultMaxMatchLength
+	}
+	return &replaceWriter{t: t, dst: dst, max: n}
+}
+
+type replaceWriter struct {
+	t   *ReplaceTransform
+	dst io.Writer
+	max int
+	buf []byte
+}
+
+func (w *replaceWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.max+filterFlushSize {
+		if err := w.flush(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *replaceWriter) Close() error {
+	return w.flush(true)
+}
+
+// flush 替换缓冲区中的匹配并写出，final 为 false 时保留最后 max 字节，
+// 起始位置在保留部分之前的匹配长度不超过 max，因此已经完整
+func (w *replaceWriter) flush(final bool) error {
+	safe := len(w.buf)
+	if !final {
+		safe -= w.max
+	}
+	out := make([]byte, 0, len(w.buf))
+	last := 0
+	for _, loc := range w.t.Pattern.FindAllSubmatchIndex(w.buf, -1) {
+		if !final && loc[0] >= safe {
+			break
+		}
+		out = append(out, w.buf[last:loc[0]]...)
+		if w.t.Literal {
+			out = append(out, w.t.Replacement...)
+		} else {
+			out = w.t.Pattern.Expand(out, w.t.Replacement, w.buf, loc)
+		}
+		last = loc[1]
+	}
+
+	cut := len(w.buf)
+	if !final {
+		cut = max(last, safe)
+	}
+	out = append(out, w.buf[last:cut]...)
+	w.buf = append(w.buf[:0], w.buf[cut:]...)
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := w.dst.Write(out)
+	return err
+}
+
+// acceptEncodings 是过滤响应体时允许上游使用的编码
+var acceptEncodings = map[string]bool{"gzip": true, "x-gzip": true, "deflate": true, "br": true, "identity": true}
+
+// restrictAcceptEncoding 从 Accept-Encoding 中删除无法解压的编码（如 zstd），
+// 使上游返回可以过滤的响应
+func restrictAcceptEncoding(h http.Header) {
+	values := h.Values("Accept-Encoding")
+	if len(values) == 0 {
+		return
+	}
+	var kept []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(part, ";")
+			if acceptEncodings[strings.ToLower(strings.TrimSpace(name))] {
+				kept = append(kept, strings.TrimSpace(part))
+			}
+		}
+	}
+	if len(kept) == 0 {
+		h.Set("Accept-Encoding", "identity")
+		return
+	}
+	h.Set("Accept-Encoding", strings.Join(kept, ", "))
+}
@@ -0,0 +1,221 @@
+package http_proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonPathElem 是 JSON 路径的一级，any 匹配任意键或下标
+type jsonPathElem struct {
+	key   string
+	index int
+	array bool
+	any   bool
+}
+
+// JSONReplaceTransform 流式替换 JSON 消息体中指定路径的值
+//
+// 消息体按 token 逐个读取和写出，不需要缓冲整个文档；输出不保留原有的空白。
+// 多个连续的 JSON 值（如 NDJSON）逐个处理，每个值之后写出换行。
+type JSONReplaceTransform struct {
+	path  []jsonPathElem
+	value json.RawMessage
+}
+
+// NewJSONReplace 创建 JSONReplaceTransform，path 如 $.user.email、$.items[*].price、$.data[0]，
+// value 为替换后的 JSON 值，如 "\"redacted\""、null、0
+func NewJSONReplace(path, value string) (*JSONReplaceTransform, error) {
+	elems, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(value)) {
+		return nil, fmt.Errorf("filter: invalid JSON value %q", value)
+	}
+	return &JSONReplaceTransform{path: elems, value: json.RawMessage(value)}, nil
+}
+
+// parseJSONPath 解析 JSON 路径，支持 .name、.*、[n] 和 [*]
+func parseJSONPath(path string) ([]jsonPathElem, error) {
+	s := strings.TrimPrefix(path, "$")
+	var elems []jsonPathElem
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			if name == "" {
+				return nil, fmt.Errorf("filter: invalid JSON path %q", path)
+			}
+			elems = append(elems, jsonPathElem{key: name, any: name == "*"})
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("filter: invalid JSON path %q", path)
+			}
+			inner := s[1:end]
+			s = s[end+1:]
+			if inner == "*" {
+				elems = append(elems, jsonPathElem{any: true})
+				continue
+			}
+			if key, err := strconv.Unquote(inner); err == nil && strings.HasPrefix(inner, `"`) {
+				elems = append(elems, jsonPathElem{key: key})
+				continue
+			}
+			n, err := strconv.Atoi(inner)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("filter: invalid JSON path %q", path)
+			}
+			elems = append(elems, jsonPathElem{index: n, array: true})
+		default:
+			return nil, fmt.Errorf("filter: invalid JSON path %q", path)
+		}
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("filter: JSON path %q selects the whole document", path)
+	}
+	return elems, nil
+}
+
+// NewWriter 实现 BodyTransform，在后台解析写入的内容
+func (t *JSONReplaceTransform) NewWriter(dst io.Writer) io.WriteCloser {
+	pr, pw := io.Pipe()
+	w := &jsonReplaceWriter{PipeWriter: pw, done: make(chan error, 1)}
+	go func() {
+		err := t.rewrite(dst, pr)
+		// 解析出错时丢弃剩余的输入，避免写入方阻塞
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+type jsonReplaceWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (w *jsonReplaceWriter) Close() error {
+	w.PipeWriter.Close()
+	return <-w.done
+}
+
+// rewrite 从 src 读取 JSON 值，替换匹配路径的值后写入 dst
+func (t *JSONReplaceTransform) rewrite(dst io.Writer, src io.Reader) error {
+	dec := json.NewDecoder(src)
+	dec.UseNumber()
+	w := bufio.NewWriter(dst)
+	for {
+		err := t.copyValue(dec, w, nil)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("filter: %w", err)
+		}
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
+
+// matches 判断当前路径是否为替换的路径
+func (t *JSONReplaceTransform) matches(path []jsonPathElem) bool {
+	if len(path) != len(t.path) {
+		return false
+	}
+	for i, want := range t.path {
+		got := path[i]
+		switch {
+		case want.any:
+		case want.array != got.array:
+			return false
+		case want.array && want.index != got.index:
+			return false
+		case !want.array && want.key != got.key:
+			return false
+		}
+	}
+	return true
+}
+
+// copyValue 复制一个 JSON 值，path 为该值的路径
+func (t *JSONReplaceTransform) copyValue(dec *json.Decoder, w *bufio.Writer, path []jsonPathElem) error {
+	if len(path) > 0 && t.matches(path) {
+		var skipped json.RawMessage
+		if err := dec.Decode(&skipped); err != nil {
+			return err
+		}
+		_, err := w.Write(t.value)
+		return err
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		object := v == '{'
+		w.WriteRune(rune(v))
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			elem := jsonPathElem{index: i, array: true}
+			if object {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+				writeJSON(w, key)
+				w.WriteByte(':')
+				elem = jsonPathElem{key: key}
+			}
+			if err := t.copyValue(dec, w, append(path, elem)); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		// 读取结束的 } 或 ]
+		end, err := dec.Token()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		w.WriteRune(rune(end.(json.Delim)))
+	case json.Number:
+		w.WriteString(v.String())
+	default:
+		writeJSON(w, v)
+	}
+	return nil
+}
+
+// unexpectedEOF 将值中间的 EOF 转换为 io.ErrUnexpectedEOF，与文档之间的 EOF 区分
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// writeJSON 写出字符串、布尔值或 null，不转义 HTML 字符
+func writeJSON(w *bufio.Writer, v any) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	// Encode 会在末尾写出换行
+	w.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
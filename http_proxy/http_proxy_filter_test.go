@@ -0,0 +1,395 @@
+package http_proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// transformString 以每次 chunk 字节写入的方式处理 input
+func transformString(t *testing.T, transform BodyTransform, input string, chunk int) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	w := transform.NewWriter(&out)
+	for s := input; s != ""; {
+		n := min(chunk, len(s))
+		if _, err := w.Write([]byte(s[:n])); err != nil {
+			w.Close()
+			return out.String(), err
+		}
+		s = s[n:]
+	}
+	err := w.Close()
+	return out.String(), err
+}
+
+func TestReplaceTransform(t *testing.T) {
+	// 足够长的输入，使替换跨越多次 flush
+	long := strings.Repeat("lorem ipsum http://cdn.example.com/a.js dolor ", 3000)
+
+	literal, err := NewLiteralReplace("http://cdn.example.com", "https://cdn.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, err := NewRegexpReplace(`http://([a-z]+)\.example\.com`, "https://$1.example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, _ := NewRegexpReplace(`x*`, "-")
+
+	tests := []struct {
+		name      string
+		transform *ReplaceTransform
+		input     string
+		want      string
+	}{
+		{"literal", literal, long, strings.ReplaceAll(long, "http://cdn.example.com", "https://cdn.example.com")},
+		{"regexp", re, long, strings.ReplaceAll(long, "http://cdn.example.com", "https://cdn.example.net")},
+		{"literal dollar", &ReplaceTransform{Pattern: regexp.MustCompile("a"), Replacement: []byte("$1"), Literal: true}, "banana", "b$1n$1n$1"},
+		{"empty matches", empty, "abc", "-a-b-c-"},
+		{"no match", literal, "nothing to see", "nothing to see"},
+		{"empty input", literal, "", ""},
+	}
+	for _, tt := range tests {
+		for _, chunk := range []int{1, 7, 4096, 1 << 20} {
+			got, err := transformString(t, tt.transform, tt.input, chunk)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s (chunk %d): output differs, got %d bytes, want %d bytes", tt.name, chunk, len(got), len(tt.want))
+			}
+		}
+	}
+
+	if _, err := NewLiteralReplace("", "x"); err == nil {
+		t.Error("expected error for empty search string")
+	}
+	if _, err := NewRegexpReplace("(", "x"); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestJSONReplaceTransform(t *testing.T) {
+	tests := []struct {
+		path, value string
+		input, want string
+	}{
+		{"$.user.email", `"redacted"`,
+			`{"user": {"name": "alice", "email": "a@example.com"}, "n": 1.50}`,
+			`{"user":{"name":"alice","email":"redacted"},"n":1.50}` + "\n"},
+		{"$.items[*].price", `0`,
+			`{"items":[{"price":10,"id":"<a>"},{"price":{"amount":5}}]}`,
+			`{"items":[{"price":0,"id":"<a>"},{"price":0}]}` + "\n"},
+		{"$.data[1]", `null`,
+			`{"data":[true,false,[1,2]]}`,
+			`{"data":[true,null,[1,2]]}` + "\n"},
+		{`$["a.b"]`, `[]`,
+			`{"a.b":{"x":1},"a":{"b":2}}`,
+			`{"a.b":[],"a":{"b":2}}` + "\n"},
+		{"$.*", `1`,
+			`{"a":"x","b":"y"}`,
+			`{"a":1,"b":1}` + "\n"},
+		{"$.token", `"-"`,
+			"{\"token\":\"1\"}\n{\"token\":\"2\",\"k\":\"é\"}\n",
+			"{\"token\":\"-\"}\n{\"token\":\"-\",\"k\":\"é\"}\n"},
+		{"$.missing", `1`, ``, ``},
+	}
+	for _, tt := range tests {
+		transform, err := NewJSONReplace(tt.path, tt.value)
+		if err != nil {
+			t.Fatalf("NewJSONReplace(%q): %v", tt.path, err)
+		}
+		got, err := transformString(t, transform, tt.input, 3)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	transform, _ := NewJSONReplace("$.a", "1")
+	if _, err := transformString(t, transform, `{"a":1,`, 3); err == nil {
+		t.Error("expected error for truncated JSON")
+	}
+	if _, err := transformString(t, transform, `<html>`+strings.Repeat(" ", 100000), 512); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+
+	for _, path := range []string{"", "$", "$.", "$[", "$[x]", "a.b"} {
+		if _, err := NewJSONReplace(path, "1"); err == nil {
+			t.Errorf("NewJSONReplace(%q) expected error", path)
+		}
+	}
+	if _, err := NewJSONReplace("$.a", "redacted"); err == nil {
+		t.Error("expected error for invalid JSON value")
+	}
+}
+
+func TestBodyFilter_Matches(t *testing.T) {
+	f := &BodyFilter{ContentTypes: []string{"text/*", "application/json"}}
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/html; charset=utf-8", true},
+		{"TEXT/PLAIN", true},
+		{"application/json", true},
+		{"application/javascript", false},
+		{"image/png", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := f.Matches(tt.contentType); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+	if !(&BodyFilter{}).Matches("") {
+		t.Error("filter without content types should match everything")
+	}
+}
+
+func TestFilterResponseBody(t *testing.T) {
+	replace, _ := NewLiteralReplace("secret", "******")
+	filter := &BodyFilter{ContentTypes: []string{"text/*"}, Transform: replace}
+	body := strings.Repeat("the secret is out. ", 1000)
+
+	compress := func(encoding string) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.BestSpeed)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		default:
+			buf.WriteString(body)
+			return buf.Bytes()
+		}
+		io.WriteString(w, body)
+		w.Close()
+		return buf.Bytes()
+	}
+	decompress := func(encoding string, data []byte) string {
+		var r io.Reader = bytes.NewReader(data)
+		switch encoding {
+		case "gzip":
+			r, _ = gzip.NewReader(r)
+		case "deflate":
+			r, _ = zlib.NewReader(r)
+		case "raw-deflate":
+			r = flate.NewReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("decompress %s: %v", encoding, err)
+		}
+		return string(out)
+	}
+
+	for _, encoding := range []string{"", "gzip", "deflate", "raw-deflate", "br"} {
+		t.Run("encoding "+encoding, func(t *testing.T) {
+			data := compress(encoding)
+			header := http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"1"}, "Etag": {`"v1"`}}
+			if encoding != "" {
+				header.Set("Content-Encoding", strings.TrimPrefix(encoding, "raw-"))
+			}
+			res := &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(bytes.NewReader(data)), ContentLength: int64(len(data))}
+			if !FilterResponseBody(res, filter) {
+				t.Fatal("FilterResponseBody() = false")
+			}
+			out, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if got := decompress(encoding, out); got != strings.ReplaceAll(body, "secret", "******") {
+				t.Errorf("body not replaced: %.40q", got)
+			}
+			if res.ContentLength != -1 || res.Header.Get("Content-Length") != "" {
+				t.Errorf("Content-Length not removed")
+			}
+			if etag := res.Header.Get("Etag"); etag != `W/"v1"` {
+				t.Errorf("Etag = %q, want weak", etag)
+			}
+		})
+	}
+
+	// 不处理的情况
+	skipped := []struct {
+		name string
+		res  *http.Response
+	}{
+		{"zstd", &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"zstd"}}}},
+		{"content type", &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"image/png"}}}},
+		{"partial content", &http.Response{StatusCode: 206, Header: http.Header{"Content-Type": {"text/plain"}}}},
+		{"head", &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"text/plain"}}, Request: httptest.NewRequest(http.MethodHead, "/", nil)}},
+	}
+	for _, tt := range skipped {
+		tt.res.Body = io.NopCloser(strings.NewReader(body))
+		if FilterResponseBody(tt.res, filter) {
+			t.Errorf("%s: FilterResponseBody() = true, want false", tt.name)
+		}
+	}
+
+	// 压缩数据损坏时读取返回错误
+	res := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}},
+		Body: io.NopCloser(strings.NewReader("not gzip"))}
+	FilterResponseBody(res, filter)
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Error("expected error for corrupt gzip body")
+	}
+}
+
+func TestRestrictAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"gzip, deflate, br, zstd", "gzip, deflate, br"},
+		{"zstd;q=1.0, gzip;q=0.8", "gzip;q=0.8"},
+		{"zstd", "identity"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.in != "" {
+			h.Set("Accept-Encoding", tt.in)
+		}
+		restrictAcceptEncoding(h)
+		if got := h.Get("Accept-Encoding"); got != tt.want {
+			t.Errorf("restrictAcceptEncoding(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReverseProxy_BodyFilters(t *testing.T) {
+	var gotBody string
+	var gotLength int64
+	var gotEncoding string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody, gotLength, gotEncoding = string(b), r.ContentLength, r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"user":{"email":"a@example.com"},"ok":true}`)
+	}))
+	defer backend.Close()
+
+	requestReplace, _ := NewLiteralReplace("password=hunter2", "password=***")
+	jsonReplace, _ := NewJSONReplace("$.user.email", `"redacted"`)
+	proxy := &ReverseProxy{
+		Director:        func(req *http.Request) { req.URL.Host = backend.Listener.Addr().String() },
+		RequestFilters:  []*BodyFilter{{ContentTypes: []string{"application/x-www-form-urlencoded"}, Transform: requestReplace}},
+		ResponseFilters: []*BodyFilter{{ContentTypes: []string{"application/json"}, Transform: jsonReplace}},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/login", strings.NewReader("user=alice&password=hunter2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept-Encoding", "zstd, gzip")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if gotBody != "user=alice&password=***" || gotLength != -1 {
+		t.Errorf("upstream body = %q (length %d), want filtered chunked body", gotBody, gotLength)
+	}
+	if gotEncoding != "gzip" {
+		t.Errorf("upstream Accept-Encoding = %q, want gzip", gotEncoding)
+	}
+	if got := w.Body.String(); got != `{"user":{"email":"redacted"},"ok":true}`+"\n" {
+		t.Errorf("response body = %q", got)
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("response Content-Length = %q, want none", w.Header().Get("Content-Length"))
+	}
+}
+
+func TestRewriteRules_BodyActions(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<script src="http://cdn.example.com/a.js"></script>`)
+	}))
+	defer backend.Close()
+
+	rules := newTestRewriteRules(t, `
+response type=text/html   replace-body http://cdn.example.com https://cdn.example.com
+response type=text/html   replace-body-regexp "src=\"([^\"]+)\"" "data-src=\"$1\""
+response type=application/json replace-json $.a 1
+`)
+	proxy := &ReverseProxy{
+		Director: func(req *http.Request) { req.URL.Host = backend.Listener.Addr().String() },
+		Rewrite:  rules,
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if got, want := w.Body.String(), `<script data-src="https://cdn.example.com/a.js"></script>`; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+
+	if _, err := parseRewriteRules(strings.NewReader(`response * replace-json user 1`)); err == nil {
+		t.Error("expected error for invalid JSON path")
+	}
+
+	// 只有可能匹配修改响应体的规则的请求才限制 Accept-Encoding
+	rules = newTestRewriteRules(t, `
+response host=www.example.com path=/app/* type=text/html replace-body a b
+response host=api.example.com set-header X-Api 1
+`)
+	for target, want := range map[string]bool{
+		"http://www.example.com/app/index.html": true,
+		"http://www.example.com/static/a.png":   false,
+		"http://api.example.com/app/":           false,
+	} {
+		if got := rules.filtersResponse(httptest.NewRequest(http.MethodGet, target, nil)); got != want {
+			t.Errorf("filtersResponse(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestFilterBody_ReadError(t *testing.T) {
+	jsonReplace, _ := NewJSONReplace("$.a", "1")
+	filter := &BodyFilter{Transform: jsonReplace}
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		// 读取原始内容出错时 JSONReplaceTransform 的后台 goroutine 应当结束
+		body := io.MultiReader(strings.NewReader(`{"a":`), iotest.ErrReader(errors.New("connection reset")))
+		res := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(body)}
+		if !FilterResponseBody(res, filter) {
+			t.Fatal("FilterResponseBody() = false")
+		}
+		if _, err := io.ReadAll(res.Body); err == nil {
+			t.Fatal("expected read error")
+		}
+		res.Body.Close()
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines = %d, want at most %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
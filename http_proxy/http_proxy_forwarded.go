@@ -0,0 +1,180 @@
+package http_proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ForwardingMode 决定转发请求时如何传递客户端地址
+type ForwardingMode string
+
+const (
+	// ForwardingTransparent 在 X-Forwarded-For 中追加客户端地址（默认）
+	ForwardingTransparent ForwardingMode = "transparent"
+
+	// ForwardingAnonymous 删除所有暴露客户端和代理链路的头
+	ForwardingAnonymous ForwardingMode = "anonymous"
+
+	// ForwardingRFC7239 在 RFC 7239 的 Forwarded 头中追加客户端地址、协议和主机
+	ForwardingRFC7239 ForwardingMode = "forwarded"
+)
+
+// anonymousHeaders 是匿名模式下删除的请求头
+var anonymousHeaders = []string{
+	"Forwarded",
+	"Via",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// ForwardingPolicy 控制转发请求时添加的 X-Forwarded-For、Forwarded 和 Via 头
+//
+// Pseudonym 不为空时在请求和响应中追加 Via 头（匿名模式不在请求中追加），
+// 收到的请求的 Via 头中已包含 Pseudonym 时视为转发环路，返回 508。
+// 串联的多个代理必须使用不同的 Pseudonym，否则后面的代理会把请求当作环路拒绝。
+type ForwardingPolicy struct {
+	Mode ForwardingMode
+
+	// Pseudonym Via 头中代理的名称，为空时不添加 Via 头，也不检测环路
+	Pseudonym string
+}
+
+// NewForwardingPolicy 创建转发头策略，mode 为空时使用透明模式
+func NewForwardingPolicy(mode, pseudonym string) (*ForwardingPolicy, error) {
+	m := ForwardingMode(strings.ToLower(mode))
+	switch m {
+	case "":
+		m = ForwardingTransparent
+	case ForwardingTransparent, ForwardingAnonymous, ForwardingRFC7239:
+	default:
+		return nil, fmt.Errorf("invalid forwarding mode %q", mode)
+	}
+	if pseudonym != "" && !validViaPseudonym(pseudonym) {
+		return nil, fmt.Errorf("invalid Via pseudonym %q", pseudonym)
+	}
+	return &ForwardingPolicy{Mode: m, Pseudonym: pseudonym}, nil
+}
+
+// validViaPseudonym 判断名称能否作为 Via 头中的 received-by（token 或 host:port）
+func validViaPseudonym(s string) bool {
+	host, port, hasPort := strings.Cut(s, ":")
+	return isToken(host) && (!hasPort || isToken(port))
+}
+
+// isToken 判断字符串是否为 RFC 7230 的 token
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply 根据策略修改转发给上游的请求头，req 为客户端的原始请求
+func (f *ForwardingPolicy) apply(outreq, req *http.Request) {
+	if f == nil {
+		addXForwardedForHeader(outreq)
+		return
+	}
+	switch f.Mode {
+	case ForwardingAnonymous:
+		// 不暴露代理链路，也不追加自己的 Via
+		for _, h := range anonymousHeaders {
+			outreq.Header.Del(h)
+		}
+		return
+	case ForwardingRFC7239:
+		addForwardedHeader(outreq, req)
+	default:
+		addXForwardedForHeader(outreq)
+	}
+	f.addVia(outreq.Header, req.ProtoMajor, req.ProtoMinor)
+}
+
+// applyResponse 在上游响应中追加 Via 头
+func (f *ForwardingPolicy) applyResponse(res *http.Response) {
+	if f != nil {
+		f.addVia(res.Header, res.ProtoMajor, res.ProtoMinor)
+	}
+}
+
+// addVia 追加 Via 头，协议版本为收到消息的版本
+func (f *ForwardingPolicy) addVia(h http.Header, major, minor int) {
+	if f.Pseudonym == "" {
+		return
+	}
+	version := "1.1"
+	switch {
+	case major >= 2:
+		version = strconv.Itoa(major)
+	case major == 1:
+		version = "1." + strconv.Itoa(minor)
+	}
+	entry := version + " " + f.Pseudonym
+	if prior := h.Values("Via"); len(prior) > 0 {
+		entry = strings.Join(prior, ", ") + ", " + entry
+	}
+	h.Set("Via", entry)
+}
+
+// Looped 判断请求是否已经经过本代理，即 Via 头中是否包含 Pseudonym
+func (f *ForwardingPolicy) Looped(h http.Header) bool {
+	if f == nil || f.Pseudonym == "" {
+		return false
+	}
+	for _, v := range h.Values("Via") {
+		for _, entry := range strings.Split(v, ",") {
+			// 每一项为 "协议版本 接收者 [注释]"
+			fields := strings.Fields(entry)
+			if len(fields) >= 2 && strings.EqualFold(fields[1], f.Pseudonym) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addForwardedHeader 追加 RFC 7239 的 Forwarded 头，如 for="[2001:db8::1]";proto=http;host=example.com
+func addForwardedHeader(outreq, req *http.Request) {
+	node := "unknown"
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		node = host
+		if strings.Contains(host, ":") {
+			node = `"[` + host + `]"`
+		}
+	}
+
+	proto := req.URL.Scheme
+	if proto == "" {
+		proto = "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+	}
+
+	element := "for=" + node + ";proto=" + proto
+	if req.Host != "" {
+		element += ";host=" + forwardedValue(req.Host)
+	}
+	if prior := outreq.Header.Values("Forwarded"); len(prior) > 0 {
+		element = strings.Join(prior, ", ") + ", " + element
+	}
+	outreq.Header.Set("Forwarded", element)
+}
+
+// forwardedValue 在值不是合法 token 时加上引号
+func forwardedValue(s string) string {
+	if isToken(s) {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
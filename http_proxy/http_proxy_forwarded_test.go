@@ -0,0 +1,214 @@
+package http_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewForwardingPolicy(t *testing.T) {
+	tests := []struct {
+		mode, pseudonym string
+		want            ForwardingMode
+		err             bool
+	}{
+		{"", "zaproxy", ForwardingTransparent, false},
+		{"Anonymous", "", ForwardingAnonymous, false},
+		{"forwarded", "proxy.example.com:3128", ForwardingRFC7239, false},
+		{"private", "zaproxy", "", true},
+		{"transparent", "my proxy", "", true},
+		{"transparent", "a,b", "", true},
+	}
+	for _, tt := range tests {
+		f, err := NewForwardingPolicy(tt.mode, tt.pseudonym)
+		if (err != nil) != tt.err {
+			t.Errorf("NewForwardingPolicy(%q, %q) error = %v, want error %v", tt.mode, tt.pseudonym, err, tt.err)
+			continue
+		}
+		if err == nil && f.Mode != tt.want {
+			t.Errorf("NewForwardingPolicy(%q) mode = %q, want %q", tt.mode, f.Mode, tt.want)
+		}
+	}
+}
+
+func TestForwardingPolicy_Looped(t *testing.T) {
+	f := &ForwardingPolicy{Pseudonym: "zaproxy"}
+	tests := []struct {
+		via  []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"1.1 cache.example.com"}, false},
+		{[]string{"1.0 fred, 1.1 ZAProxy"}, true},
+		{[]string{"1.1 cache (Squid/6.0)", "2 zaproxy"}, true},
+		{[]string{"1.1 zaproxy-edge"}, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{"Via": tt.via}
+		if got := f.Looped(h); got != tt.want {
+			t.Errorf("Looped(%q) = %v, want %v", tt.via, got, tt.want)
+		}
+	}
+	if (&ForwardingPolicy{}).Looped(http.Header{"Via": {"1.1 zaproxy"}}) {
+		t.Error("Looped() without pseudonym should be false")
+	}
+}
+
+func TestReverseProxy_Forwarding(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Via", "1.1 upstream")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	tests := []struct {
+		name     string
+		policy   *ForwardingPolicy
+		remote   string
+		want     map[string]string // 空字符串表示该头不存在
+		response string
+	}{
+		{
+			name:   "default",
+			policy: nil,
+			remote: "192.0.2.1:1234",
+			want:   map[string]string{"X-Forwarded-For": "10.0.0.1, 192.0.2.1", "Via": "1.0 client-cache", "Forwarded": ""},
+		},
+		{
+			name:     "transparent",
+			policy:   &ForwardingPolicy{Mode: ForwardingTransparent, Pseudonym: "zaproxy"},
+			remote:   "192.0.2.1:1234",
+			want:     map[string]string{"X-Forwarded-For": "10.0.0.1, 192.0.2.1", "Via": "1.0 client-cache, 1.1 zaproxy"},
+			response: "1.1 upstream, 1.1 zaproxy",
+		},
+		{
+			name:     "anonymous",
+			policy:   &ForwardingPolicy{Mode: ForwardingAnonymous, Pseudonym: "gw"},
+			remote:   "192.0.2.1:1234",
+			want:     map[string]string{"X-Forwarded-For": "", "X-Real-Ip": "", "Via": ""},
+			response: "1.1 upstream, 1.1 gw",
+		},
+		{
+			name:   "anonymous without via",
+			policy: &ForwardingPolicy{Mode: ForwardingAnonymous},
+			remote: "192.0.2.1:1234",
+			want:   map[string]string{"X-Forwarded-For": "", "Via": ""},
+		},
+		{
+			name:   "forwarded ipv6",
+			policy: &ForwardingPolicy{Mode: ForwardingRFC7239},
+			remote: "[2001:db8::1]:1234",
+			want: map[string]string{
+				"Forwarded":       `for=10.0.0.1, for="[2001:db8::1]";proto=http;host="example.com:8080"`,
+				"X-Forwarded-For": "10.0.0.1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewReverseProxy(backendURL)
+			proxy.Forwarding = tt.policy
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com:8080/", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-Forwarded-For", "10.0.0.1")
+			req.Header.Set("X-Real-Ip", "10.0.0.1")
+			req.Header.Set("Forwarded", "for=10.0.0.1")
+			req.Header.Set("Via", "1.0 client-cache")
+			if tt.policy != nil && tt.policy.Mode == ForwardingRFC7239 {
+				req.Header.Del("Via")
+			} else {
+				req.Header.Del("Forwarded")
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+
+			for h, want := range tt.want {
+				if v := got.Get(h); v != want {
+					t.Errorf("%s = %q, want %q", h, v, want)
+				}
+			}
+			if tt.response != "" {
+				if v := w.Header().Get("Via"); v != tt.response {
+					t.Errorf("response Via = %q, want %q", v, tt.response)
+				}
+			}
+		})
+	}
+}
+
+func TestReverseProxy_ForwardingLoop(t *testing.T) {
+	proxy := &ReverseProxy{
+		Forwarding:   &ForwardingPolicy{Mode: ForwardingTransparent, Pseudonym: "zaproxy"},
+		Auth:         &ProxyAuth{Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"}},
+		ConnectPorts: &PortPolicy{Ports: PortList{}},
+	}
+
+	// 环路在认证之前检测，转发的请求不会携带代理认证信息
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("Via", "1.1 zaproxy")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusLoopDetected {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusLoopDetected)
+	}
+
+	req = httptest.NewRequest(http.MethodConnect, "example.com:25", nil)
+	req.Header.Set("Via", "1.1 other")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusProxyAuthRequired {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+	}
+}
+
+func TestReverseProxy_ForwardingChain(t *testing.T) {
+	var via string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		via = r.Header.Get("Via")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	tests := []struct {
+		name           string
+		first, second  string
+		status         int
+		upstream, resp string
+	}{
+		{name: "without via", status: http.StatusOK},
+		{name: "distinct names", first: "gw-01", second: "gw-02", status: http.StatusOK,
+			upstream: "1.1 gw-01, 1.1 gw-02", resp: "1.1 gw-02, 1.1 gw-01"},
+		{name: "same name", first: "gw", second: "gw", status: http.StatusLoopDetected, resp: "1.1 gw"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			via = ""
+			// first 通过 second 访问 backend
+			proxy := NewReverseProxy(backendURL)
+			proxy.Forwarding = &ForwardingPolicy{Pseudonym: tt.second}
+			second := httptest.NewServer(proxy)
+			defer second.Close()
+			secondURL, _ := url.Parse(second.URL)
+			first := NewReverseProxy(backendURL)
+			first.Forwarding = &ForwardingPolicy{Pseudonym: tt.first}
+			first.Transport = &http.Transport{Proxy: http.ProxyURL(secondURL)}
+
+			req := httptest.NewRequest(http.MethodGet, backend.URL, nil)
+			w := httptest.NewRecorder()
+			first.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if via != tt.upstream || w.Header().Get("Via") != tt.resp {
+				t.Errorf("Via = %q upstream, %q in response, want %q and %q", via, w.Header().Get("Via"), tt.upstream, tt.resp)
+			}
+		})
+	}
+}
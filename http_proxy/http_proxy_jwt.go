@@ -0,0 +1,374 @@
+package http_proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // crypto.SHA384 和 crypto.SHA512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwk 是 JWKS 中的一个公钥（RFC 7517）
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtKey 是解析后的公钥
+type jwtKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// parseJWKS 解析 JWKS 文档中的签名公钥，支持 RSA、EC（P-256/P-384/P-521）和 Ed25519
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := make([]jwtKey, 0, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %d (%s): %w", i, k.Kid, err)
+		}
+		keys = append(keys, jwtKey{kid: k.Kid, alg: k.Alg, key: pub})
+	}
+	return keys, nil
+}
+
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := decodeB64(k.N)
+		e, err2 := decodeB64(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA key too short")
+		}
+		return pub, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := decodeB64(k.X)
+		y, err2 := decodeB64(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if err1 != nil || err2 != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC key")
+		}
+		// 通过 ecdh 校验点在曲线上
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		x, err := decodeB64(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// JWTAuthenticator 使用本地 JWKS 文件中的公钥校验 JWT
+//
+// 令牌必须包含 exp，并且签名、iss 和 aud 校验通过，
+// UserClaim 和 GroupsClaim 指定的声明分别映射为用户名和用户组。
+type JWTAuthenticator struct {
+	// JWKSPath JWKS 文件路径
+	JWKSPath string
+	// Issuer 要求的 iss，为空时不检查
+	Issuer string
+	// Audience 要求 aud 中包含的值，为空时不检查
+	Audience string
+	// UserClaim 映射为用户名的声明，默认为 sub
+	UserClaim string
+	// GroupsClaim 映射为用户组的声明，默认为 groups，值可以是字符串数组或以空格分隔的字符串
+	GroupsClaim string
+	// Leeway 检查 exp 和 nbf 时允许的时钟偏差
+	Leeway time.Duration
+
+	mu      sync.RWMutex
+	keys    []jwtKey
+	modTime time.Time
+}
+
+// NewJWTAuthenticator 创建 JWTAuthenticator 并加载 JWKS 文件
+func NewJWTAuthenticator(jwksPath string) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{JWKSPath: jwksPath, UserClaim: "sub", GroupsClaim: "groups", Leeway: 30 * time.Second}
+	if err := a.Load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Load 重新加载 JWKS 文件，加载失败时保留原有公钥
+func (a *JWTAuthenticator) Load() error {
+	info, err := os.Stat(a.JWKSPath)
+	if err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	data, err := os.ReadFile(a.JWKSPath)
+	if err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("jwks: no signing keys")
+	}
+
+	a.mu.Lock()
+	a.keys, a.modTime = keys, info.ModTime()
+	a.mu.Unlock()
+	return nil
+}
+
+// Len 返回公钥数
+func (a *JWTAuthenticator) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.keys)
+}
+
+// changed 判断 JWKS 文件自上次加载后是否被修改
+func (a *JWTAuthenticator) changed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return fileModified(a.JWKSPath, a.modTime)
+}
+
+// Run 每隔 interval 检查 JWKS 文件，有修改时重新加载，直到 ctx 结束
+func (a *JWTAuthenticator) Run(ctx context.Context, interval time.Duration) {
+	runReload(ctx, interval, a, "jwks", "keys")
+}
+
+// jwtHeader 是 JWT 的 JOSE 头
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// AuthenticateToken 实现 TokenAuthenticator
+func (a *JWTAuthenticator) AuthenticateToken(ctx context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+	headerJSON, err1 := decodeB64(parts[0])
+	payload, err2 := decodeB64(parts[1])
+	sig, err3 := decodeB64(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, ErrInvalidCredentials
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if !a.verifySignature(header, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidCredentials
+	}
+
+	var claims map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if err := a.checkClaims(claims, time.Now()); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	userClaim := a.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	username, _ := claims[userClaim].(string)
+	if username == "" {
+		return nil, ErrInvalidCredentials
+	}
+	groupsClaim := a.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	return &Identity{Username: username, Groups: claimStrings(claims[groupsClaim])}, nil
+}
+
+// verifySignature 使用与 kid 和 alg 匹配的公钥校验签名
+func (a *JWTAuthenticator) verifySignature(header jwtHeader, signed, sig []byte) bool {
+	a.mu.RLock()
+	keys := a.keys
+	a.mu.RUnlock()
+
+	for _, k := range keys {
+		if header.Kid != "" && k.kid != "" && header.Kid != k.kid {
+			continue
+		}
+		if k.alg != "" && k.alg != header.Alg {
+			continue
+		}
+		if verifyJWS(header.Alg, k.key, signed, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyJWS 校验 JWS 签名（RFC 7518），不支持 none 和对称密钥算法
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	if len(alg) < 5 {
+		return false
+	}
+	var h crypto.Hash
+	switch alg[len(alg)-3:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	}
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if h == 0 {
+			return false
+		}
+		hasher := h.New()
+		hasher.Write(signed)
+		digest := hasher.Sum(nil)
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, h, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(pub, h, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		want := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[pub.Curve.Params().Name]
+		if alg != want || len(sig) != 2*size {
+			return false
+		}
+		hasher := h.New()
+		hasher.Write(signed)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, hasher.Sum(nil), r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(pub, signed, sig)
+	}
+	return false
+}
+
+// checkClaims 检查 exp、nbf、iss 和 aud
+func (a *JWTAuthenticator) checkClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := claimTime(claims["exp"])
+	if !ok {
+		return errors.New("missing exp")
+	}
+	if now.After(exp.Add(a.Leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claimTime(claims["nbf"]); ok && now.Add(a.Leeway).Before(nbf) {
+		return errors.New("token not yet valid")
+	}
+	if a.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.Issuer {
+			return errors.New("issuer mismatch")
+		}
+	}
+	if a.Audience != "" {
+		found := false
+		for _, aud := range claimStrings(claims["aud"]) {
+			if aud == a.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("audience mismatch")
+		}
+	}
+	return nil
+}
+
+// claimTime 解析 NumericDate 声明
+func claimTime(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// claimStrings 将字符串或字符串数组声明转换为字符串切片，字符串按空格分隔
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
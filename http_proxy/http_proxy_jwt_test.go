@@ -0,0 +1,193 @@
+package http_proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signJWT 使用私钥签发 JWT
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "alg": "RS256",
+			"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+		// 加密用途的公钥不参与签名校验
+		{"kty": "EC", "kid": "enc", "use": "enc", "crv": "P-256",
+			"x": b64(otherKey.X.FillBytes(make([]byte, 32))), "y": b64(otherKey.Y.FillBytes(make([]byte, 32)))},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewJWTAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", a.Len())
+	}
+	a.Issuer = "https://idp.example.com"
+	a.Audience = "zaproxy"
+
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":    "alice",
+			"iss":    "https://idp.example.com",
+			"aud":    []string{"zaproxy", "other"},
+			"exp":    now + 300,
+			"groups": []string{"staff", "dev"},
+		}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	unsigned := func(alg string) string {
+		header, _ := json.Marshal(map[string]string{"alg": alg})
+		payload, _ := json.Marshal(claims(nil))
+		return b64(header) + "." + b64(payload) + "."
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", signJWT(t, "RS256", "rsa", rsaKey, claims(nil)), true},
+		{"ES256", signJWT(t, "ES256", "ec", ecKey, claims(nil)), true},
+		{"EdDSA", signJWT(t, "EdDSA", "ed", edKey, claims(nil)), true},
+		{"without kid", signJWT(t, "ES256", "", ecKey, claims(nil)), true},
+		{"audience string", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"aud": "zaproxy"})), true},
+		{"within leeway", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"exp": now - 10})), true},
+		{"expired", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"exp": now - 300})), false},
+		{"missing exp", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"exp": nil})), false},
+		{"not yet valid", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"nbf": now + 300})), false},
+		{"wrong issuer", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"iss": "https://evil.example.com"})), false},
+		{"wrong audience", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"aud": "other"})), false},
+		{"missing sub", signJWT(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"sub": nil})), false},
+		{"kid mismatch", signJWT(t, "ES256", "ed", ecKey, claims(nil)), false},
+		{"alg mismatch", signJWT(t, "RS256", "ec", rsaKey, claims(nil)), false},
+		{"unknown key", signJWT(t, "ES256", "", otherKey, claims(nil)), false},
+		{"encryption key", signJWT(t, "ES256", "enc", otherKey, claims(nil)), false},
+		{"alg none", unsigned("none"), false},
+		{"alg HS256", unsigned("HS256"), false},
+		{"malformed", "not-a-jwt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.AuthenticateToken(context.Background(), tt.token)
+			if !tt.ok {
+				if err != ErrInvalidCredentials {
+					t.Fatalf("error = %v, want ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id.Username != "alice" || !reflect.DeepEqual(id.Groups, []string{"staff", "dev"}) {
+				t.Errorf("identity = %+v", id)
+			}
+		})
+	}
+
+	// 自定义用户名和用户组声明，用户组可以是以空格分隔的字符串
+	a.UserClaim, a.GroupsClaim = "email", "roles"
+	token := signJWT(t, "EdDSA", "ed", edKey, claims(map[string]interface{}{"email": "bob@example.com", "roles": "admin ops"}))
+	id, err := a.AuthenticateToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Username != "bob@example.com" || !reflect.DeepEqual(id.Groups, []string{"admin", "ops"}) {
+		t.Errorf("identity = %+v", id)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	tests := []struct {
+		name string
+		jwks string
+	}{
+		{"invalid json", `{"keys":`},
+		{"short RSA key", `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`},
+		{"unsupported curve", `{"keys":[{"kty":"EC","crv":"P-192","x":"AA","y":"AA"}]}`},
+		{"point not on curve", `{"keys":[{"kty":"EC","crv":"P-256","x":"` + b64(make([]byte, 32)) + `","y":"` + b64(make([]byte, 32)) + `"}]}`},
+		{"symmetric key", `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`},
+	}
+	for _, tt := range tests {
+		if _, err := parseJWKS([]byte(tt.jwks)); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
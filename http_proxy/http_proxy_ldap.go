@@ -0,0 +1,310 @@
+package http_proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP 目录不可用时的处理策略
+const (
+	// LDAPFallbackDeny 拒绝认证，代理返回 503
+	LDAPFallbackDeny = "deny"
+	// LDAPFallbackCache 使用最近认证成功的结果，没有记录时拒绝
+	LDAPFallbackCache = "cache"
+)
+
+// LDAP 认证的默认参数
+const (
+	DefaultLDAPTimeout     = 5 * time.Second
+	DefaultLDAPFallbackTTL = time.Hour
+)
+
+// LDAPAuthenticator 使用 LDAP 目录认证用户
+//
+// 设置了 BindDN 时直接以用户身份绑定（bind-as-user），例如：
+//
+//	uid={username},ou=people,dc=example,dc=com
+//
+// 否则先以 SearchDN 绑定，在 BaseDN 下按 UserFilter 搜索用户，再以找到的 DN 和用户的密码绑定（search-then-bind）。
+// 用户组取自用户条目的 GroupAttribute 属性（如 memberOf），
+// 或在 GroupBaseDN 下按 GroupFilter 搜索，取组条目的 GroupNameAttribute 属性。
+// 模板中的 {username} 和 {dn} 分别替换为转义后的用户名和用户 DN。
+type LDAPAuthenticator struct {
+	// URLs 目录服务器地址，ldap:// 或 ldaps://，依次尝试直到连接成功
+	URLs []string
+	// StartTLS 在 ldap:// 连接上使用 StartTLS
+	StartTLS bool
+	// TLSConfig LDAPS 和 StartTLS 使用的 TLS 配置，为 nil 时使用系统根证书
+	TLSConfig *tls.Config
+	// Timeout 单次认证的超时时间
+	Timeout time.Duration
+
+	// BindDN 用户 DN 模板，设置后使用 bind-as-user
+	BindDN string
+	// SearchDN 和 SearchPassword 是搜索用户和组时使用的账号，为空时匿名搜索
+	SearchDN       string
+	SearchPassword string
+	// BaseDN 搜索用户的基准 DN
+	BaseDN string
+	// UserFilter 搜索用户的过滤器模板，如 (uid={username})
+	UserFilter string
+
+	// GroupAttribute 用户条目中记录所属组 DN 的属性，如 memberOf
+	GroupAttribute string
+	// GroupBaseDN 搜索组的基准 DN，为空时不搜索组
+	GroupBaseDN string
+	// GroupFilter 搜索组的过滤器模板，如 (member={dn})
+	GroupFilter string
+	// GroupNameAttribute 组名属性，默认为 cn
+	GroupNameAttribute string
+
+	// Fallback 目录不可用时的处理策略，默认为 LDAPFallbackDeny
+	Fallback string
+	// FallbackCache 使用 LDAPFallbackCache 时保存最近认证成功的结果
+	FallbackCache *IdentityCache
+}
+
+// NewLDAPAuthenticator 使用默认参数创建 LDAPAuthenticator
+func NewLDAPAuthenticator(urls ...string) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		URLs:               urls,
+		Timeout:            DefaultLDAPTimeout,
+		UserFilter:         "(uid={username})",
+		GroupFilter:        "(member={dn})",
+		GroupNameAttribute: "cn",
+		Fallback:           LDAPFallbackDeny,
+	}
+}
+
+// Authenticate 实现 Authenticator
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	// 空密码的简单绑定是匿名绑定（RFC 4513 第 5.1.2 节），会被服务器当作成功
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	id, err := a.authenticate(ctx, username, password)
+	if err == nil {
+		if a.FallbackCache != nil {
+			a.FallbackCache.Add(username, password, id)
+		}
+		return id, nil
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, err
+	}
+
+	if a.Fallback == LDAPFallbackCache && a.FallbackCache != nil {
+		if id, ok := a.FallbackCache.Get(username, password); ok {
+			log.Printf("ldap: directory unavailable, using cached identity for %s: %v", username, err)
+			return id, nil
+		}
+	}
+	return nil, err
+}
+
+// authenticate 连接目录并校验用户
+func (a *LDAPAuthenticator) authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLDAPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	// ctx 结束时中断正在进行的读写
+	stop := context.AfterFunc(ctx, func() { conn.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	var entry *ldapEntry
+	if a.BindDN != "" {
+		dn := strings.ReplaceAll(a.BindDN, "{username}", EscapeLDAPDN(username))
+		if err := conn.bind(dn, password); err != nil {
+			return nil, err
+		}
+		entry = &ldapEntry{dn: dn}
+		if a.GroupAttribute != "" {
+			entries, err := conn.search(dn, ldapScopeBase, "(objectClass=*)", 1, a.GroupAttribute)
+			if err != nil {
+				return nil, err
+			}
+			if len(entries) == 1 {
+				entry = &entries[0]
+			}
+		}
+	} else {
+		if err := conn.bind(a.SearchDN, a.SearchPassword); err != nil {
+			if errors.Is(err, ErrInvalidCredentials) {
+				// 服务账号无法绑定是配置错误，不是用户的密码错误
+				return nil, errors.New("ldap: search bind failed: invalid credentials")
+			}
+			return nil, err
+		}
+		filter := strings.ReplaceAll(a.UserFilter, "{username}", EscapeLDAPFilter(username))
+		var attrs []string
+		if a.GroupAttribute != "" {
+			attrs = append(attrs, a.GroupAttribute)
+		}
+		entries, err := conn.search(a.BaseDN, ldapScopeSubtree, filter, 2, attrs...)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 {
+			// 用户不存在或不唯一
+			return nil, ErrInvalidCredentials
+		}
+		entry = &entries[0]
+		if err := conn.bind(entry.dn, password); err != nil {
+			return nil, err
+		}
+	}
+
+	id := &Identity{Username: username}
+	if a.GroupAttribute != "" {
+		for _, dn := range entry.attrs[strings.ToLower(a.GroupAttribute)] {
+			id.Groups = append(id.Groups, ldapRDNValue(dn))
+		}
+	}
+	if a.GroupBaseDN != "" {
+		groups, err := a.searchGroups(conn, username, entry.dn)
+		if err != nil {
+			return nil, err
+		}
+		id.Groups = append(id.Groups, groups...)
+	}
+	return id, nil
+}
+
+// searchGroups 搜索用户所属的组
+func (a *LDAPAuthenticator) searchGroups(conn *ldapConn, username, dn string) ([]string, error) {
+	if a.BindDN == "" && a.SearchDN != "" {
+		// 以服务账号搜索，用户本身通常没有读取组的权限
+		if err := conn.bind(a.SearchDN, a.SearchPassword); err != nil {
+			return nil, fmt.Errorf("ldap: search bind failed: %w", err)
+		}
+	}
+	nameAttr := a.GroupNameAttribute
+	if nameAttr == "" {
+		nameAttr = "cn"
+	}
+	filter := strings.NewReplacer(
+		"{username}", EscapeLDAPFilter(username),
+		"{dn}", EscapeLDAPFilter(dn),
+	).Replace(a.GroupFilter)
+	entries, err := conn.search(a.GroupBaseDN, ldapScopeSubtree, filter, 0, nameAttr)
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, e := range entries {
+		if names := e.attrs[strings.ToLower(nameAttr)]; len(names) > 0 {
+			groups = append(groups, names[0])
+		} else {
+			groups = append(groups, ldapRDNValue(e.dn))
+		}
+	}
+	return groups, nil
+}
+
+// dial 依次连接目录服务器，返回第一个成功的连接
+func (a *LDAPAuthenticator) dial(ctx context.Context) (*ldapConn, error) {
+	if len(a.URLs) == 0 {
+		return nil, errors.New("ldap: no server configured")
+	}
+	var lastErr error
+	for _, rawURL := range a.URLs {
+		conn, err := a.dialURL(ctx, rawURL)
+		if err == nil {
+			return conn, nil
+		}
+		log.Printf("ldap: %s unavailable: %v", rawURL, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (a *LDAPAuthenticator) dialURL(ctx context.Context, rawURL string) (*ldapConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid url %q: %w", rawURL, err)
+	}
+	var defaultPort string
+	switch u.Scheme {
+	case "ldap":
+		defaultPort = "389"
+	case "ldaps":
+		defaultPort = "636"
+	default:
+		return nil, fmt.Errorf("ldap: unsupported url scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	config := a.TLSConfig.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(c, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return newLDAPConn(tlsConn), nil
+	}
+	conn := newLDAPConn(c)
+	if a.StartTLS {
+		if err := conn.startTLS(config); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("ldap: StartTLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// ldapRDNValue 返回 DN 中第一个 RDN 的值，如 cn=admins,ou=groups 返回 admins
+func ldapRDNValue(dn string) string {
+	rdn := dn
+	for i := 0; i < len(dn); i++ {
+		if dn[i] == '\\' {
+			i++
+			continue
+		}
+		if dn[i] == ',' {
+			rdn = dn[:i]
+			break
+		}
+	}
+	if eq := strings.IndexByte(rdn, '='); eq >= 0 {
+		rdn = rdn[eq+1:]
+	}
+	return strings.ReplaceAll(strings.TrimSpace(rdn), `\`, "")
+}
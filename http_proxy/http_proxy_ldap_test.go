@@ -0,0 +1,355 @@
+package http_proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ldapTestEntry 是测试目录中的条目
+type ldapTestEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// ldapTestServer 是用于测试的最小 LDAP 服务器，支持简单绑定、搜索和 StartTLS
+type ldapTestServer struct {
+	ln      net.Listener
+	entries []ldapTestEntry
+	tls     *tls.Config // 为 nil 时不支持 StartTLS
+	ldaps   bool
+}
+
+func newLDAPTestServer(t *testing.T, entries []ldapTestEntry, tlsConfig *tls.Config, ldaps bool) *ldapTestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ldaps {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	s := &ldapTestServer{ln: ln, entries: entries, tls: tlsConfig, ldaps: ldaps}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *ldapTestServer) url() string {
+	if s.ldaps {
+		return "ldaps://" + s.ln.Addr().String()
+	}
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func (s *ldapTestServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readBERMessage(r)
+		if err != nil {
+			return
+		}
+		parts, err := msg.children()
+		if err != nil || len(parts) < 2 {
+			return
+		}
+		id := berInt(berInteger, parts[0].int())
+		reply := func(op []byte) { c.Write(berEncode(berSequence, id, op)) }
+		result := func(tag byte, code int64) []byte {
+			return berEncode(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+		}
+
+		op := parts[1]
+		fields, _ := op.children()
+		switch op.tag {
+		case ldapBindRequest:
+			dn, password := string(fields[1].content), string(fields[2].content)
+			code := int64(ldapResultInvalidCreds)
+			if dn == "" && password == "" {
+				code = ldapResultSuccess
+			}
+			for _, e := range s.entries {
+				if strings.EqualFold(e.dn, dn) && e.password != "" && e.password == password {
+					code = ldapResultSuccess
+				}
+			}
+			reply(result(ldapBindResponse, code))
+
+		case ldapSearchRequest:
+			base, scope := strings.ToLower(string(fields[0].content)), fields[1].int()
+			attrList, _ := fields[7].children()
+			for _, e := range s.entries {
+				dn := strings.ToLower(e.dn)
+				inScope := dn == base || (scope == ldapScopeSubtree && strings.HasSuffix(dn, ","+base))
+				if !inScope || !ldapTestMatch(fields[6], e) {
+					continue
+				}
+				var attrs [][]byte
+				for _, a := range attrList {
+					var vals [][]byte
+					for _, v := range e.attrs[strings.ToLower(string(a.content))] {
+						vals = append(vals, berString(berOctetString, v))
+					}
+					attrs = append(attrs, berEncode(berSequence, berString(berOctetString, string(a.content)), berEncode(berSet, vals...)))
+				}
+				reply(berEncode(ldapSearchEntry, berString(berOctetString, e.dn), berEncode(berSequence, attrs...)))
+			}
+			reply(result(ldapSearchDone, ldapResultSuccess))
+
+		case ldapExtendedRequest:
+			if s.tls == nil || s.ldaps {
+				reply(result(ldapExtendedResponse, 2))
+				continue
+			}
+			reply(result(ldapExtendedResponse, ldapResultSuccess))
+			tlsConn := tls.Server(c, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			c, r = tlsConn, bufio.NewReader(tlsConn)
+
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+// ldapTestMatch 判断条目是否满足过滤器，只支持测试用到的 &、|、= 和 =*
+func ldapTestMatch(f berElement, e ldapTestEntry) bool {
+	switch f.tag {
+	case ldapFilterAnd, ldapFilterOr:
+		items, _ := f.children()
+		for _, item := range items {
+			if ldapTestMatch(item, e) != (f.tag == ldapFilterAnd) {
+				return f.tag != ldapFilterAnd
+			}
+		}
+		return f.tag == ldapFilterAnd
+	case ldapFilterPresent:
+		return strings.EqualFold(string(f.content), "objectClass") || len(e.attrs[strings.ToLower(string(f.content))]) > 0
+	case ldapFilterEquality:
+		ava, _ := f.children()
+		for _, v := range e.attrs[strings.ToLower(string(ava[0].content))] {
+			if strings.EqualFold(v, string(ava[1].content)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var ldapTestEntries = []ldapTestEntry{
+	{dn: "cn=svc,dc=example,dc=com", password: "svc-secret"},
+	{dn: "uid=alice,ou=people,dc=example,dc=com", password: "secret", attrs: map[string][]string{
+		"uid":      {"alice"},
+		"memberof": {"cn=staff,ou=groups,dc=example,dc=com"},
+	}},
+	{dn: "uid=bob,ou=people,dc=example,dc=com", password: "hunter2", attrs: map[string][]string{
+		"uid": {"bob"},
+	}},
+	{dn: "cn=admins,ou=groups,dc=example,dc=com", attrs: map[string][]string{
+		"cn":     {"admins"},
+		"member": {"uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"},
+	}},
+	{dn: "cn=dev,ou=groups,dc=example,dc=com", attrs: map[string][]string{
+		"cn":     {"dev"},
+		"member": {"uid=bob,ou=people,dc=example,dc=com"},
+	}},
+}
+
+func TestCompileLDAPFilter(t *testing.T) {
+	valid := []string{
+		"(uid=alice)",
+		"(&(objectClass=person)(uid=alice))",
+		"(|(member=uid=a\\2cb)(!(cn=x)))",
+		"(cn=*)",
+		"(cn=ad*m*s)",
+		"(uidNumber>=1000)",
+	}
+	for _, f := range valid {
+		if _, err := compileLDAPFilter(f); err != nil {
+			t.Errorf("compileLDAPFilter(%q) error = %v", f, err)
+		}
+	}
+	invalid := []string{"uid=alice", "(uid=alice", "(&(uid=a)", "(=x)", "(uid=a\\zz)", "(uid=a)(cn=b)"}
+	for _, f := range invalid {
+		if _, err := compileLDAPFilter(f); err == nil {
+			t.Errorf("compileLDAPFilter(%q) expected error", f)
+		}
+	}
+}
+
+func TestLDAPEscape(t *testing.T) {
+	if got, want := EscapeLDAPFilter("a*(b)\\"), `a\2a\28b\29\5c`; got != want {
+		t.Errorf("EscapeLDAPFilter = %q, want %q", got, want)
+	}
+	if got, want := EscapeLDAPDN(" a,b+c "), `\ a\,b\+c\ `; got != want {
+		t.Errorf("EscapeLDAPDN = %q, want %q", got, want)
+	}
+	if got := ldapRDNValue(`cn=a\,b,ou=groups`); got != "a,b" {
+		t.Errorf("ldapRDNValue = %q", got)
+	}
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	server := newLDAPTestServer(t, ldapTestEntries, nil, false)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		setup  func(a *LDAPAuthenticator)
+		user   string
+		pass   string
+		groups []string
+		err    error
+	}{
+		{"bind as user", func(a *LDAPAuthenticator) {
+			a.BindDN = "uid={username},ou=people,dc=example,dc=com"
+		}, "alice", "secret", nil, nil},
+		{"bind as user with memberOf", func(a *LDAPAuthenticator) {
+			a.BindDN = "uid={username},ou=people,dc=example,dc=com"
+			a.GroupAttribute = "memberOf"
+		}, "alice", "secret", []string{"staff"}, nil},
+		{"bind as user wrong password", func(a *LDAPAuthenticator) {
+			a.BindDN = "uid={username},ou=people,dc=example,dc=com"
+		}, "alice", "wrong", nil, ErrInvalidCredentials},
+		{"empty password", func(a *LDAPAuthenticator) {
+			a.BindDN = "uid={username},ou=people,dc=example,dc=com"
+		}, "alice", "", nil, ErrInvalidCredentials},
+		{"search then bind with groups", func(a *LDAPAuthenticator) {
+			a.GroupBaseDN = "ou=groups,dc=example,dc=com"
+		}, "bob", "hunter2", []string{"admins", "dev"}, nil},
+		{"search then bind unknown user", nil, "carol", "secret", nil, ErrInvalidCredentials},
+		{"filter injection", nil, "*", "secret", nil, ErrInvalidCredentials},
+		{"search account rejected", func(a *LDAPAuthenticator) {
+			a.SearchPassword = "wrong"
+		}, "bob", "hunter2", nil, errors.New("search bind failed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewLDAPAuthenticator(server.url())
+			a.SearchDN, a.SearchPassword = "cn=svc,dc=example,dc=com", "svc-secret"
+			a.BaseDN = "ou=people,dc=example,dc=com"
+			if tt.setup != nil {
+				tt.setup(a)
+			}
+			id, err := a.Authenticate(ctx, tt.user, tt.pass)
+			switch {
+			case tt.err == ErrInvalidCredentials:
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("error = %v, want ErrInvalidCredentials", err)
+				}
+			case tt.err != nil:
+				if err == nil || errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), tt.err.Error()) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			default:
+				if id.Username != tt.user || !reflect.DeepEqual(id.Groups, tt.groups) {
+					t.Errorf("identity = %+v, want %s %v", id, tt.user, tt.groups)
+				}
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticator_TLS(t *testing.T) {
+	// 借用 httptest 的自签名证书（对 127.0.0.1 有效）
+	https := httptest.NewTLSServer(http.NotFoundHandler())
+	defer https.Close()
+	serverTLS := &tls.Config{Certificates: https.TLS.Certificates}
+	roots := x509.NewCertPool()
+	roots.AddCert(https.Certificate())
+
+	for _, ldaps := range []bool{false, true} {
+		server := newLDAPTestServer(t, ldapTestEntries, serverTLS, ldaps)
+		a := NewLDAPAuthenticator(server.url())
+		a.StartTLS = !ldaps
+		a.BindDN = "uid={username},ou=people,dc=example,dc=com"
+		a.TLSConfig = &tls.Config{RootCAs: roots}
+		if _, err := a.Authenticate(context.Background(), "alice", "secret"); err != nil {
+			t.Errorf("ldaps=%v: unexpected error: %v", ldaps, err)
+		}
+
+		// 不信任服务器证书
+		a.TLSConfig = &tls.Config{RootCAs: x509.NewCertPool()}
+		if _, err := a.Authenticate(context.Background(), "alice", "secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("ldaps=%v: expected TLS error, got %v", ldaps, err)
+		}
+	}
+
+	// 服务器不支持 StartTLS
+	server := newLDAPTestServer(t, ldapTestEntries, nil, false)
+	a := NewLDAPAuthenticator(server.url())
+	a.StartTLS = true
+	a.BindDN = "uid={username},ou=people,dc=example,dc=com"
+	if _, err := a.Authenticate(context.Background(), "alice", "secret"); err == nil || !strings.Contains(err.Error(), "StartTLS") {
+		t.Errorf("expected StartTLS error, got %v", err)
+	}
+}
+
+func TestLDAPAuthenticator_Fallback(t *testing.T) {
+	// 保留一个已关闭的端口作为不可用的服务器
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := "ldap://" + ln.Addr().String()
+	ln.Close()
+
+	server := newLDAPTestServer(t, ldapTestEntries, nil, false)
+	a := NewLDAPAuthenticator(down, server.url())
+	a.BindDN = "uid={username},ou=people,dc=example,dc=com"
+	a.Timeout = time.Second
+	a.Fallback = LDAPFallbackCache
+	a.FallbackCache = NewIdentityCache(10, time.Minute)
+	ctx := context.Background()
+
+	// 第一个服务器不可用时使用下一个
+	if _, err := a.Authenticate(ctx, "alice", "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 所有服务器都不可用时使用最近认证成功的结果
+	a.URLs = []string{down}
+	if id, err := a.Authenticate(ctx, "alice", "secret"); err != nil || id.Username != "alice" {
+		t.Errorf("cache fallback = %v, %v", id, err)
+	}
+	if _, err := a.Authenticate(ctx, "alice", "wrong"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected directory error for uncached credentials, got %v", err)
+	}
+
+	a.Fallback = LDAPFallbackDeny
+	if _, err := a.Authenticate(ctx, "alice", "secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected directory error with deny policy, got %v", err)
+	}
+
+	// 通过代理认证时目录不可用返回 503
+	proxy := &ReverseProxy{Auth: &ProxyAuth{Authenticator: a}}
+	req := httptest.NewRequest(http.MethodConnect, "example.com:443", nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth("alice", "secret"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,564 @@
+package http_proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// LDAP 协议（RFC 4511）的最小实现，只包含认证需要的 Bind、Search、StartTLS 和 Unbind
+
+// BER 标签
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+)
+
+// LDAP 协议操作的标签
+const (
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapSearchReference  = 0x73
+	ldapExtendedRequest  = 0x77
+	ldapExtendedResponse = 0x78
+	ldapAuthSimple       = 0x80
+	ldapExtendedName     = 0x80
+)
+
+// 搜索过滤器的标签
+const (
+	ldapFilterAnd        = 0xa0
+	ldapFilterOr         = 0xa1
+	ldapFilterNot        = 0xa2
+	ldapFilterEquality   = 0xa3
+	ldapFilterSubstrings = 0xa4
+	ldapFilterGreater    = 0xa5
+	ldapFilterLess       = 0xa6
+	ldapFilterPresent    = 0x87
+	ldapFilterApprox     = 0xa8
+	ldapSubstringInitial = 0x80
+	ldapSubstringAny     = 0x81
+	ldapSubstringFinal   = 0x82
+)
+
+const (
+	ldapProtocolVersion    = 3
+	ldapStartTLSOID        = "1.3.6.1.4.1.1466.20037"
+	ldapMaxMessageSize     = 4 << 20
+	ldapScopeBase          = 0
+	ldapScopeSubtree       = 2
+	ldapDerefNever         = 0
+	ldapSearchTimeLimit    = 10 // 秒
+	ldapResultSuccess      = 0
+	ldapResultNoSuchObj    = 32
+	ldapResultInvalidCreds = 49
+)
+
+// berElement 是解码后的 BER 元素
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+// berEncode 编码一个 BER 元素
+func berEncode(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	out := []byte{tag}
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	case n < 0x10000:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berInt(tag byte, v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if v >= -0x80 && v < 0x80 {
+			return berEncode(tag, b)
+		}
+		v >>= 8
+	}
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return berEncode(berBoolean, []byte{0xff})
+	}
+	return berEncode(berBoolean, []byte{0})
+}
+
+// berParse 解析 b 开头的一个元素，返回剩余的数据
+func berParse(b []byte) (berElement, []byte, error) {
+	if len(b) < 2 {
+		return berElement{}, nil, errors.New("ldap: truncated BER element")
+	}
+	tag, n := b[0], int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return berElement{}, nil, errors.New("ldap: invalid BER length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n < 0 || n > len(b) {
+		return berElement{}, nil, errors.New("ldap: truncated BER element")
+	}
+	return berElement{tag: tag, content: b[:n]}, b[n:], nil
+}
+
+// children 解析构造类型元素中的所有子元素
+func (e berElement) children() ([]berElement, error) {
+	var out []berElement
+	for b := e.content; len(b) > 0; {
+		child, rest, err := berParse(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, child)
+		b = rest
+	}
+	return out, nil
+}
+
+func (e berElement) int() int64 {
+	var v int64
+	for i, c := range e.content {
+		if i == 0 && c&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+// readBERMessage 从连接读取一个完整的 BER 元素
+func readBERMessage(r *bufio.Reader) (berElement, error) {
+	head := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return berElement{}, err
+	}
+	n := int(head[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return berElement{}, errors.New("ldap: invalid BER length")
+		}
+		head = head[:2+size]
+		if _, err := io.ReadFull(r, head[2:]); err != nil {
+			return berElement{}, err
+		}
+		n = 0
+		for _, c := range head[2:] {
+			n = n<<8 | int(c)
+		}
+	}
+	if n > ldapMaxMessageSize {
+		return berElement{}, fmt.Errorf("ldap: message too large (%d bytes)", n)
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag: head[0], content: content}, nil
+}
+
+// LDAPResultError 是 LDAP 服务器返回的错误结果
+type LDAPResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *LDAPResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// ldapConn 是一个 LDAP 连接
+type ldapConn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	msgID int64
+}
+
+func newLDAPConn(conn net.Conn) *ldapConn {
+	return &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send 发送一个请求并返回消息 ID
+func (c *ldapConn) send(op []byte) (int64, error) {
+	c.msgID++
+	msg := berEncode(berSequence, berInt(berInteger, c.msgID), op)
+	_, err := c.conn.Write(msg)
+	return c.msgID, err
+}
+
+// receive 读取一个响应，返回协议操作
+func (c *ldapConn) receive(id int64) (berElement, error) {
+	for {
+		msg, err := readBERMessage(c.r)
+		if err != nil {
+			return berElement{}, err
+		}
+		parts, err := msg.children()
+		if err != nil {
+			return berElement{}, err
+		}
+		if msg.tag != berSequence || len(parts) < 2 {
+			return berElement{}, errors.New("ldap: invalid message")
+		}
+		// 消息 ID 为 0 的是未经请求的通知（如服务器即将断开），其他不匹配的响应忽略
+		if parts[0].int() == 0 {
+			if err := ldapResult(parts[1]); err != nil {
+				return berElement{}, err
+			}
+			return berElement{}, errors.New("ldap: unexpected notice of disconnection")
+		}
+		if parts[0].int() == id {
+			return parts[1], nil
+		}
+	}
+}
+
+// ldapResult 解析 LDAPResult，结果码不为 0 时返回 *LDAPResultError
+func ldapResult(op berElement) error {
+	parts, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(parts) < 3 {
+		return errors.New("ldap: invalid result")
+	}
+	if code := parts[0].int(); code != ldapResultSuccess {
+		return &LDAPResultError{Code: code, Message: string(parts[2].content)}
+	}
+	return nil
+}
+
+// bind 使用简单认证绑定，密码错误时返回 ErrInvalidCredentials
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(berEncode(ldapBindRequest,
+		berInt(berInteger, ldapProtocolVersion),
+		berString(berOctetString, dn),
+		berString(ldapAuthSimple, password)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return errors.New("ldap: unexpected bind response")
+	}
+	err = ldapResult(op)
+	var resultErr *LDAPResultError
+	if errors.As(err, &resultErr) && (resultErr.Code == ldapResultInvalidCreds || resultErr.Code == ldapResultNoSuchObj) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// startTLS 发送 StartTLS 扩展操作并在连接上开始 TLS 握手
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	id, err := c.send(berEncode(ldapExtendedRequest, berString(ldapExtendedName, ldapStartTLSOID)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapExtendedResponse {
+		return errors.New("ldap: unexpected StartTLS response")
+	}
+	if err := ldapResult(op); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+	return nil
+}
+
+// ldapEntry 是搜索结果中的一个条目
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// search 执行搜索，最多返回 sizeLimit 个条目（0 表示不限制）
+func (c *ldapConn) search(base string, scope int64, filter string, sizeLimit int64, attrs ...string) ([]ldapEntry, error) {
+	f, err := compileLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrList := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		attrList[i] = berString(berOctetString, a)
+	}
+	id, err := c.send(berEncode(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, ldapDerefNever),
+		berInt(berInteger, sizeLimit),
+		berInt(berInteger, ldapSearchTimeLimit),
+		berBool(false),
+		f,
+		berEncode(berSequence, attrList...)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchEntry:
+			entry, err := parseLDAPEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchReference:
+			// 不跟随引用
+		case ldapSearchDone:
+			return entries, ldapResult(op)
+		default:
+			return nil, errors.New("ldap: unexpected search response")
+		}
+	}
+}
+
+func parseLDAPEntry(op berElement) (ldapEntry, error) {
+	parts, err := op.children()
+	if err != nil || len(parts) < 2 {
+		return ldapEntry{}, errors.New("ldap: invalid search entry")
+	}
+	entry := ldapEntry{dn: string(parts[0].content), attrs: make(map[string][]string)}
+	attrs, err := parts[1].children()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	for _, attr := range attrs {
+		fields, err := attr.children()
+		if err != nil || len(fields) < 2 {
+			return ldapEntry{}, errors.New("ldap: invalid attribute")
+		}
+		values, err := fields[1].children()
+		if err != nil {
+			return ldapEntry{}, err
+		}
+		name := strings.ToLower(string(fields[0].content))
+		for _, v := range values {
+			entry.attrs[name] = append(entry.attrs[name], string(v.content))
+		}
+	}
+	return entry, nil
+}
+
+// close 发送 Unbind 并关闭连接
+func (c *ldapConn) close() error {
+	c.send(berEncode(ldapUnbindRequest))
+	return c.conn.Close()
+}
+
+// compileLDAPFilter 将字符串形式的搜索过滤器（RFC 4515）编码为 BER
+func compileLDAPFilter(filter string) ([]byte, error) {
+	f, rest, err := parseLDAPFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: invalid filter %q", filter)
+	}
+	return f, nil
+}
+
+func parseLDAPFilter(s string) ([]byte, string, error) {
+	if len(s) < 3 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: invalid filter %q", s)
+	}
+	s = s[1:]
+	switch s[0] {
+	case '&', '|':
+		tag := byte(ldapFilterAnd)
+		if s[0] == '|' {
+			tag = ldapFilterOr
+		}
+		var items [][]byte
+		for s = s[1:]; strings.HasPrefix(s, "("); {
+			item, rest, err := parseLDAPFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			items, s = append(items, item), rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("ldap: missing ')' in filter")
+		}
+		return berEncode(tag, items...), s[1:], nil
+	case '!':
+		item, rest, err := parseLDAPFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: missing ')' in filter")
+		}
+		return berEncode(ldapFilterNot, item), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: missing ')' in filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(ldapFilterEquality)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = ldapFilterGreater, attr[:len(attr)-1]
+	case '<':
+		tag, attr = ldapFilterLess, attr[:len(attr)-1]
+	case '~':
+		tag, attr = ldapFilterApprox, attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, "", fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+
+	if tag == ldapFilterEquality && value == "*" {
+		return berString(ldapFilterPresent, attr), rest, nil
+	}
+	if tag == ldapFilterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		var subs [][]byte
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := unescapeLDAPFilterValue(part)
+			if err != nil {
+				return nil, "", err
+			}
+			subTag := byte(ldapSubstringAny)
+			switch i {
+			case 0:
+				subTag = ldapSubstringInitial
+			case len(parts) - 1:
+				subTag = ldapSubstringFinal
+			}
+			subs = append(subs, berString(subTag, v))
+		}
+		return berEncode(ldapFilterSubstrings, berString(berOctetString, attr), berEncode(berSequence, subs...)), rest, nil
+	}
+
+	v, err := unescapeLDAPFilterValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berEncode(tag, berString(berOctetString, attr), berString(berOctetString, v)), rest, nil
+}
+
+// unescapeLDAPFilterValue 解码过滤器值中的 \XX 转义
+func unescapeLDAPFilterValue(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("ldap: invalid escape in filter value %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in filter value %q", s)
+		}
+		b.WriteByte(c[0])
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// EscapeLDAPFilter 转义搜索过滤器中的值（RFC 4515）
+func EscapeLDAPFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EscapeLDAPDN 转义 DN 中的属性值（RFC 4514）
+func EscapeLDAPDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(s)-1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
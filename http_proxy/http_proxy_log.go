@@ -0,0 +1,115 @@
+package http_proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// responseRecorder 记录响应状态码和写入的字节数，用于访问日志
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if fl, ok := r.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hij, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("http server does not support hijacker")
+	}
+	conn, brw, err := hij.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, brw, err
+}
+
+func (r *responseRecorder) CloseNotify() <-chan bool {
+	if cn, ok := r.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+// setHijackedStatus 把直接写入被劫持连接的状态码记录到 w 及其包装的 responseRecorder 中
+func setHijackedStatus(w http.ResponseWriter, code int) {
+	for {
+		rec, ok := w.(*responseRecorder)
+		if !ok {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = code
+		}
+		w = rec.ResponseWriter
+	}
+}
+
+// logAccess 以 Combined Log Format 记录一条访问日志，末尾附加请求耗时（秒）
+func (p *ReverseProxy) logAccess(req *http.Request, rec *responseRecorder, start time.Time) {
+	host := req.RemoteAddr
+	if h, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		host = h
+	}
+
+	user := "-"
+	if id, ok := IdentityFromContext(req.Context()); ok && id.Username != "" {
+		user = id.Username
+	}
+
+	status := rec.status
+	if status == 0 && rec.hijacked {
+		// CONNECT 隧道的响应直接写入被劫持的连接
+		status = http.StatusOK
+	}
+
+	size := "-"
+	if rec.bytes > 0 {
+		size = strconv.FormatInt(rec.bytes, 10)
+	}
+
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.String()
+	}
+
+	p.AccessLog.Printf("%s - %s [%s] %q %d %s %q %q %.3f",
+		host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method+" "+uri+" "+req.Proto,
+		status, size, orDash(req.Referer()), orDash(req.UserAgent()),
+		time.Since(start).Seconds())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
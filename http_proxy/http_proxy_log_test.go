@@ -0,0 +1,121 @@
+package http_proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseProxy_AccessLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "hello")
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	proxy := NewReverseProxy(backendURL)
+	proxy.AccessLog = log.New(&buf, "", 0)
+
+	req := httptest.NewRequest("GET", "http://example.com/test", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req = req.WithContext(WithIdentity(req.Context(), &Identity{Username: "alice"}))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	line := buf.String()
+	for _, want := range []string{
+		"192.0.2.1 - alice [",
+		`"GET http://example.com/test HTTP/1.1" 201 5`,
+		`"test-agent"`,
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("access log %q does not contain %q", line, want)
+		}
+	}
+}
+
+// logLines 把每条日志发送到通道，日志在处理器返回之后才写入
+type logLines chan string
+
+func (l logLines) Write(b []byte) (int, error) {
+	l <- string(b)
+	return len(b), nil
+}
+
+func TestReverseProxy_AccessLogTunnel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	listening := ln.Addr().String()
+
+	// 关闭后的端口拒绝连接
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln2.Addr().String()
+	ln2.Close()
+
+	tests := []struct {
+		name   string
+		target string
+		policy *DestinationPolicy
+		want   int
+	}{
+		{"destination denied", listening, DefaultDestinationPolicy(), http.StatusForbidden},
+		{"dial error", closed, nil, http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := make(logLines, 1)
+			proxy := NewReverseProxy(&url.URL{Scheme: "http", Host: tt.target})
+			proxy.DestinationPolicy = tt.policy
+			proxy.AccessLog = log.New(lines, "", 0)
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", tt.target, tt.target)
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			if res.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.want)
+			}
+
+			select {
+			case line := <-lines:
+				want := fmt.Sprintf(`"CONNECT %s HTTP/1.1" %d `, tt.target, tt.want)
+				if !strings.Contains(line, want) {
+					t.Errorf("access log %q does not contain %q", line, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no access log written")
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package http_proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// localAddrsTTL 是本机网卡地址的缓存时间
+const localAddrsTTL = 30 * time.Second
+
+// LoopDetector 识别目标为代理自身监听地址的请求和隧道，防止代理把请求转发给自己
+//
+// 只有目标端口是监听端口时才解析目标主机，其他请求没有额外开销。
+// 监听地址为未指定地址（如 :8080）时，本机回环地址和所有网卡地址都视为自身地址。
+type LoopDetector struct {
+	// Listen 代理的监听地址
+	Listen []*net.TCPAddr
+
+	// Resolver 解析目标主机名，为 nil 时使用 net.DefaultResolver
+	Resolver *net.Resolver
+
+	mu     sync.Mutex
+	local  []net.IP
+	loaded time.Time
+}
+
+// NewLoopDetector 根据监听器的地址创建环路检测器，忽略非 TCP 地址
+func NewLoopDetector(addrs ...net.Addr) *LoopDetector {
+	d := &LoopDetector{}
+	for _, addr := range addrs {
+		if tcp, ok := addr.(*net.TCPAddr); ok {
+			d.Listen = append(d.Listen, tcp)
+		}
+	}
+	return d
+}
+
+// localAddrs 返回本机网卡地址，定期刷新以适应地址变化
+func (d *LoopDetector) localAddrs() []net.IP {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.local != nil && time.Since(d.loaded) < localAddrsTTL {
+		return d.local
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil && d.local != nil {
+		return d.local
+	}
+	local := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok {
+			local = append(local, normalizeIP(n.IP))
+		}
+	}
+	d.local, d.loaded = local, time.Now()
+	return d.local
+}
+
+// isSelfIP 判断连接 ip:port 是否会连接到代理自身
+func (d *LoopDetector) isSelfIP(ip net.IP, port int) bool {
+	ip = normalizeIP(ip)
+	for _, l := range d.Listen {
+		if l.Port != port {
+			continue
+		}
+		// 连接未指定地址等同于连接本机
+		if ip.IsUnspecified() || ip.Equal(normalizeIP(l.IP)) {
+			return true
+		}
+		if l.IP == nil || l.IP.IsUnspecified() {
+			if ip.IsLoopback() {
+				return true
+			}
+			for _, local := range d.localAddrs() {
+				if ip.Equal(local) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// IsSelf 判断目标 host:port 是否为代理自身，主机名解析为多个地址时任一地址匹配即视为自身
+func (d *LoopDetector) IsSelf(ctx context.Context, host string, port int) bool {
+	if d == nil || host == "" {
+		return false
+	}
+	listening := false
+	for _, l := range d.Listen {
+		if l.Port == port {
+			listening = true
+			break
+		}
+	}
+	if !listening {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return d.isSelfIP(ip, port)
+	}
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		// 解析失败时交给拨号处理
+		return false
+	}
+	for _, ip := range ips {
+		if d.isSelfIP(ip.IP, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopTargetPort 返回请求的目标端口，直接发送给代理的请求（origin-form）以 Host 头为目标
+func loopTargetPort(req *http.Request) int {
+	if req.Method == http.MethodConnect || req.URL.Host != "" {
+		return requestPort(req)
+	}
+	if _, port, err := net.SplitHostPort(req.Host); err == nil {
+		n, _ := strconv.Atoi(port)
+		return n
+	}
+	return 80
+}
+
+// checkLoop 检查转发环路，发现环路时写入 508 响应并返回 false
+//
+// 请求的 Via 头包含本代理的名称，或目标为代理自身的监听地址时视为环路。
+func (p *ReverseProxy) checkLoop(rw http.ResponseWriter, req *http.Request) bool {
+	return p.checkVia(rw, req) && p.checkSelf(rw, req)
+}
+
+// checkVia 检查请求的 Via 头是否包含本代理的名称，只比较请求头，不需要解析目标
+func (p *ReverseProxy) checkVia(rw http.ResponseWriter, req *http.Request) bool {
+	if !p.Forwarding.Looped(req.Header) {
+		return true
+	}
+	p.loopDetected(rw, req, "via "+req.Header.Get("Via"))
+	return false
+}
+
+// checkSelf 检查目标是否为代理自身的监听地址，可能需要解析域名和枚举网络接口
+func (p *ReverseProxy) checkSelf(rw http.ResponseWriter, req *http.Request) bool {
+	if !p.LoopDetector.IsSelf(req.Context(), requestHostname(req), loopTargetPort(req)) {
+		return true
+	}
+	p.loopDetected(rw, req, "target is this proxy")
+	return false
+}
+
+// loopDetected 记录检测到的环路并写入 508 响应
+func (p *ReverseProxy) loopDetected(rw http.ResponseWriter, req *http.Request, reason string) {
+	p.logf("http: proxy loop detected: %s %s from %s: %s", req.Method, req.Host, req.RemoteAddr, reason)
+	rw.Header().Set("Connection", "close")
+	http.Error(rw, "Loop Detected", http.StatusLoopDetected)
+}
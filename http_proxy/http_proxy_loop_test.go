@@ -0,0 +1,111 @@
+package http_proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoopDetector_IsSelf(t *testing.T) {
+	any := NewLoopDetector(&net.TCPAddr{Port: 8080})
+	bound := NewLoopDetector(&net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 3128}, &net.UnixAddr{Name: "/tmp/x"})
+
+	tests := []struct {
+		name     string
+		detector *LoopDetector
+		host     string
+		port     int
+		want     bool
+	}{
+		{"loopback", any, "127.0.0.1", 8080, true},
+		{"ipv6 loopback", any, "::1", 8080, true},
+		{"unspecified", any, "0.0.0.0", 8080, true},
+		{"localhost", any, "localhost", 8080, true},
+		{"other port", any, "127.0.0.1", 8081, false},
+		{"remote address", any, "203.0.113.1", 8080, false},
+		{"bound address", bound, "127.0.0.2", 3128, true},
+		{"other loopback address", bound, "127.0.0.1", 3128, false},
+		{"nil detector", nil, "127.0.0.1", 8080, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.detector.IsSelf(context.Background(), tt.host, tt.port); got != tt.want {
+				t.Errorf("IsSelf(%s, %d) = %v, want %v", tt.host, tt.port, got, tt.want)
+			}
+		})
+	}
+	if len(bound.Listen) != 1 {
+		t.Errorf("Listen = %v, want only the TCP address", bound.Listen)
+	}
+
+	// 监听未指定地址时，本机网卡地址也是自身地址
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && !n.IP.IsLoopback() {
+			if !any.IsSelf(context.Background(), n.IP.String(), 8080) {
+				t.Errorf("IsSelf(%s) = false for a local interface address", n.IP)
+			}
+			break
+		}
+	}
+}
+
+func TestReverseProxy_LoopDetector(t *testing.T) {
+	proxy := &ReverseProxy{
+		LoopDetector: NewLoopDetector(&net.TCPAddr{Port: 8080}),
+		ConnectPorts: &PortPolicy{Ports: PortList{}},
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"connect to self", http.MethodConnect, "127.0.0.1:8080", http.StatusLoopDetected},
+		{"connect elsewhere", http.MethodConnect, "127.0.0.1:8081", http.StatusForbidden},
+		{"request to self", http.MethodGet, "http://localhost:8080/", http.StatusLoopDetected},
+		{"origin-form request", http.MethodGet, "/", http.StatusLoopDetected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.target == "/" {
+				// 直接发送给代理的请求，Host 为代理自身
+				req.Host = "127.0.0.1:8080"
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseProxy_LoopDetectorAfterAuth(t *testing.T) {
+	proxy := &ReverseProxy{
+		LoopDetector: NewLoopDetector(&net.TCPAddr{Port: 8080}),
+		Auth: &ProxyAuth{
+			Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"},
+		},
+	}
+
+	// 未认证的客户端不会触发解析目标的环路检查
+	req := httptest.NewRequest(http.MethodConnect, "127.0.0.1:8080", nil)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusProxyAuthRequired {
+		t.Fatalf("unauthenticated: status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+	}
+
+	req = httptest.NewRequest(http.MethodConnect, "127.0.0.1:8080", nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth("alice", "secret"))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusLoopDetected {
+		t.Fatalf("authenticated: status = %d, want %d", w.Code, http.StatusLoopDetected)
+	}
+}
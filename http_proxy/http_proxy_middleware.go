@@ -0,0 +1,180 @@
+package http_proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ProxyContext 是单个请求或隧道在拦截器之间共享的上下文
+//
+// ServeHTTP 在收到请求时创建 ProxyContext 并保存在请求的上下文中，
+// Director 等回调可以通过 ProxyContextFrom 获取。
+type ProxyContext struct {
+	// Request 客户端的原始请求
+	Request *http.Request
+
+	// Identity 认证后的用户身份，未认证或免认证时为 nil
+	Identity *Identity
+
+	// Tunnel 为 true 表示 CONNECT 隧道
+	Tunnel bool
+
+	// Timings 请求各阶段的时间
+	Timings ProxyTimings
+
+	mu     sync.Mutex
+	values map[string]any
+	err    error
+}
+
+// ProxyTimings 记录请求各阶段开始的时间，未到达的阶段为零值
+type ProxyTimings struct {
+	// Start 开始处理请求的时间
+	Start time.Time
+
+	// Upstream 开始向上游发送请求或建立隧道连接的时间
+	Upstream time.Time
+
+	// FirstByte 收到上游响应头或隧道建立的时间
+	FirstByte time.Time
+}
+
+// Set 保存一个值，供之后的拦截器使用
+func (c *ProxyContext) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[string]any)
+	}
+	c.values[key] = value
+}
+
+// Get 返回 Set 保存的值
+func (c *ProxyContext) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	return v, ok
+}
+
+// fail 记录代理过程中的错误，只保留第一个
+func (c *ProxyContext) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// failure 返回 fail 记录的错误
+func (c *ProxyContext) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Elapsed 返回从开始处理请求到现在的时间
+func (c *ProxyContext) Elapsed() time.Duration {
+	return time.Since(c.Timings.Start)
+}
+
+type proxyContextKey struct{}
+
+// ProxyContextFrom 从上下文中获取 ProxyContext
+func ProxyContextFrom(ctx context.Context) (*ProxyContext, bool) {
+	pc, ok := ctx.Value(proxyContextKey{}).(*ProxyContext)
+	return pc, ok && pc != nil
+}
+
+// withProxyContext 返回请求的 ProxyContext，请求中没有时创建一个并返回携带它的请求
+func withProxyContext(req *http.Request, start time.Time) (*http.Request, *ProxyContext) {
+	if pc, ok := ProxyContextFrom(req.Context()); ok {
+		return req, pc
+	}
+	pc := &ProxyContext{Request: req, Tunnel: req.Method == http.MethodConnect}
+	pc.Timings.Start = start
+	if id, ok := IdentityFromContext(req.Context()); ok {
+		pc.Identity = id
+	}
+	return req.WithContext(context.WithValue(req.Context(), proxyContextKey{}, pc)), pc
+}
+
+// RequestInterceptor 在普通 HTTP 请求转发前调用，可以修改 req。
+// 返回非 nil 的响应时直接返回给客户端，不再调用之后的拦截器，也不转发请求；
+// 返回错误时以 502 响应客户端。修改了 req.URL.Host 时，
+// 所有拦截器调用完成后按新的目标重新进行环路、访问控制和域名黑名单检查。
+type RequestInterceptor func(pc *ProxyContext, req *http.Request) (*http.Response, error)
+
+// ResponseInterceptor 在上游响应返回客户端前调用，可以修改 res；返回错误时以 502 响应客户端
+type ResponseInterceptor func(pc *ProxyContext, res *http.Response) error
+
+// TunnelInterceptor 在建立 CONNECT 隧道前调用，可以修改 req.URL.Host 改变隧道的目标，
+// 新的目标同样要通过环路、访问控制、CONNECT 端口和域名黑名单检查。
+// 返回非 nil 的响应时直接返回给客户端，不建立隧道；返回错误时以 502 响应客户端。
+type TunnelInterceptor func(pc *ProxyContext, req *http.Request) (*http.Response, error)
+
+// interceptRequest 依次调用请求拦截器，返回第一个拦截器给出的响应
+func (p *ReverseProxy) interceptRequest(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+	for _, intercept := range p.RequestInterceptors {
+		res, err := intercept(pc, req)
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			if res.Header == nil {
+				res.Header = make(http.Header)
+			}
+			return res, nil
+		}
+	}
+	return nil, nil
+}
+
+// interceptResponse 依次调用响应拦截器
+func (p *ReverseProxy) interceptResponse(pc *ProxyContext, res *http.Response) error {
+	for _, intercept := range p.ResponseInterceptors {
+		if err := intercept(pc, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interceptTunnel 依次调用隧道拦截器，返回第一个拦截器给出的响应
+func (p *ReverseProxy) interceptTunnel(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+	for _, intercept := range p.TunnelInterceptors {
+		res, err := intercept(pc, req)
+		if err != nil || res != nil {
+			return res, err
+		}
+	}
+	return nil, nil
+}
+
+// checkTarget 按重写规则或拦截器修改后的目标重新进行环路、访问控制、CONNECT 端口和域名黑名单检查，
+// 拒绝时写入响应并返回 false
+func (p *ReverseProxy) checkTarget(rw http.ResponseWriter, req *http.Request) bool {
+	if (p.Forwarding != nil || p.LoopDetector != nil) && !p.checkLoop(rw, req) {
+		return false
+	}
+	if p.ACL != nil && !p.checkACL(rw, req) {
+		return false
+	}
+	if req.Method == http.MethodConnect && p.ConnectPorts != nil && !p.checkConnectPort(rw, req) {
+		return false
+	}
+	return p.Blocklist == nil || p.checkBlocklist(rw, req)
+}
+
+// writeResponse 将拦截器给出的响应写入客户端
+func writeResponse(rw http.ResponseWriter, res *http.Response) {
+	copyHeader(rw.Header(), res.Header)
+	rw.WriteHeader(res.StatusCode)
+	if res.Body != nil {
+		io.Copy(rw, res.Body)
+		res.Body.Close()
+	}
+}
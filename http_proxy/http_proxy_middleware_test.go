@@ -0,0 +1,260 @@
+package http_proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReverseProxy_Interceptors(t *testing.T) {
+	upstreamCalls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.Header().Set("X-Trace", r.Header.Get("X-Trace"))
+		io.WriteString(w, "backend")
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	var trace []string
+	newProxy := func() *ReverseProxy {
+		proxy := NewReverseProxy(target)
+		director := proxy.Director
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			// Director 也可以访问共享的上下文
+			if pc, ok := ProxyContextFrom(req.Context()); ok {
+				pc.Set("director", true)
+			}
+		}
+		proxy.Auth = &ProxyAuth{Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"}}
+		proxy.RequestInterceptors = []RequestInterceptor{
+			func(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+				trace = append(trace, "request 1")
+				if _, ok := pc.Get("director"); !ok {
+					t.Error("director value not found")
+				}
+				if pc.Identity == nil || pc.Identity.Username != "alice" {
+					t.Errorf("Identity = %v, want alice", pc.Identity)
+				}
+				if pc.Timings.Start.IsZero() || pc.Tunnel {
+					t.Errorf("unexpected context: start %v, tunnel %v", pc.Timings.Start, pc.Tunnel)
+				}
+				pc.Set("trace-id", "abc")
+				req.Header.Set("X-Trace", "abc")
+				return nil, nil
+			},
+			func(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+				trace = append(trace, "request 2")
+				switch req.URL.Path {
+				case "/cached":
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("cached"))}, nil
+				case "/error":
+					return nil, errors.New("interceptor failed")
+				}
+				return nil, nil
+			},
+			func(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+				trace = append(trace, "request 3")
+				return nil, nil
+			},
+		}
+		proxy.ResponseInterceptors = []ResponseInterceptor{
+			func(pc *ProxyContext, res *http.Response) error {
+				trace = append(trace, "response")
+				if pc.Timings.FirstByte.Before(pc.Timings.Upstream) || pc.Timings.Upstream.IsZero() {
+					t.Errorf("unexpected timings: %+v", pc.Timings)
+				}
+				id, _ := pc.Get("trace-id")
+				res.Header.Set("X-Trace-Id", id.(string))
+				if pc.Request.URL.Path == "/bad" {
+					return errors.New("bad response")
+				}
+				return nil
+			},
+		}
+		return proxy
+	}
+
+	tests := []struct {
+		path     string
+		code     int
+		body     string
+		trace    string
+		upstream int
+	}{
+		{"/", http.StatusOK, "backend", "request 1,request 2,request 3,response", 1},
+		{"/cached", http.StatusOK, "cached", "request 1,request 2", 0},
+		{"/error", http.StatusBadGateway, "Bad Gateway\n", "request 1,request 2", 0},
+		{"/bad", http.StatusBadGateway, "Bad Gateway\n", "request 1,request 2,request 3,response", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			trace, upstreamCalls = nil, 0
+			req := httptest.NewRequest(http.MethodGet, backend.URL+tt.path, nil)
+			req.Header.Set("Proxy-Authorization", "Basic "+BasicAuth("alice", "secret"))
+			w := httptest.NewRecorder()
+			newProxy().ServeHTTP(w, req)
+
+			if w.Code != tt.code || w.Body.String() != tt.body {
+				t.Errorf("response = %d %q, want %d %q", w.Code, w.Body.String(), tt.code, tt.body)
+			}
+			if got := strings.Join(trace, ","); got != tt.trace {
+				t.Errorf("trace = %q, want %q", got, tt.trace)
+			}
+			if upstreamCalls != tt.upstream {
+				t.Errorf("upstream calls = %d, want %d", upstreamCalls, tt.upstream)
+			}
+			if tt.path == "/" {
+				if w.Header().Get("X-Trace") != "abc" || w.Header().Get("X-Trace-Id") != "abc" {
+					t.Errorf("headers = %v", w.Header())
+				}
+			}
+		})
+	}
+}
+
+func TestReverseProxy_InterceptorPolicy(t *testing.T) {
+	upstreamCalls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	blocklist, err := NewBlocklist(writeBlocklist(t, "ads.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewReverseProxy(target)
+	proxy.Blocklist = blocklist
+	proxy.RequestInterceptors = []RequestInterceptor{
+		func(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+			// 拦截器改变目标后同样要通过黑名单检查
+			req.URL.Host = "ads.example.com"
+			return nil, nil
+		},
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if w.Code != http.StatusForbidden || upstreamCalls != 0 {
+		t.Errorf("status = %d, upstream calls = %d, want 403 and none", w.Code, upstreamCalls)
+	}
+}
+
+func TestReverseProxy_TunnelInterceptors(t *testing.T) {
+	// 回显服务器作为隧道的实际目标
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	proxy := &ReverseProxy{
+		TunnelInterceptors: []TunnelInterceptor{
+			func(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+				if !pc.Tunnel {
+					t.Error("Tunnel = false")
+				}
+				switch req.URL.Host {
+				case "blocked.example.com:443":
+					return &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{"X-Reason": {"policy"}}}, nil
+				case "broken.example.com:443":
+					return nil, errors.New("interceptor failed")
+				}
+				req.URL.Host = echo.Addr().String()
+				return nil, nil
+			},
+		},
+	}
+
+	t.Run("short circuit", func(t *testing.T) {
+		for host, code := range map[string]int{"blocked.example.com:443": http.StatusForbidden, "broken.example.com:443": http.StatusBadGateway} {
+			req := httptest.NewRequest(http.MethodConnect, "http://"+host, nil)
+			req.URL = &url.URL{Host: host}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != code {
+				t.Errorf("%s: status = %d, want %d", host, w.Code, code)
+			}
+		}
+	})
+
+	t.Run("policy on new target", func(t *testing.T) {
+		ports, _ := ParsePortList("443")
+		blocklist, err := NewBlocklist(writeBlocklist(t, "ads.example.com\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy := &ReverseProxy{
+			ConnectPorts: &PortPolicy{Ports: ports},
+			Blocklist:    blocklist,
+			TunnelInterceptors: []TunnelInterceptor{
+				func(pc *ProxyContext, req *http.Request) (*http.Response, error) {
+					switch req.URL.Host {
+					case "news.example.com:443":
+						req.URL.Host = "ads.example.com:443"
+					case "mail.example.com:443":
+						req.URL.Host = "mail.example.com:25"
+					}
+					return nil, nil
+				},
+			},
+		}
+		for host, want := range map[string]string{
+			"news.example.com:443": "Forbidden: domain is blocked\n",
+			"mail.example.com:443": "Forbidden: CONNECT to this port is not allowed\n",
+		} {
+			req := httptest.NewRequest(http.MethodConnect, "http://"+host, nil)
+			req.URL = &url.URL{Host: host}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusForbidden || w.Body.String() != want {
+				t.Errorf("%s: response = %d %q, want 403 %q", host, w.Code, w.Body.String(), want)
+			}
+		}
+	})
+
+	t.Run("redirect target", func(t *testing.T) {
+		server := httptest.NewServer(proxy)
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, "CONNECT origin.example.com:443 HTTP/1.1\r\nHost: origin.example.com:443\r\n\r\n")
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d", res.StatusCode)
+		}
+		io.WriteString(conn, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("echo = %q, %v", buf, err)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package http_proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultConnectPorts 是默认允许 CONNECT 的目标端口
+var DefaultConnectPorts = PortList{{443, 443}, {8443, 8443}}
+
+// PortRange 是一个端口范围（包含两端）
+type PortRange struct {
+	Low, High int
+}
+
+// PortList 是端口范围列表，nil 表示不限制端口
+type PortList []PortRange
+
+// ParsePortList 解析端口列表，如 "443,8443,10000-10100"，"*" 表示允许所有端口
+func ParsePortList(s string) (PortList, error) {
+	s = strings.TrimSpace(s)
+	if s == "*" {
+		return nil, nil
+	}
+
+	list := PortList{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lowStr, highStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			highStr = lowStr
+		}
+		low, err1 := strconv.Atoi(strings.TrimSpace(lowStr))
+		high, err2 := strconv.Atoi(strings.TrimSpace(highStr))
+		if err1 != nil || err2 != nil || low < 1 || high > 65535 || low > high {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		list = append(list, PortRange{low, high})
+	}
+	return list, nil
+}
+
+// Contains 判断端口是否在列表中，nil 列表包含所有端口
+func (l PortList) Contains(port int) bool {
+	if l == nil {
+		return true
+	}
+	for _, r := range l {
+		if port >= r.Low && port <= r.High {
+			return true
+		}
+	}
+	return false
+}
+
+func (l PortList) String() string {
+	if l == nil {
+		return "*"
+	}
+	parts := make([]string, 0, len(l))
+	for _, r := range l {
+		if r.Low == r.High {
+			parts = append(parts, strconv.Itoa(r.Low))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", r.Low, r.High))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// PortPolicy 限制 CONNECT 隧道允许的目标端口，防止代理被用作通用TCP中继
+type PortPolicy struct {
+	// Ports 默认允许的端口，nil 表示允许所有端口
+	Ports PortList
+
+	// Users 为指定用户覆盖默认端口列表
+	Users map[string]PortList
+}
+
+// Allowed 判断请求的 CONNECT 目标端口是否允许
+func (p *PortPolicy) Allowed(req *http.Request) bool {
+	port := connectPort(req)
+	if port == 0 {
+		return false
+	}
+
+	ports := p.Ports
+	if id, ok := IdentityFromContext(req.Context()); ok {
+		if userPorts, ok := p.Users[id.Username]; ok {
+			ports = userPorts
+		}
+	}
+	return ports.Contains(port)
+}
+
+// connectPort 返回 CONNECT 请求的目标端口，未指定时为 443，无效时为 0
+func connectPort(req *http.Request) int {
+	host := req.Host
+	if req.URL != nil && req.URL.Host != "" {
+		host = req.URL.Host
+	}
+	_, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return 443
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return 0
+	}
+	return port
+}
+
+// checkConnectPort 检查 CONNECT 目标端口，不允许时写入 403 响应并返回 false
+func (p *ReverseProxy) checkConnectPort(rw http.ResponseWriter, req *http.Request) bool {
+	if p.ConnectPorts.Allowed(req) {
+		return true
+	}
+
+	user := "-"
+	if id, ok := IdentityFromContext(req.Context()); ok {
+		user = id.Username
+	}
+	p.logf("http: proxy CONNECT to disallowed port: %s from %s (user %s)", req.URL.Host, req.RemoteAddr, user)
+	rw.Header().Set("Connection", "close")
+	http.Error(rw, "Forbidden: CONNECT to this port is not allowed", http.StatusForbidden)
+	return false
+}
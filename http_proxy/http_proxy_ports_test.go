@@ -0,0 +1,88 @@
+package http_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePortList(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"443,8443", "443,8443", false},
+		{" 443 , 10000-10100 ", "443,10000-10100", false},
+		{"*", "*", false},
+		{"", "", false}, // 空列表禁止所有端口
+		{"0", "", true},
+		{"65536", "", true},
+		{"200-100", "", true},
+		{"abc", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePortList(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortList(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("ParsePortList(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortPolicy_Allowed(t *testing.T) {
+	policy := &PortPolicy{
+		Ports: DefaultConnectPorts,
+		Users: map[string]PortList{
+			"admin": nil, // 不限制
+			"dev":   {{22, 22}, {443, 443}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		host string
+		user string
+		want bool
+	}{
+		{"default 443", "example.com:443", "", true},
+		{"default 8443", "example.com:8443", "", true},
+		{"default 22", "example.com:22", "", false},
+		{"no port", "example.com", "", true},
+		{"invalid port", "example.com:99999", "", false},
+		{"user override allows 22", "example.com:22", "dev", true},
+		{"user override replaces default", "example.com:8443", "dev", false},
+		{"unrestricted user", "example.com:25", "admin", true},
+		{"user without override", "example.com:22", "alice", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodConnect, "http://"+tt.host, nil)
+			req.URL.Host = tt.host
+			if tt.user != "" {
+				req = req.WithContext(WithIdentity(req.Context(), &Identity{Username: tt.user}))
+			}
+			if got := policy.Allowed(req); got != tt.want {
+				t.Errorf("Allowed(%s, %q) = %v, want %v", tt.host, tt.user, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseProxy_ConnectPorts(t *testing.T) {
+	proxy := &ReverseProxy{ConnectPorts: &PortPolicy{Ports: DefaultConnectPorts}}
+
+	req := httptest.NewRequest(http.MethodConnect, "http://example.com:25", nil)
+	req.URL.Host = "example.com:25"
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
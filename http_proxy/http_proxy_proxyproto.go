@@ -0,0 +1,226 @@
+package http_proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout 是读取 PROXY 协议头的默认超时时间
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature 是 PROXY 协议 v2 头的签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener 解析来自可信地址的连接上的 PROXY 协议 v1 和 v2 头，
+// 将连接的 RemoteAddr 替换为头中的客户端地址
+//
+// 只有来源在 Trusted 中的连接才会解析 PROXY 头，其他连接原样返回，
+// 防止客户端伪造源地址。可信来源的连接也可以不发送 PROXY 头（如负载均衡的健康检查）。
+// PROXY 头在第一次读取或调用 RemoteAddr 时解析，不阻塞 Accept。
+type ProxyProtocolListener struct {
+	net.Listener
+
+	// Trusted 允许发送 PROXY 头的来源网段（负载均衡的地址）
+	Trusted []*net.IPNet
+
+	// HeaderTimeout 读取 PROXY 头的超时时间
+	HeaderTimeout time.Duration
+}
+
+// NewProxyProtocolListener 创建 ProxyProtocolListener
+func NewProxyProtocolListener(l net.Listener, trusted []*net.IPNet) *ProxyProtocolListener {
+	return &ProxyProtocolListener{Listener: l, Trusted: trusted, HeaderTimeout: DefaultProxyHeaderTimeout}
+}
+
+// Accept 实现 net.Listener
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok || !containsIP(l.Trusted, normalizeIP(addr.IP)) {
+		return c, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	return &proxyProtocolConn{Conn: c, r: bufio.NewReader(c), timeout: timeout}, nil
+}
+
+// normalizeIP 将 IPv4 映射的 IPv6 地址转换为 IPv4 地址
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// proxyProtocolConn 是来自可信来源的连接，在第一次使用时解析 PROXY 头
+type proxyProtocolConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+
+	// readDeadline 是调用方设置的读取超时，解析 PROXY 头后恢复
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		deadline := time.Now().Add(c.timeout)
+		if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+			deadline = c.readDeadline
+		}
+		c.mu.Unlock()
+		c.Conn.SetReadDeadline(deadline)
+		c.remote, c.err = readProxyHeader(c.r)
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+		if c.err != nil {
+			// 头无效时直接关闭连接，不向发送方返回任何数据
+			c.err = fmt.Errorf("proxy protocol from %s: %w", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyProtocolConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyProtocolConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// RemoteAddr 返回 PROXY 头中的客户端地址，没有 PROXY 头或头无效时返回连接的源地址
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader 读取 PROXY 头并返回客户端地址
+// 没有 PROXY 头、LOCAL 命令或 UNKNOWN/非 TCP 协议时返回 nil 地址，由连接的源地址代替
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	if err != nil && len(peek) == 0 {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// readProxyHeaderV1 解析文本格式的头，如 "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// v1 头最长 107 字节
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("invalid v1 header")
+	}
+
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errors.New("invalid v1 source address")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 解析二进制格式的头
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, errors.New("unsupported v2 version")
+	}
+	command, family := head[12]&0x0f, head[13]
+	payload := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL：负载均衡自身的连接（如健康检查）
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errors.New("unsupported v2 command")
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("truncated v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("truncated v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	// UNSPEC、UDP 和 UNIX 地址使用连接的源地址
+	return nil, nil
+}
@@ -0,0 +1,168 @@
+package http_proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2Header 构造 PROXY 协议 v2 头
+func proxyV2Header(command, family byte, addrs []byte) []byte {
+	h := append([]byte{}, proxyV2Signature...)
+	h = append(h, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	copy(v6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(v6[32:], 4000)
+	binary.BigEndian.PutUint16(v6[34:], 443)
+
+	tests := []struct {
+		name   string
+		input  string
+		remote string // 空字符串表示使用连接的源地址
+		err    bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET /", "192.0.2.1:56324", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 4000 443\r\nGET /", "[2001:db8::1]:4000", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\nGET /", "", false},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 2001:db8::2 4000 443\r\n", "", true},
+		{"v1 missing crlf", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n", "", true},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 192.0.2.2 99999 443\r\n", "", true},
+		{"v2 tcp4", string(proxyV2Header(1, 0x11, v4)) + "GET /", "192.0.2.1:56324", false},
+		{"v2 tcp6", string(proxyV2Header(1, 0x21, v6)) + "GET /", "[2001:db8::1]:4000", false},
+		{"v2 local", string(proxyV2Header(0, 0x00, nil)) + "GET /", "", false},
+		{"v2 truncated address", string(proxyV2Header(1, 0x11, v4[:8])), "", true},
+		{"no header", "GET / HTTP/1.1\r\n\r\n", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			addr, err := readProxyHeader(r)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.remote {
+				t.Errorf("remote = %q, want %q", got, tt.remote)
+			}
+			// 头之后的数据保持不变
+			rest, _ := io.ReadAll(r)
+			if !strings.HasPrefix(string(rest), "GET /") {
+				t.Errorf("remaining data = %q", rest)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	// serve 启动一个返回客户端地址的服务器，返回其监听地址
+	serve := func(trusted string) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets, _ := ParseCIDRs([]string{trusted})
+		pl := NewProxyProtocolListener(ln, nets)
+		pl.HeaderTimeout = time.Second
+
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.RemoteAddr)
+		})}
+		go server.Serve(pl)
+		t.Cleanup(func() { server.Close() })
+		return ln.Addr().String()
+	}
+	addr := serve("127.0.0.1")
+
+	send := func(data string) string {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		io.WriteString(c, data)
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"
+	if got := send("PROXY TCP4 203.0.113.7 127.0.0.1 40000 80\r\n" + request); got != "203.0.113.7:40000" {
+		t.Errorf("RemoteAddr = %q, want 203.0.113.7:40000", got)
+	}
+	if got := send(request); !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("RemoteAddr without header = %q", got)
+	}
+	if got := send("PROXY TCP4 bogus\r\n" + request); !strings.HasPrefix(got, "error") {
+		t.Errorf("expected connection to be closed for invalid header, got %q", got)
+	}
+
+	// 不可信来源的 PROXY 头不被解析
+	addr = serve("192.0.2.0/24")
+	if got := send("PROXY TCP4 203.0.113.7 127.0.0.1 40000 80\r\n" + request); strings.Contains(got, "203.0.113.7") {
+		t.Errorf("untrusted PROXY header was accepted: %q", got)
+	}
+}
+
+func TestProxyProtocolConn_ReadDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	nets, _ := ParseCIDRs([]string{"127.0.0.1"})
+	pl := NewProxyProtocolListener(ln, nets)
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	io.WriteString(client, "PROXY TCP4 203.0.113.7 127.0.0.1 40000 80\r\n")
+
+	c, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// 解析 PROXY 头后保留调用方（如 http.Server）设置的读取超时
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if got := c.RemoteAddr().String(); got != "203.0.113.7:40000" {
+		t.Fatalf("RemoteAddr = %q", got)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("Read error = %v, want timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read deadline was cleared after parsing the PROXY header")
+	}
+}
@@ -0,0 +1,401 @@
+package http_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 配额周期
+const (
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+)
+
+const (
+	dailyKeyLayout   = "2006-01-02"
+	monthlyKeyLayout = "2006-01"
+
+	// 用量历史的保留期限
+	dailyHistory   = 31
+	monthlyHistory = 12
+
+	defaultQuotaFlushInterval = 30 * time.Second
+	defaultQuotaWarnThreshold = 0.8
+)
+
+// ErrQuotaExceeded 表示用户的流量配额已用尽
+var ErrQuotaExceeded = errors.New("traffic quota exceeded")
+
+// Quota 指定一个周期内的字节数和请求数上限，0 表示不限制
+type Quota struct {
+	Bytes    int64 `json:"bytes"`
+	Requests int64 `json:"requests"`
+}
+
+// QuotaLimits 指定每日和每月的配额
+type QuotaLimits struct {
+	Daily   Quota `json:"daily"`
+	Monthly Quota `json:"monthly"`
+}
+
+// Usage 是一个周期内的用量
+type Usage struct {
+	Bytes    int64 `json:"bytes"`
+	Requests int64 `json:"requests"`
+}
+
+// UserUsage 是一个用户按周期记录的用量，键为日期（2006-01-02）或月份（2006-01）
+type UserUsage struct {
+	Daily   map[string]*Usage `json:"daily"`
+	Monthly map[string]*Usage `json:"monthly"`
+}
+
+// usageFile 是用量存储文件的格式
+type usageFile struct {
+	Users map[string]*UserUsage `json:"users"`
+}
+
+// LoadUsage 从存储文件中读取所有用户的用量，文件不存在时返回空结果
+func LoadUsage(path string) (map[string]*UserUsage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]*UserUsage), nil
+		}
+		return nil, fmt.Errorf("read usage file: %w", err)
+	}
+
+	var f usageFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse usage file: %w", err)
+	}
+	if f.Users == nil {
+		f.Users = make(map[string]*UserUsage)
+	}
+	return f.Users, nil
+}
+
+// writeUsageFile 原子地写入用量存储文件
+func writeUsageFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create usage dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write usage file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// QuotaStatus 是用户在当前周期的配额使用情况，用于管理接口
+type QuotaStatus struct {
+	User     string  `json:"user"`
+	Period   string  `json:"period"`
+	Key      string  `json:"key"`
+	Usage    Usage   `json:"usage"`
+	Limit    Quota   `json:"limit"`
+	Percent  float64 `json:"percent"`
+	Warning  bool    `json:"warning"`
+	Exceeded bool    `json:"exceeded"`
+}
+
+// QuotaManager 记录每个用户的流量和请求数，并在超出配额时拒绝请求
+// 用量定期持久化到本地文件，重启后继续累计
+type QuotaManager struct {
+	// Default 所有用户的默认配额
+	Default QuotaLimits
+
+	// Users 为指定用户覆盖默认配额
+	Users map[string]QuotaLimits
+
+	// WarnThreshold 用量达到配额的该比例时在管理接口中标记警告，默认 0.8
+	WarnThreshold float64
+
+	// Path 用量存储文件路径，为空时不持久化
+	Path string
+
+	// FlushInterval 持久化的间隔，默认 30 秒
+	FlushInterval time.Duration
+
+	mu    sync.Mutex
+	usage map[string]*UserUsage
+	dirty bool
+}
+
+// NewQuotaManager 创建配额管理器，并从存储文件中恢复用量
+func NewQuotaManager(path string, defaults QuotaLimits, users map[string]QuotaLimits) (*QuotaManager, error) {
+	q := &QuotaManager{
+		Default: defaults,
+		Users:   users,
+		Path:    path,
+		usage:   make(map[string]*UserUsage),
+	}
+	if path != "" {
+		usage, err := LoadUsage(path)
+		if err != nil {
+			return nil, err
+		}
+		q.usage = usage
+	}
+	return q, nil
+}
+
+// Run 定期将用量写入存储文件，直到 ctx 结束，退出前再写入一次
+func (q *QuotaManager) Run(ctx context.Context) {
+	interval := q.FlushInterval
+	if interval <= 0 {
+		interval = defaultQuotaFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := q.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "quota: flush error: %v\n", err)
+			}
+			return
+		case <-ticker.C:
+			if err := q.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "quota: flush error: %v\n", err)
+			}
+		}
+	}
+}
+
+// Flush 将用量写入存储文件，并清理过期的历史记录
+func (q *QuotaManager) Flush() error {
+	if q.Path == "" {
+		return nil
+	}
+
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	q.prune(time.Now())
+	data, err := json.MarshalIndent(usageFile{Users: q.usage}, "", "  ")
+	q.dirty = false
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 在锁外写文件，避免阻塞请求
+	return writeUsageFile(q.Path, data)
+}
+
+// prune 删除超出保留期限的历史用量，调用方需持有锁
+func (q *QuotaManager) prune(now time.Time) {
+	oldestDay := now.AddDate(0, 0, -dailyHistory).Format(dailyKeyLayout)
+	oldestMonth := now.AddDate(0, -monthlyHistory, 0).Format(monthlyKeyLayout)
+	for _, u := range q.usage {
+		for k := range u.Daily {
+			if k < oldestDay {
+				delete(u.Daily, k)
+			}
+		}
+		for k := range u.Monthly {
+			if k < oldestMonth {
+				delete(u.Monthly, k)
+			}
+		}
+	}
+}
+
+// limits 返回用户适用的配额
+func (q *QuotaManager) limits(user string) QuotaLimits {
+	if l, ok := q.Users[user]; ok {
+		return l
+	}
+	return q.Default
+}
+
+// current 返回用户当前日和当前月的用量记录，调用方需持有锁
+func (q *QuotaManager) current(user string, now time.Time) (day, month *Usage) {
+	u, ok := q.usage[user]
+	if !ok {
+		u = &UserUsage{}
+		q.usage[user] = u
+	}
+	if u.Daily == nil {
+		u.Daily = make(map[string]*Usage)
+	}
+	if u.Monthly == nil {
+		u.Monthly = make(map[string]*Usage)
+	}
+
+	dayKey, monthKey := now.Format(dailyKeyLayout), now.Format(monthlyKeyLayout)
+	if day = u.Daily[dayKey]; day == nil {
+		day = &Usage{}
+		u.Daily[dayKey] = day
+	}
+	if month = u.Monthly[monthKey]; month == nil {
+		month = &Usage{}
+		u.Monthly[monthKey] = month
+	}
+	return day, month
+}
+
+// exceeded 判断用量是否超出配额
+func exceeded(u *Usage, limit Quota) bool {
+	return (limit.Bytes > 0 && u.Bytes >= limit.Bytes) ||
+		(limit.Requests > 0 && u.Requests >= limit.Requests)
+}
+
+// AddRequest 检查用户的配额并记录一次请求
+// 配额已用尽时返回 ErrQuotaExceeded 和配额重置前的等待时间
+func (q *QuotaManager) AddRequest(user string) (time.Duration, error) {
+	now := time.Now()
+	limits := q.limits(user)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	day, month := q.current(user, now)
+	if exceeded(month, limits.Monthly) {
+		return nextMonth(now).Sub(now), ErrQuotaExceeded
+	}
+	if exceeded(day, limits.Daily) {
+		return nextDay(now).Sub(now), ErrQuotaExceeded
+	}
+
+	day.Requests++
+	month.Requests++
+	q.dirty = true
+	return 0, nil
+}
+
+// AddBytes 记录用户传输的字节数，超出配额时返回 ErrQuotaExceeded
+func (q *QuotaManager) AddBytes(user string, n int64) error {
+	now := time.Now()
+	limits := q.limits(user)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	day, month := q.current(user, now)
+	day.Bytes += n
+	month.Bytes += n
+	q.dirty = true
+
+	if (limits.Daily.Bytes > 0 && day.Bytes > limits.Daily.Bytes) ||
+		(limits.Monthly.Bytes > 0 && month.Bytes > limits.Monthly.Bytes) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Status 返回所有用户在当前日和当前月的配额使用情况
+func (q *QuotaManager) Status() []QuotaStatus {
+	now := time.Now()
+	threshold := q.WarnThreshold
+	if threshold <= 0 {
+		threshold = defaultQuotaWarnThreshold
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	users := make([]string, 0, len(q.usage))
+	for user := range q.usage {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var result []QuotaStatus
+	for _, user := range users {
+		limits := q.limits(user)
+		u := q.usage[user]
+		for _, p := range []struct {
+			period string
+			key    string
+			usage  map[string]*Usage
+			limit  Quota
+		}{
+			{QuotaDaily, now.Format(dailyKeyLayout), u.Daily, limits.Daily},
+			{QuotaMonthly, now.Format(monthlyKeyLayout), u.Monthly, limits.Monthly},
+		} {
+			usage, ok := p.usage[p.key]
+			if !ok {
+				continue
+			}
+			st := QuotaStatus{User: user, Period: p.period, Key: p.key, Usage: *usage, Limit: p.limit}
+			if p.limit.Bytes > 0 {
+				st.Percent = float64(usage.Bytes) / float64(p.limit.Bytes)
+			}
+			if p.limit.Requests > 0 {
+				st.Percent = math.Max(st.Percent, float64(usage.Requests)/float64(p.limit.Requests))
+			}
+			st.Percent = math.Round(st.Percent*10000) / 100
+			st.Warning = st.Percent >= threshold*100
+			st.Exceeded = exceeded(usage, p.limit)
+			result = append(result, st)
+		}
+	}
+	return result
+}
+
+func nextDay(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+}
+
+func nextMonth(now time.Time) time.Time {
+	y, m, _ := now.Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())
+}
+
+// quotaUser 返回请求计入配额的用户键，未认证的请求按客户端IP计算
+func quotaUser(req *http.Request) string {
+	if id, ok := IdentityFromContext(req.Context()); ok && id.Username != "" {
+		return id.Username
+	}
+	return "ip:" + clientIP(req)
+}
+
+// checkQuota 检查并记录请求配额，配额用尽时写入 429 响应并返回 false
+func (p *ReverseProxy) checkQuota(rw http.ResponseWriter, req *http.Request) bool {
+	retry, err := p.Quota.AddRequest(quotaUser(req))
+	if err == nil {
+		return true
+	}
+
+	p.logf("http: proxy quota exceeded: %s %s from %s", req.Method, req.URL, quotaUser(req))
+	rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	if req.Method == http.MethodConnect {
+		rw.Header().Set("Connection", "close")
+	}
+	http.Error(rw, "Quota Exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+// quotaReader 统计读取的字节数并计入用户配额，超出配额后中断传输
+type quotaReader struct {
+	r     io.Reader
+	quota *QuotaManager
+	user  string
+}
+
+func (qr *quotaReader) Read(b []byte) (int, error) {
+	n, err := qr.r.Read(b)
+	if n > 0 {
+		if qerr := qr.quota.AddBytes(qr.user, int64(n)); qerr != nil {
+			return n, qerr
+		}
+	}
+	return n, err
+}
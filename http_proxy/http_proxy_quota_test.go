@@ -0,0 +1,102 @@
+package http_proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuotaManager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	q, err := NewQuotaManager(path, QuotaLimits{Daily: Quota{Requests: 2, Bytes: 100}}, map[string]QuotaLimits{
+		"vip": {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := q.AddRequest("alice"); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+	}
+	retry, err := q.AddRequest("alice")
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if retry <= 0 {
+		t.Errorf("retry = %v, want positive duration until reset", retry)
+	}
+
+	if err := q.AddBytes("bob", 50); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := q.AddBytes("bob", 51); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	// 不受限制的用户
+	for i := 0; i < 5; i++ {
+		if _, err := q.AddRequest("vip"); err != nil {
+			t.Fatalf("vip request %d: unexpected error: %v", i, err)
+		}
+	}
+
+	// 用量持久化后可以恢复
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewQuotaManager(path, q.Default, q.Users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.AddRequest("alice"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("restored quota: expected ErrQuotaExceeded, got %v", err)
+	}
+
+	var found bool
+	for _, st := range restored.Status() {
+		if st.User == "alice" && st.Period == QuotaDaily {
+			found = true
+			if !st.Exceeded || !st.Warning || st.Usage.Requests != 2 {
+				t.Errorf("alice status = %+v, want exceeded with 2 requests", st)
+			}
+		}
+	}
+	if !found {
+		t.Error("missing daily status for alice")
+	}
+}
+
+func TestReverseProxy_Quota(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "0123456789")
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewQuotaManager("", QuotaLimits{Monthly: Quota{Bytes: 15}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewReverseProxy(backendURL)
+	proxy.Quota = q
+
+	// 第二个请求传输过程中超出配额，第三个请求被拒绝
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i, w.Code, want)
+		}
+	}
+}
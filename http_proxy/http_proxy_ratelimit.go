@@ -0,0 +1,246 @@
+package http_proxy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitKey 指定限流的维度
+type RateLimitKey string
+
+const (
+	// RateLimitByUser 按认证用户限流，未认证的请求按客户端IP限流
+	RateLimitByUser RateLimitKey = "user"
+	// RateLimitByIP 按客户端IP限流
+	RateLimitByIP RateLimitKey = "ip"
+	// RateLimitByHost 按目标主机限流
+	RateLimitByHost RateLimitKey = "host"
+)
+
+// rateLimitSweepInterval 清理空闲令牌桶的间隔
+const rateLimitSweepInterval = time.Minute
+
+// ParseRateLimitKey 解析限流维度
+func ParseRateLimitKey(s string) (RateLimitKey, error) {
+	switch k := RateLimitKey(s); k {
+	case RateLimitByUser, RateLimitByIP, RateLimitByHost:
+		return k, nil
+	}
+	return "", fmt.Errorf("invalid rate limit key: %q (want user, ip or host)", s)
+}
+
+// tokenBucket 是一个令牌桶，调用方负责加锁
+type tokenBucket struct {
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 桶容量
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// refill 根据经过的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// take 尝试取出 n 个令牌，失败时返回需要等待的时间
+func (b *tokenBucket) take(now time.Time, n float64) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	return false, b.delay(n)
+}
+
+// delay 返回令牌数补充到 n 个所需的时间
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// full 判断令牌桶是否已满（已满的桶与新建的桶等价，可以安全删除）
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// RateLimitResult 是一次限流检查的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int           // 桶容量
+	Remaining  int           // 剩余令牌数
+	Reset      time.Duration // 令牌桶补满所需的时间
+	RetryAfter time.Duration // 被拒绝时建议的重试等待时间
+}
+
+// RateLimiter 基于令牌桶对请求和新建隧道进行限流
+type RateLimiter struct {
+	// RequestRate 每秒允许的请求数（非 CONNECT），0 表示不限制
+	RequestRate float64
+	// RequestBurst 请求的突发容量，0 表示取 RequestRate
+	RequestBurst int
+
+	// TunnelRate 每秒允许新建的隧道数（CONNECT），0 表示不限制
+	TunnelRate float64
+	// TunnelBurst 隧道的突发容量，0 表示取 TunnelRate
+	TunnelBurst int
+
+	// KeyBy 限流维度，默认按用户
+	KeyBy RateLimitKey
+
+	mu        sync.Mutex
+	requests  map[string]*tokenBucket
+	tunnels   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter 创建限流器
+func NewRateLimiter(requestRate float64, requestBurst int, tunnelRate float64, tunnelBurst int, keyBy RateLimitKey) *RateLimiter {
+	return &RateLimiter{
+		RequestRate:  requestRate,
+		RequestBurst: requestBurst,
+		TunnelRate:   tunnelRate,
+		TunnelBurst:  tunnelBurst,
+		KeyBy:        keyBy,
+	}
+}
+
+// Allow 检查请求是否允许通过，并消耗一个令牌
+func (l *RateLimiter) Allow(req *http.Request) RateLimitResult {
+	rate, burst := l.RequestRate, l.RequestBurst
+	if req.Method == http.MethodConnect {
+		rate, burst = l.TunnelRate, l.TunnelBurst
+	}
+	if rate <= 0 {
+		return RateLimitResult{Allowed: true}
+	}
+
+	key := l.key(req)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.requests == nil {
+		l.requests = make(map[string]*tokenBucket)
+		l.tunnels = make(map[string]*tokenBucket)
+		l.lastSweep = now
+	}
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	buckets := l.requests
+	if req.Method == http.MethodConnect {
+		buckets = l.tunnels
+	}
+	b, ok := buckets[key]
+	if !ok {
+		b = newTokenBucket(rate, burst, now)
+		buckets[key] = b
+	}
+
+	allowed, wait := b.take(now, 1)
+	return RateLimitResult{
+		Allowed:    allowed,
+		Limit:      int(b.burst),
+		Remaining:  int(b.tokens),
+		Reset:      b.delay(b.burst),
+		RetryAfter: wait,
+	}
+}
+
+// sweep 删除已补满的令牌桶，防止内存无限增长，调用方需持有锁
+func (l *RateLimiter) sweep(now time.Time) {
+	for _, buckets := range []map[string]*tokenBucket{l.requests, l.tunnels} {
+		for k, b := range buckets {
+			if b.full(now) {
+				delete(buckets, k)
+			}
+		}
+	}
+	l.lastSweep = now
+}
+
+// key 返回请求对应的限流键
+func (l *RateLimiter) key(req *http.Request) string {
+	switch l.KeyBy {
+	case RateLimitByHost:
+		return "host:" + requestHostname(req)
+	case RateLimitByIP:
+		return "ip:" + clientIP(req)
+	default:
+		if id, ok := IdentityFromContext(req.Context()); ok && id.Username != "" {
+			return "user:" + id.Username
+		}
+		return "ip:" + clientIP(req)
+	}
+}
+
+// clientIP 返回请求的客户端IP
+func clientIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// requestHostname 返回请求的目标主机名（不含端口）
+func requestHostname(req *http.Request) string {
+	host := req.Host
+	if req.URL != nil && req.URL.Host != "" {
+		host = req.URL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// setRateLimitHeaders 写入限流相关的响应头
+func setRateLimitHeaders(h http.Header, res RateLimitResult) {
+	if res.Limit == 0 {
+		return
+	}
+	h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(res.RetryAfter.Seconds())))))
+	}
+}
+
+// checkRateLimit 执行限流检查，被拒绝时写入 429 响应并返回 false
+func (p *ReverseProxy) checkRateLimit(rw http.ResponseWriter, req *http.Request) bool {
+	res := p.RateLimiter.Allow(req)
+	if res.Allowed {
+		// 放行的请求不添加限流响应头，避免和上游的响应头混在一起
+		return true
+	}
+	setRateLimitHeaders(rw.Header(), res)
+
+	p.logf("http: proxy rate limit exceeded: %s %s from %s", req.Method, req.URL, req.RemoteAddr)
+	if req.Method == http.MethodConnect {
+		// 在建立隧道之前拒绝，和 407 一样直接返回错误响应并关闭连接
+		rw.Header().Set("Connection", "close")
+	}
+	http.Error(rw, "Too Many Requests", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,150 @@
+package http_proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 3, now)
+
+	// 突发容量内的请求全部通过
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(now, 1); !ok {
+			t.Fatalf("take %d: expected token", i)
+		}
+	}
+
+	ok, wait := b.take(now, 1)
+	if ok {
+		t.Fatal("expected bucket to be empty")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want 500ms", wait)
+	}
+
+	// 半秒后补充一个令牌
+	if ok, _ := b.take(now.Add(500*time.Millisecond), 1); !ok {
+		t.Error("expected token after refill")
+	}
+	if !b.full(now.Add(10*time.Second)) || b.tokens != 3 {
+		t.Errorf("tokens = %v, want capped at burst 3", b.tokens)
+	}
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	tests := []struct {
+		name   string
+		keyBy  RateLimitKey
+		reqA   func() *http.Request
+		reqB   func() *http.Request
+		shared bool // A 和 B 是否共享同一个令牌桶
+	}{
+		{
+			name:  "by user",
+			keyBy: RateLimitByUser,
+			reqA: func() *http.Request {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				return req.WithContext(WithIdentity(req.Context(), &Identity{Username: "alice"}))
+			},
+			reqB: func() *http.Request {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				return req.WithContext(WithIdentity(req.Context(), &Identity{Username: "bob"}))
+			},
+			shared: false,
+		},
+		{
+			name:  "by ip",
+			keyBy: RateLimitByIP,
+			reqA: func() *http.Request {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				req.RemoteAddr = "10.0.0.1:1234"
+				return req
+			},
+			reqB: func() *http.Request {
+				req := httptest.NewRequest("GET", "http://example.org/", nil)
+				req.RemoteAddr = "10.0.0.1:5678"
+				return req
+			},
+			shared: true,
+		},
+		{
+			name:  "by host",
+			keyBy: RateLimitByHost,
+			reqA: func() *http.Request {
+				return httptest.NewRequest("GET", "http://example.com/a", nil)
+			},
+			reqB: func() *http.Request {
+				return httptest.NewRequest("GET", "http://example.org/b", nil)
+			},
+			shared: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(1, 1, 0, 0, tt.keyBy)
+
+			if res := l.Allow(tt.reqA()); !res.Allowed {
+				t.Fatal("first request should be allowed")
+			}
+			if res := l.Allow(tt.reqA()); res.Allowed {
+				t.Fatal("second request should be limited")
+			}
+			if res := l.Allow(tt.reqB()); res.Allowed == tt.shared {
+				t.Errorf("request B allowed = %v, want %v", res.Allowed, !tt.shared)
+			}
+		})
+	}
+}
+
+func TestReverseProxy_RateLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewReverseProxy(backendURL)
+	proxy.RateLimiter = NewRateLimiter(1, 2, 1, 1, RateLimitByIP)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i, w.Code, want)
+		}
+		// 限流响应头只出现在 429 响应中
+		wantLimit := ""
+		if want == http.StatusTooManyRequests {
+			wantLimit = "2"
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != wantLimit {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want %q", i, got, wantLimit)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d: missing Retry-After header", i)
+		}
+	}
+
+	// CONNECT 使用独立的隧道令牌桶
+	req := httptest.NewRequest("CONNECT", "http://example.com:443", nil)
+	req.RemoteAddr = "192.0.2.9:1234"
+	proxy.RateLimiter.Allow(req)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("CONNECT: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,47 @@
+package http_proxy
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// reloadable 是从文件加载、文件修改后可以重新加载的配置
+type reloadable interface {
+	// changed 判断文件自上次加载后是否被修改
+	changed() bool
+	// Load 重新加载文件，失败时保留原有配置
+	Load() error
+	// Len 返回加载的条目数，用于日志
+	Len() int
+}
+
+// runReload 每隔 interval 检查 r 的文件，有修改时重新加载，直到 ctx 结束。
+// name 和 unit 用于日志，如 "acl: reloaded 12 rules"
+func runReload(ctx context.Context, interval time.Duration, r reloadable, name, unit string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Load(); err != nil {
+				log.Printf("%s: reload error: %v", name, err)
+				continue
+			}
+			log.Printf("%s: reloaded %d %s", name, r.Len(), unit)
+		}
+	}
+}
+
+// fileModified 判断文件的修改时间是否与 modTime 不同，无法读取文件信息时返回 false
+func fileModified(path string, modTime time.Time) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.ModTime().Equal(modTime)
+}
@@ -0,0 +1,595 @@
+package http_proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RewriteRequest 规则作用于转发给上游的请求
+	RewriteRequest = "request"
+
+	// RewriteResponse 规则作用于上游的响应
+	RewriteResponse = "response"
+)
+
+// RewriteRule 是一条重写规则，所有条件都满足时执行动作，未设置的条件不限制
+type RewriteRule struct {
+	// Line 规则在文件中的行号
+	Line int
+	// Text 规则原文
+	Text string
+	// Phase 规则作用于请求（request）还是响应（response）
+	Phase string
+	// Action 动作名称，如 set-header、rewrite-url
+	Action string
+
+	domains []string
+	path    *regexp.Regexp
+	methods []string
+	headers []rewriteHeaderCond
+	status  []statusRange
+	types   []string
+
+	args   []string
+	code   int
+	filter *BodyFilter
+}
+
+// rewriteHeaderCond 是头条件，value 为 nil 时只要求头存在
+type rewriteHeaderCond struct {
+	name  string
+	value *regexp.Regexp
+}
+
+// statusRange 是状态码范围（包含两端）
+type statusRange struct {
+	low, high int
+}
+
+// RewriteRules 按顺序对普通 HTTP 请求和响应应用重写规则，所有匹配的规则都会执行，
+// 后面的规则按前面的规则修改后的请求匹配；redirect 和请求阶段的 status 直接响应客户端，不再执行后面的规则
+//
+// 规则文件每行一条，# 开头的行为注释，包含空格的值用双引号括起来：
+//
+//	request  host=git.internal.example.com           set-header Authorization "Bearer xyz"
+//	request  *                                       remove-header X-Client-Data
+//	request  host=registry.npmjs.org path=/*         rewrite-url https://npm.mirror.example.com/$1
+//	request  host=old.example.com path=/*            redirect 301 https://new.example.com/$1
+//	request  path=/ads/* method=GET                  status 403 "Blocked"
+//	response host=*.example.com status=5xx           set-header Cache-Control no-store
+//	response header=Server                           remove-header Server
+//	response type=text/html                          replace-body http://cdn.example.com https://cdn.example.com
+//	response type=application/json path=/api/*       replace-json $.user.email "\"redacted\""
+//
+// 条件：host（*.example.com 匹配 example.com 及其子域名）、path（* 匹配任意字符，
+// 可在动作中以 $1...$9 引用）、method、header（Name 或 Name:模式）、type（消息体的媒体类型，如 text/*）
+// 和 status（仅响应阶段，如 404,5xx）。
+// replace-body、replace-body-regexp 和 replace-json 动作通过 BodyFilter 流式修改消息体。
+// 直接响应客户端的请求不再应用响应阶段的规则。规则不作用于 CONNECT 隧道。
+type RewriteRules struct {
+	// Path 规则文件路径
+	Path string
+
+	mu      sync.RWMutex
+	rules   []*RewriteRule
+	modTime time.Time
+}
+
+// NewRewriteRules 创建重写规则并加载规则文件
+func NewRewriteRules(path string) (*RewriteRules, error) {
+	r := &RewriteRules{Path: path}
+	if err := r.Load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load 重新加载规则文件，加载失败时保留原有规则
+func (r *RewriteRules) Load() error {
+	f, err := os.Open(r.Path)
+	if err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+	defer f.Close()
+
+	var modTime time.Time
+	if info, err := f.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	rules, err := parseRewriteRules(f)
+	if err != nil {
+		return fmt.Errorf("rewrite: %s:%w", r.Path, err)
+	}
+
+	r.mu.Lock()
+	r.rules, r.modTime = rules, modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// Len 返回规则数
+func (r *RewriteRules) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.rules)
+}
+
+// changed 判断规则文件自上次加载后是否被修改
+func (r *RewriteRules) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return fileModified(r.Path, r.modTime)
+}
+
+// Run 每隔 interval 检查规则文件，有修改时重新加载，直到 ctx 结束
+func (r *RewriteRules) Run(ctx context.Context, interval time.Duration) {
+	runReload(ctx, interval, r, "rewrite", "rules")
+}
+
+// filtersResponse 判断是否可能有规则修改该请求的响应体，
+// 发送请求前只能检查与响应无关的条件（域名、方法和路径）
+func (r *RewriteRules) filtersResponse(req *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	for _, rule := range r.snapshot() {
+		if rule.Phase == RewriteResponse && rule.filter != nil && rule.matchRequest(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot 返回当前规则，规则加载后不再修改，可以在锁外使用
+func (r *RewriteRules) snapshot() []*RewriteRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rules
+}
+
+// parseRewriteRules 解析规则文件，错误信息以行号开头
+func parseRewriteRules(r io.Reader) ([]*RewriteRule, error) {
+	var rules []*RewriteRule
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields, err := splitRewriteFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%d: %w", lineNo, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := parseRewriteRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %w", lineNo, err)
+		}
+		rule.Line, rule.Text = lineNo, strings.TrimSpace(scanner.Text())
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// splitRewriteFields 按空白拆分一行，双引号内的空白和 # 保留，支持 \" 和 \\ 转义
+func splitRewriteFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case c == '"':
+			quoted = !quoted
+			inField = true
+		case quoted:
+			field.WriteByte(c)
+		case c == '#':
+			i = len(line)
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func parseRewriteRule(fields []string) (*RewriteRule, error) {
+	rule := &RewriteRule{Phase: strings.ToLower(fields[0])}
+	if rule.Phase != RewriteRequest && rule.Phase != RewriteResponse {
+		return nil, fmt.Errorf("unknown phase %q, must be request or response", fields[0])
+	}
+
+	// 动作之前的字段为条件，* 表示匹配所有
+	i := 1
+	for ; i < len(fields); i++ {
+		cond := fields[i]
+		if cond == "*" {
+			continue
+		}
+		key, value, ok := strings.Cut(cond, "=")
+		if !ok {
+			break
+		}
+		if value == "" {
+			return nil, fmt.Errorf("invalid condition %q", cond)
+		}
+		switch strings.ToLower(key) {
+		case "host":
+			for _, d := range strings.Split(strings.ToLower(value), ",") {
+				rule.domains = append(rule.domains, strings.TrimSuffix(d, "."))
+			}
+		case "path":
+			rule.path = compileRewriteGlob(value, true)
+		case "method":
+			rule.methods = strings.Split(strings.ToUpper(value), ",")
+		case "header":
+			name, pattern, hasValue := strings.Cut(value, ":")
+			c := rewriteHeaderCond{name: http.CanonicalHeaderKey(name)}
+			if hasValue {
+				c.value = compileRewriteGlob(pattern, false)
+			}
+			rule.headers = append(rule.headers, c)
+		case "type":
+			rule.types = strings.Split(strings.ToLower(value), ",")
+		case "status":
+			if rule.Phase != RewriteResponse {
+				return nil, fmt.Errorf("status condition is only allowed in response rules")
+			}
+			status, err := parseStatusRanges(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.status = status
+		default:
+			return nil, fmt.Errorf("unknown condition %q", key)
+		}
+	}
+	if i == len(fields) {
+		return nil, fmt.Errorf("rule requires an action")
+	}
+
+	rule.Action, rule.args = strings.ToLower(fields[i]), fields[i+1:]
+	if err := rule.parseAction(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// parseAction 检查动作的参数
+func (rule *RewriteRule) parseAction() error {
+	nargs := len(rule.args)
+	switch rule.Action {
+	case "set-header", "add-header":
+		if nargs != 2 {
+			return fmt.Errorf("%s requires a header name and a value", rule.Action)
+		}
+	case "remove-header":
+		if nargs != 1 {
+			return fmt.Errorf("remove-header requires a header name")
+		}
+	case "rewrite-url":
+		if rule.Phase != RewriteRequest {
+			return fmt.Errorf("rewrite-url is only allowed in request rules")
+		}
+		if nargs != 1 {
+			return fmt.Errorf("rewrite-url requires a URL")
+		}
+		if !strings.HasPrefix(rule.args[0], "http://") && !strings.HasPrefix(rule.args[0], "https://") {
+			return fmt.Errorf("rewrite-url requires an absolute http or https URL")
+		}
+	case "redirect":
+		if rule.Phase != RewriteRequest {
+			return fmt.Errorf("redirect is only allowed in request rules")
+		}
+		rule.code = http.StatusFound
+		if nargs == 2 {
+			code, err := strconv.Atoi(rule.args[0])
+			if err != nil || (code != 301 && code != 302 && code != 303 && code != 307 && code != 308) {
+				return fmt.Errorf("invalid redirect status %q", rule.args[0])
+			}
+			rule.code, rule.args = code, rule.args[1:]
+		} else if nargs != 1 {
+			return fmt.Errorf("redirect requires a URL")
+		}
+	case "status":
+		if nargs != 1 && nargs != 2 {
+			return fmt.Errorf("status requires a status code and an optional body")
+		}
+		code, err := strconv.Atoi(rule.args[0])
+		if err != nil || code < 200 || code > 599 {
+			return fmt.Errorf("invalid status %q", rule.args[0])
+		}
+		rule.code, rule.args = code, rule.args[1:]
+	case "replace-body", "replace-body-regexp", "replace-json":
+		if nargs != 2 {
+			return fmt.Errorf("%s requires two arguments", rule.Action)
+		}
+		var transform BodyTransform
+		var err error
+		switch rule.Action {
+		case "replace-body":
+			transform, err = NewLiteralReplace(rule.args[0], rule.args[1])
+		case "replace-body-regexp":
+			transform, err = NewRegexpReplace(rule.args[0], rule.args[1])
+		default:
+			transform, err = NewJSONReplace(rule.args[0], rule.args[1])
+		}
+		if err != nil {
+			return err
+		}
+		rule.filter = &BodyFilter{Transform: transform}
+	default:
+		return fmt.Errorf("unknown action %q", rule.Action)
+	}
+	return nil
+}
+
+// compileRewriteGlob 将 * 通配模式编译为正则表达式，capture 为 true 时每个 * 都是一个分组
+func compileRewriteGlob(pattern string, capture bool) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	wildcard := "(?:.*)"
+	if capture {
+		wildcard = "(.*)"
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, wildcard) + "$")
+}
+
+// parseStatusRanges 解析状态码列表，如 404,500-504,5xx
+func parseStatusRanges(s string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		if len(part) == 3 && strings.HasSuffix(part, "xx") && part[0] >= '1' && part[0] <= '5' {
+			low := int(part[0]-'0') * 100
+			ranges = append(ranges, statusRange{low, low + 99})
+			continue
+		}
+		lowStr, highStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			highStr = lowStr
+		}
+		low, err1 := strconv.Atoi(lowStr)
+		high, err2 := strconv.Atoi(highStr)
+		if err1 != nil || err2 != nil || low < 100 || high > 599 || low > high {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		ranges = append(ranges, statusRange{low, high})
+	}
+	return ranges, nil
+}
+
+// matchRequest 判断规则的域名、方法和路径条件是否匹配请求
+func (rule *RewriteRule) matchRequest(req *http.Request) bool {
+	if len(rule.domains) > 0 && !aclDomainMatches(rule.domains, strings.ToLower(requestHostname(req))) {
+		return false
+	}
+	if len(rule.methods) > 0 && !containsString(rule.methods, req.Method) {
+		return false
+	}
+	return rule.path == nil || rule.path.MatchString(req.URL.Path)
+}
+
+// match 判断规则是否匹配，返回 path 模式中 * 匹配的内容
+func (rule *RewriteRule) match(req *http.Request, res *http.Response) ([]string, bool) {
+	if len(rule.domains) > 0 && !aclDomainMatches(rule.domains, strings.ToLower(requestHostname(req))) {
+		return nil, false
+	}
+	if len(rule.methods) > 0 && !containsString(rule.methods, req.Method) {
+		return nil, false
+	}
+	header := req.Header
+	if res != nil {
+		header = res.Header
+	}
+	for _, c := range rule.headers {
+		values, ok := header[c.name]
+		if !ok {
+			return nil, false
+		}
+		if c.value != nil && !matchAny(c.value, values) {
+			return nil, false
+		}
+	}
+	if len(rule.types) > 0 && !matchContentType(rule.types, header.Get("Content-Type")) {
+		return nil, false
+	}
+	if len(rule.status) > 0 {
+		found := false
+		for _, r := range rule.status {
+			if res.StatusCode >= r.low && res.StatusCode <= r.high {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	if rule.path == nil {
+		return nil, true
+	}
+	m := rule.path.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return nil, false
+	}
+	return m[1:], true
+}
+
+func matchAny(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandCaptures 将模板中的 $1...$9 替换为 path 模式中 * 匹配的内容
+func expandCaptures(template string, captures []string) string {
+	if !strings.Contains(template, "$") {
+		return template
+	}
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c == '$' && i+1 < len(template) && template[i+1] >= '1' && template[i+1] <= '9' {
+			if n := int(template[i+1] - '1'); n < len(captures) {
+				b.WriteString(captures[n])
+			}
+			i++
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// applyHeader 执行 set-header、add-header 和 remove-header 动作
+func (rule *RewriteRule) applyHeader(h http.Header) {
+	switch rule.Action {
+	case "set-header":
+		h.Set(rule.args[0], rule.args[1])
+	case "add-header":
+		h.Add(rule.args[0], rule.args[1])
+	case "remove-header":
+		h.Del(rule.args[0])
+	}
+}
+
+// rewriteRequest 对转发给上游的请求应用请求阶段的规则，
+// 规则要求直接响应客户端时返回构造的响应和对应的规则
+func (r *RewriteRules) rewriteRequest(req *http.Request) (*http.Response, *RewriteRule, error) {
+	var filters []*BodyFilter
+	for _, rule := range r.snapshot() {
+		if rule.Phase != RewriteRequest {
+			continue
+		}
+		captures, ok := rule.match(req, nil)
+		if !ok {
+			continue
+		}
+		switch rule.Action {
+		case "set-header", "add-header", "remove-header":
+			if strings.EqualFold(rule.args[0], "Host") && rule.Action != "remove-header" {
+				req.Host = rule.args[1]
+				continue
+			}
+			rule.applyHeader(req.Header)
+		case "rewrite-url":
+			target := expandCaptures(rule.args[0], captures)
+			u, err := url.Parse(target)
+			if err != nil || u.Host == "" {
+				return nil, rule, fmt.Errorf("rewrite: rule at line %d: invalid URL %q", rule.Line, target)
+			}
+			if u.RawQuery == "" && !strings.Contains(target, "?") {
+				u.RawQuery = req.URL.RawQuery
+			}
+			req.URL, req.Host = u, u.Host
+		case "redirect":
+			res := newRewriteResponse(req, rule.code, "")
+			res.Header.Set("Location", expandCaptures(rule.args[0], captures))
+			return res, rule, nil
+		case "status":
+			body := ""
+			if len(rule.args) > 0 {
+				body = rule.args[0]
+			}
+			return newRewriteResponse(req, rule.code, body), rule, nil
+		case "replace-body", "replace-body-regexp", "replace-json":
+			filters = append(filters, rule.filter)
+		}
+	}
+	FilterRequestBody(req, filters...)
+	return nil, nil, nil
+}
+
+// RewriteResponse 对上游的响应应用响应阶段的规则，可用作 ModifyResponse
+func (r *RewriteRules) RewriteResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil {
+		return nil
+	}
+	var filters []*BodyFilter
+	for _, rule := range r.snapshot() {
+		if rule.Phase != RewriteResponse {
+			continue
+		}
+		if _, ok := rule.match(req, res); !ok {
+			continue
+		}
+		switch rule.Action {
+		case "set-header", "add-header", "remove-header":
+			rule.applyHeader(res.Header)
+		case "status":
+			res.StatusCode = rule.code
+			res.Status = fmt.Sprintf("%d %s", rule.code, http.StatusText(rule.code))
+			if len(rule.args) > 0 {
+				if res.Body != nil {
+					res.Body.Close()
+				}
+				setRewriteBody(res, rule.args[0])
+			}
+		case "replace-body", "replace-body-regexp", "replace-json":
+			filters = append(filters, rule.filter)
+		}
+	}
+	FilterResponseBody(res, filters...)
+	return nil
+}
+
+// newRewriteResponse 构造直接返回给客户端的响应，body 为空时使用状态码的描述
+func newRewriteResponse(req *http.Request, code int, body string) *http.Response {
+	res := &http.Response{
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode: code,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	if body == "" {
+		body = http.StatusText(code) + "\n"
+	}
+	setRewriteBody(res, body)
+	return res
+}
+
+// setRewriteBody 替换响应体并更新 Content-Length
+func setRewriteBody(res *http.Response, body string) {
+	res.Body = io.NopCloser(strings.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.Header.Del("Content-Encoding")
+	res.Header.Set("Content-Type", "text/plain; charset=utf-8")
+}
@@ -0,0 +1,278 @@
+package http_proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitRewriteFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{`request * set-header X-A b`, []string{"request", "*", "set-header", "X-A", "b"}, false},
+		{`request  set-header Authorization "Bearer a#b"  # 注释`, []string{"request", "set-header", "Authorization", "Bearer a#b"}, false},
+		{`request status 403 "say \"no\""`, []string{"request", "status", "403", `say "no"`}, false},
+		{`request set-header X-Empty ""`, []string{"request", "set-header", "X-Empty", ""}, false},
+		{`   # 只有注释`, nil, false},
+		{`request status 403 "open`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitRewriteFields(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("splitRewriteFields(%q) error = %v, want error %v", tt.line, err, tt.err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitRewriteFields(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseRewriteRules_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"unknown phase", "both * remove-header Server", "1: unknown phase"},
+		{"missing action", "request host=example.com", "1: rule requires an action"},
+		{"unknown action", "\nrequest * drop", `2: unknown action "drop"`},
+		{"unknown condition", "request port=80 remove-header X", `1: unknown condition "port"`},
+		{"status condition in request", "request status=404 remove-header X", "1: status condition is only allowed in response rules"},
+		{"rewrite in response", "response * rewrite-url http://example.com/", "1: rewrite-url is only allowed in request rules"},
+		{"relative url", "request * rewrite-url /x", "1: rewrite-url requires an absolute http or https URL"},
+		{"redirect status", "request * redirect 200 http://example.com/", `1: invalid redirect status "200"`},
+		{"invalid status", "request * status 99", `1: invalid status "99"`},
+		{"invalid status range", "response status=6xx remove-header X", `1: invalid status "6xx"`},
+		{"set-header args", "request * set-header X-A", "1: set-header requires a header name and a value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRewriteRules(strings.NewReader(tt.text))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want prefix %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// newTestRewriteRules 从字符串创建重写规则
+func newTestRewriteRules(t *testing.T, text string) *RewriteRules {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rewrite")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := NewRewriteRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestReverseProxy_Rewrite(t *testing.T) {
+	var got *http.Request
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(context.Background())
+		w.Header().Set("Server", "backend/1.0")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		io.WriteString(w, "backend")
+	}))
+	defer backend.Close()
+
+	rules := newTestRewriteRules(t, `
+request  host=internal.example.com                 set-header Authorization "Bearer secret"
+request  *                                         remove-header X-Tracking
+request  header=User-Agent:*curl*                  add-header X-Client curl
+request  host=registry.example.com path=/pkg/*     rewrite-url http://mirror.example.com/mirror/$1
+request  host=old.example.com path=/*              redirect 301 https://new.example.com/$1
+request  path=/ads/* method=GET                    status 403 "Blocked by rewrite"
+response *                                         remove-header Server
+response status=4xx                                status 200 "not found"
+`)
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		code    int
+		body    string
+		check   func(t *testing.T, w *httptest.ResponseRecorder)
+	}{
+		{
+			name:    "set and remove headers",
+			target:  "http://internal.example.com/a",
+			headers: map[string]string{"X-Tracking": "1", "User-Agent": "curl/8.0"},
+			code:    http.StatusOK,
+			body:    "backend",
+			check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if v := got.Header.Get("Authorization"); v != "Bearer secret" {
+					t.Errorf("Authorization = %q", v)
+				}
+				if v := got.Header.Get("X-Tracking"); v != "" {
+					t.Errorf("X-Tracking = %q, want removed", v)
+				}
+				if v := got.Header.Get("X-Client"); v != "curl" {
+					t.Errorf("X-Client = %q, want curl", v)
+				}
+				if v := w.Header().Get("Server"); v != "" {
+					t.Errorf("response Server = %q, want removed", v)
+				}
+			},
+		},
+		{
+			name:   "rewrite url",
+			target: "http://registry.example.com/pkg/left-pad?v=1",
+			code:   http.StatusOK,
+			check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if got.URL.Path != "/mirror/left-pad" || got.URL.RawQuery != "v=1" {
+					t.Errorf("upstream URL = %s, want /mirror/left-pad?v=1", got.URL)
+				}
+				if got.Header.Get("X-Client") != "" {
+					t.Errorf("unexpected X-Client header")
+				}
+			},
+		},
+		{
+			name:   "redirect",
+			target: "http://old.example.com/docs/index.html",
+			code:   http.StatusMovedPermanently,
+			check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if v := w.Header().Get("Location"); v != "https://new.example.com/docs/index.html" {
+					t.Errorf("Location = %q", v)
+				}
+			},
+		},
+		{
+			name:   "status",
+			target: "http://internal.example.com/ads/banner.js",
+			code:   http.StatusForbidden,
+			body:   "Blocked by rewrite",
+		},
+		{
+			name:   "response status",
+			target: "http://internal.example.com/missing",
+			code:   http.StatusOK,
+			body:   "not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			// 所有连接都发往后端，规则按原始目标匹配
+			proxy := &ReverseProxy{
+				Director: func(*http.Request) {},
+				Transport: &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, backend.Listener.Addr().String())
+				}},
+				Rewrite: rules,
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d", w.Code, tt.code)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if tt.check != nil {
+				tt.check(t, w)
+			}
+		})
+	}
+}
+
+func TestRewriteRules_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rewrite")
+	os.WriteFile(path, []byte("request * remove-header X-A\n"), 0644)
+	rules, err := NewRewriteRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rules.Run(ctx, 10*time.Millisecond)
+
+	// 无效的规则文件不会替换原有规则
+	os.WriteFile(path, []byte("request * drop\n"), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if rules.Len() != 1 {
+		t.Fatalf("Len() = %d after invalid reload, want 1", rules.Len())
+	}
+
+	os.WriteFile(path, []byte("request * remove-header X-A\nresponse * remove-header Server\n"), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	deadline := time.Now().Add(time.Second)
+	for rules.Len() != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rules.Len() != 2 {
+		t.Fatalf("Len() = %d after reload, want 2", rules.Len())
+	}
+}
+
+func TestReverseProxy_RewriteError(t *testing.T) {
+	rules := newTestRewriteRules(t, "request path=/* rewrite-url http://$1/\n")
+	var proxyErr error
+	var stats *ProxyStats
+	proxy := &ReverseProxy{
+		Director:        func(*http.Request) {},
+		Rewrite:         rules,
+		OnProxyError:    func(req *http.Request, err error) { proxyErr = err },
+		OnProxyComplete: func(s *ProxyStats) { stats = s },
+	}
+
+	// 展开后的地址没有主机名，重写失败
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", w.Code)
+	}
+	if proxyErr == nil || stats == nil || stats.Err != proxyErr {
+		t.Errorf("OnProxyError err = %v, stats = %+v", proxyErr, stats)
+	}
+}
+
+func TestReverseProxy_RewritePolicy(t *testing.T) {
+	upstreamCalls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	blocklist, err := NewBlocklist(writeBlocklist(t, "ads.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewReverseProxy(target)
+	proxy.Blocklist = blocklist
+	// 重写后的目标同样要通过黑名单检查
+	proxy.Rewrite = newTestRewriteRules(t, "request path=/* rewrite-url http://ads.example.com/$1\n")
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/banner.js", nil))
+	if w.Code != http.StatusForbidden || upstreamCalls != 0 {
+		t.Errorf("status = %d, upstream calls = %d, want 403 and none", w.Code, upstreamCalls)
+	}
+}
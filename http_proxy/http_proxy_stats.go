@@ -0,0 +1,196 @@
+package http_proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ProxyStats 是一个请求或隧道结束时的统计信息，由 OnProxyComplete 接收
+type ProxyStats struct {
+	// Request 客户端的原始请求
+	Request *http.Request
+
+	// Tunnel 为 true 表示 CONNECT 隧道
+	Tunnel bool
+
+	// StatusCode 返回给客户端的状态码，隧道建立成功时为 200
+	StatusCode int
+
+	// BytesSent 发送给客户端的响应体或隧道下行字节数
+	BytesSent int64
+
+	// BytesReceived 从客户端读取的请求体或隧道上行字节数
+	BytesReceived int64
+
+	// UpstreamAddr 上游连接的远端地址，没有连接上游时为空
+	UpstreamAddr string
+
+	// DNS、Dial 和 TLS 分别为解析域名、建立 TCP 连接和 TLS 握手的时间，复用已有连接时为零。
+	// 隧道中的 TLS 握手由客户端完成，TLS 始终为零
+	DNS  time.Duration
+	Dial time.Duration
+	TLS  time.Duration
+
+	// FirstByte 从开始处理请求到收到上游响应头（隧道为连接建立）的时间，没有收到时为零
+	FirstByte time.Duration
+
+	// Total 从开始处理请求到结束的时间
+	Total time.Duration
+
+	// Err 请求或隧道失败的原因，客户端或上游正常关闭连接不视为错误
+	Err error
+}
+
+// proxyTrace 记录上游连接的地址、各阶段耗时和传输的字节数，
+// 回调可能在 Transport 或拨号的其他 goroutine 中调用
+type proxyTrace struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	dns          time.Duration
+	dial         time.Duration
+	tls          time.Duration
+	addr         string
+	status       int
+
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// clientTrace 返回记录普通请求上游连接的 httptrace.ClientTrace
+func (t *proxyTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			// 同时尝试多个地址时从第一次连接开始计算
+			t.mu.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.dial = time.Since(t.connectStart)
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.tls = time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.addr = info.Conn.RemoteAddr().String()
+			t.mu.Unlock()
+		},
+	}
+}
+
+// control 包装拨号的 Control 函数，第一次调用时域名已经解析完成，开始建立连接
+func (t *proxyTrace) control(start time.Time, next func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		t.mu.Lock()
+		if t.connectStart.IsZero() {
+			t.connectStart = time.Now()
+			t.dns = t.connectStart.Sub(start)
+		}
+		t.mu.Unlock()
+		if next != nil {
+			return next(network, address, c)
+		}
+		return nil
+	}
+}
+
+// dialed 记录隧道拨号结束，解析失败时全部时间计入 DNS
+func (t *proxyTrace) dialed(start time.Time, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.connectStart.IsZero() {
+		t.dns = time.Since(start)
+		return
+	}
+	if addr != "" {
+		t.dial = time.Since(t.connectStart)
+		t.addr = addr
+	}
+}
+
+// setStatus 记录直接写入被劫持连接的状态码
+func (t *proxyTrace) setStatus(code int) {
+	if t != nil {
+		t.mu.Lock()
+		t.status = code
+		t.mu.Unlock()
+	}
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// complete 汇总统计信息并调用 OnProxyComplete
+func (p *ReverseProxy) complete(pc *ProxyContext, t *proxyTrace, rec *responseRecorder) {
+	t.mu.Lock()
+	stats := &ProxyStats{
+		Request:       pc.Request,
+		Tunnel:        pc.Tunnel,
+		StatusCode:    rec.status,
+		BytesSent:     rec.bytes + t.sent.Load(),
+		BytesReceived: t.received.Load(),
+		UpstreamAddr:  t.addr,
+		DNS:           t.dns,
+		Dial:          t.dial,
+		TLS:           t.tls,
+		Total:         time.Since(pc.Timings.Start),
+		Err:           pc.failure(),
+	}
+	if t.status != 0 {
+		stats.StatusCode = t.status
+	}
+	t.mu.Unlock()
+	if !pc.Timings.FirstByte.IsZero() {
+		stats.FirstByte = pc.Timings.FirstByte.Sub(pc.Timings.Start)
+	}
+	p.OnProxyComplete(stats)
+}
+
+// proxyError 记录代理错误并调用 OnProxyError
+func (p *ReverseProxy) proxyError(pc *ProxyContext, req *http.Request, err error) {
+	pc.fail(err)
+	if p.OnProxyError != nil {
+		p.OnProxyError(req, err)
+	}
+}
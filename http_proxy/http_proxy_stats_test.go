@@ -0,0 +1,172 @@
+package http_proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseProxy_OnProxyComplete(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "hello world")
+	}))
+	defer backend.Close()
+	tlsBackend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer tlsBackend.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		backend   *httptest.Server
+		body      string
+		status    int
+		sent      int64
+		received  int64
+		tls       bool
+		err       bool
+		upstream  bool
+		logPrefix string
+	}{
+		{name: "plain", backend: backend, body: "ping", status: http.StatusOK, sent: 11, received: 4, upstream: true, logPrefix: "http: proxy completed request successfully"},
+		{name: "tls", backend: tlsBackend, status: http.StatusOK, sent: 6, tls: true, upstream: true, logPrefix: "http: proxy completed request successfully"},
+		{name: "upstream down", backend: closed, status: http.StatusBadGateway, sent: 12, err: true, logPrefix: "http: proxy completed request with error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, _ := url.Parse(tt.backend.URL)
+			var logs bytes.Buffer
+			var stats *ProxyStats
+			proxy := NewReverseProxy(target)
+			// 每个请求使用新的连接，以便记录连接耗时
+			proxy.Transport = &http.Transport{TLSClientConfig: tlsBackend.Client().Transport.(*http.Transport).TLSClientConfig}
+			proxy.ErrorLog = log.New(&logs, "", 0)
+			proxy.OnProxyComplete = func(s *ProxyStats) { stats = s }
+
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if stats == nil {
+				t.Fatal("OnProxyComplete was not called")
+			}
+			if stats.Tunnel || stats.Request == nil {
+				t.Errorf("Tunnel = %v, Request = %v", stats.Tunnel, stats.Request)
+			}
+			if stats.StatusCode != tt.status || stats.StatusCode != w.Code {
+				t.Errorf("StatusCode = %d, want %d", stats.StatusCode, tt.status)
+			}
+			if stats.BytesSent != tt.sent || stats.BytesReceived != tt.received {
+				t.Errorf("bytes sent/received = %d/%d, want %d/%d", stats.BytesSent, stats.BytesReceived, tt.sent, tt.received)
+			}
+			if (stats.Err != nil) != tt.err {
+				t.Errorf("Err = %v, want error %v", stats.Err, tt.err)
+			}
+			if tt.upstream {
+				if stats.UpstreamAddr != target.Host {
+					t.Errorf("UpstreamAddr = %q, want %q", stats.UpstreamAddr, target.Host)
+				}
+				if stats.Dial <= 0 || stats.FirstByte <= 0 || stats.Total < stats.FirstByte {
+					t.Errorf("unexpected timings: dial %v, first byte %v, total %v", stats.Dial, stats.FirstByte, stats.Total)
+				}
+			}
+			if (stats.TLS > 0) != tt.tls {
+				t.Errorf("TLS = %v, want handshake %v", stats.TLS, tt.tls)
+			}
+			if !strings.Contains(logs.String(), tt.logPrefix) {
+				t.Errorf("log does not contain %q:\n%s", tt.logPrefix, logs.String())
+			}
+		})
+	}
+}
+
+func TestReverseProxy_OnProxyComplete_Tunnel(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+
+	done := make(chan *ProxyStats, 1)
+	proxy := &ReverseProxy{OnProxyComplete: func(s *ProxyStats) { done <- s }}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	connect := func(target, payload string) int {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode == http.StatusOK {
+			io.WriteString(conn, payload)
+			buf := make([]byte, len(payload))
+			if _, err := io.ReadFull(br, buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode
+	}
+	wait := func() *ProxyStats {
+		select {
+		case s := <-done:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatal("OnProxyComplete was not called")
+			return nil
+		}
+	}
+
+	if code := connect(echo.Addr().String(), "hello"); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	stats := wait()
+	if !stats.Tunnel || stats.StatusCode != http.StatusOK || stats.Err != nil {
+		t.Errorf("tunnel = %v, status = %d, err = %v", stats.Tunnel, stats.StatusCode, stats.Err)
+	}
+	if stats.BytesSent != 5 || stats.BytesReceived != 5 {
+		t.Errorf("bytes sent/received = %d/%d, want 5/5", stats.BytesSent, stats.BytesReceived)
+	}
+	if stats.UpstreamAddr != echo.Addr().String() || stats.Dial <= 0 || stats.FirstByte <= 0 || stats.TLS != 0 {
+		t.Errorf("upstream %q, dial %v, first byte %v, tls %v", stats.UpstreamAddr, stats.Dial, stats.FirstByte, stats.TLS)
+	}
+
+	if code := connect(closed.Addr().String(), ""); code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", code)
+	}
+	stats = wait()
+	if stats.StatusCode != http.StatusGatewayTimeout || stats.Err == nil || stats.UpstreamAddr != "" {
+		t.Errorf("status = %d, err = %v, upstream = %q", stats.StatusCode, stats.Err, stats.UpstreamAddr)
+	}
+}
@@ -0,0 +1,159 @@
+package http_proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenAuthenticator 校验 Proxy-Authorization: Bearer 令牌
+type TokenAuthenticator interface {
+	// AuthenticateToken 令牌有效时返回用户身份，无效时返回 ErrInvalidCredentials
+	AuthenticateToken(ctx context.Context, token string) (*Identity, error)
+}
+
+// parseBearerToken 解析 "Bearer <token>" 认证头
+func parseBearerToken(auth string) (string, bool) {
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[len(prefix):])
+	return token, token != "" && len(token) <= 8192
+}
+
+// verifyToken 依次尝试各个令牌校验器
+func (a *ProxyAuth) verifyToken(ctx context.Context, token string) (*Identity, error) {
+	for _, t := range a.Tokens {
+		id, err := t.AuthenticateToken(ctx, token)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// APIKeyAuthenticator 从文件读取静态 API 密钥
+//
+// 文件每行一个密钥，格式为 "密钥 用户名 [组1,组2]"，# 开头的行为注释。
+// 密钥可以是明文，也可以是 {SHA256} 加十六进制的 SHA-256 摘要：
+//
+//	k3y-for-ci        ci-bot   automation
+//	{SHA256}2bb80d5...  deploy   automation,prod
+type APIKeyAuthenticator struct {
+	// Path API 密钥文件路径
+	Path string
+
+	// OnReload 在密钥文件重新加载后调用
+	OnReload func()
+
+	mu      sync.RWMutex
+	keys    map[string]*Identity // 键为密钥的 SHA-256 摘要
+	modTime time.Time
+}
+
+// NewAPIKeyAuthenticator 创建 APIKeyAuthenticator 并加载密钥文件
+func NewAPIKeyAuthenticator(path string) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{Path: path}
+	if err := a.Load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load 重新加载密钥文件，加载失败时保留原有密钥
+func (a *APIKeyAuthenticator) Load() error {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return fmt.Errorf("api keys: %w", err)
+	}
+	defer f.Close()
+
+	var modTime time.Time
+	if info, err := f.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+
+	keys := make(map[string]*Identity)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("api keys: %s:%d: invalid entry", a.Path, lineNo)
+		}
+
+		digest, hashed := strings.CutPrefix(fields[0], sha256Prefix)
+		if hashed {
+			digest = strings.ToLower(digest)
+		} else {
+			digest = hashAPIKey(fields[0])
+		}
+		id := &Identity{Username: fields[1]}
+		if len(fields) == 3 {
+			id.Groups = strings.Split(fields[2], ",")
+		}
+		keys[digest] = id
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("api keys: %w", err)
+	}
+
+	a.mu.Lock()
+	a.keys, a.modTime = keys, modTime
+	a.mu.Unlock()
+
+	if a.OnReload != nil {
+		a.OnReload()
+	}
+	return nil
+}
+
+// Len 返回密钥数
+func (a *APIKeyAuthenticator) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.keys)
+}
+
+// AuthenticateToken 实现 TokenAuthenticator
+func (a *APIKeyAuthenticator) AuthenticateToken(ctx context.Context, token string) (*Identity, error) {
+	// 按摘要查找，查找时间与密钥内容无关
+	a.mu.RLock()
+	id, ok := a.keys[hashAPIKey(token)]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return id, nil
+}
+
+// changed 判断密钥文件自上次加载后是否被修改
+func (a *APIKeyAuthenticator) changed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return fileModified(a.Path, a.modTime)
+}
+
+// Run 每隔 interval 检查密钥文件，有修改时重新加载，直到 ctx 结束
+func (a *APIKeyAuthenticator) Run(ctx context.Context, interval time.Duration) {
+	runReload(ctx, interval, a, "api keys", "keys")
+}
@@ -0,0 +1,136 @@
+package http_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBearerToken(t *testing.T) {
+	tests := []struct {
+		auth  string
+		token string
+		ok    bool
+	}{
+		{"Bearer abc.def", "abc.def", true},
+		{"bearer  abc ", "abc", true},
+		{"Bearer ", "", false},
+		{"Basic YWxpY2U6c2VjcmV0", "", false},
+		{"Bearerabc", "", false},
+	}
+	for _, tt := range tests {
+		token, ok := parseBearerToken(tt.auth)
+		if token != tt.token || ok != tt.ok {
+			t.Errorf("parseBearerToken(%q) = %q, %v, want %q, %v", tt.auth, token, ok, tt.token, tt.ok)
+		}
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# api keys\nk3y-for-ci ci-bot automation\n" +
+		"{SHA256}2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b deploy automation,prod\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAPIKeyAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		token  string
+		user   string
+		groups []string
+	}{
+		{"k3y-for-ci", "ci-bot", []string{"automation"}},
+		{"secret", "deploy", []string{"automation", "prod"}},
+		{"{SHA256}2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", "", nil},
+		{"unknown", "", nil},
+	}
+	for _, tt := range tests {
+		id, err := a.AuthenticateToken(ctx, tt.token)
+		if tt.user == "" {
+			if err != ErrInvalidCredentials {
+				t.Errorf("AuthenticateToken(%s) error = %v, want ErrInvalidCredentials", tt.token, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("AuthenticateToken(%s) error = %v", tt.token, err)
+			continue
+		}
+		if id.Username != tt.user || !reflect.DeepEqual(id.Groups, tt.groups) {
+			t.Errorf("AuthenticateToken(%s) = %+v, want %s %v", tt.token, id, tt.user, tt.groups)
+		}
+	}
+
+	// 格式错误时保留原有密钥
+	if err := os.WriteFile(path, []byte("only-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Load(); err == nil {
+		t.Error("expected error for invalid file")
+	}
+	if a.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", a.Len())
+	}
+}
+
+func TestReverseProxy_BearerAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("k3y-for-ci ci-bot automation\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewAPIKeyAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 认证通过的 CONNECT 请求会被端口策略以 403 拒绝，据此区分认证结果
+	proxy := &ReverseProxy{
+		Auth: &ProxyAuth{
+			Realm:         "proxy",
+			Authenticator: &StaticAuthenticator{Username: "alice", Password: "secret"},
+			Tokens:        []TokenAuthenticator{keys},
+		},
+		ConnectPorts: &PortPolicy{Ports: PortList{}},
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no credentials", "", http.StatusProxyAuthRequired},
+		{"valid token", "Bearer k3y-for-ci", http.StatusForbidden},
+		{"invalid token", "Bearer wrong", http.StatusProxyAuthRequired},
+		{"basic still accepted", "Basic " + BasicAuth("alice", "secret"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodConnect, "example.com:25", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Proxy-Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusProxyAuthRequired {
+				challenges := strings.Join(w.Header().Values("Proxy-Authenticate"), "\n")
+				if !strings.Contains(challenges, `Bearer realm="proxy"`) || !strings.Contains(challenges, "Basic") {
+					t.Errorf("unexpected challenges: %s", challenges)
+				}
+			}
+		})
+	}
+}
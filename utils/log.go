@@ -1,40 +1,246 @@
 package utils
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
-// RotateLogFile 轮转日志文件
-func RotateLogFile(logFilePath string) error {
+// RotateLogFile 轮转日志文件，返回轮转后的文件路径
+func RotateLogFile(logFilePath string) (string, error) {
 	// 检查日志文件是否存在
 	if _, err := os.Stat(logFilePath); os.IsNotExist(err) {
-		return nil // 如果日志文件不存在，不需要轮转
+		return "", nil // 如果日志文件不存在，不需要轮转
 	}
 
 	// 生成带时间戳的新文件名
 	timestamp := time.Now().Format("20060102-150405")
 	rotatedFilePath := logFilePath + "." + timestamp
 
+	// 同一秒内多次轮转时追加序号，避免覆盖
+	for i := 1; FileExists(rotatedFilePath) || FileExists(rotatedFilePath+".gz"); i++ {
+		rotatedFilePath = fmt.Sprintf("%s.%s.%d", logFilePath, timestamp, i)
+	}
+
 	// 重命名当前日志文件
 	if err := os.Rename(logFilePath, rotatedFilePath); err != nil {
-		return fmt.Errorf("重命名日志文件失败: %w", err)
+		return "", fmt.Errorf("重命名日志文件失败: %w", err)
 	}
 
 	// 创建新的日志文件
 	newFile, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		return fmt.Errorf("创建新日志文件失败: %w", err)
+		return "", fmt.Errorf("创建新日志文件失败: %w", err)
 	}
 	newFile.Close() // 我们只是创建文件，实际的写入将由日志记录器处理
 
+	return rotatedFilePath, nil
+}
+
+// CompressLogFile 使用gzip压缩日志文件，成功后删除原文件
+func CompressLogFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("打开日志文件失败: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("创建压缩文件失败: %w", err)
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return fmt.Errorf("压缩日志文件失败: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return fmt.Errorf("压缩日志文件失败: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return fmt.Errorf("压缩日志文件失败: %w", err)
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
+
+// RotatingFile 是支持按大小和时间轮转的日志文件，实现了 io.Writer
+type RotatingFile struct {
+	// Path 日志文件路径
+	Path string
+
+	// MaxSize 单个日志文件的最大字节数，超过后轮转，0 表示不按大小轮转
+	MaxSize int64
+
+	// Interval 按时间轮转的间隔（如 24h），0 表示不按时间轮转
+	Interval time.Duration
+
+	// MaxFiles 保留的已轮转日志文件个数，0 表示不删除旧文件
+	MaxFiles int
+
+	// Compress 是否使用gzip压缩已轮转的日志文件
+	Compress bool
+
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+	nextRotate time.Time
+	wg         sync.WaitGroup
+	bgMu       sync.Mutex // 串行执行后台压缩和清理任务
+}
+
+// OpenRotatingFile 打开日志文件，如果目录不存在则创建
+func OpenRotatingFile(path string, maxSize int64, interval time.Duration, maxFiles int, compress bool) (*RotatingFile, error) {
+	r := &RotatingFile{
+		Path:     path,
+		MaxSize:  maxSize,
+		Interval: interval,
+		MaxFiles: maxFiles,
+		Compress: compress,
+	}
+	if err := EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("创建日志目录失败: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open 打开（或重新打开）日志文件，调用方需持有锁
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开日志文件失败: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("读取日志文件信息失败: %w", err)
+	}
+
+	r.file = f
+	r.size = info.Size()
+	if r.Interval > 0 {
+		r.nextRotate = time.Now().Truncate(r.Interval).Add(r.Interval)
+	}
 	return nil
 }
 
+// Write 写入日志，必要时先轮转
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			// 轮转失败时继续写入当前文件，避免丢失日志
+			fmt.Fprintf(os.Stderr, "日志轮转失败: %v\n", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) shouldRotate(n int64) bool {
+	if r.MaxSize > 0 && r.size > 0 && r.size+n > r.MaxSize {
+		return true
+	}
+	return r.Interval > 0 && !time.Now().Before(r.nextRotate)
+}
+
+// rotate 轮转日志文件，调用方需持有锁
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("关闭日志文件失败: %w", err)
+	}
+	r.file = nil
+
+	rotated, err := RotateLogFile(r.Path)
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// 压缩和清理可能较慢，在后台进行
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.bgMu.Lock()
+		defer r.bgMu.Unlock()
+		if r.Compress && rotated != "" {
+			if err := CompressLogFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+		}
+		if r.MaxFiles > 0 {
+			if err := CleanOldLogs(r.Path, r.MaxFiles); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Rotate 立即轮转日志文件
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return r.open()
+	}
+	return r.rotate()
+}
+
+// Reopen 重新打开日志文件，用于配合外部 logrotate（收到 SIGUSR1 时调用）
+func (r *RotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	return r.open()
+}
+
+// Close 关闭日志文件，并等待后台压缩任务完成
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
+
 // CleanOldLogs 清理旧的日志文件，只保留最新的 maxFiles 个
 func CleanOldLogs(logFilePath string, maxFiles int) error {
 	if maxFiles <= 0 {
@@ -1,14 +1,19 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
+// ErrPIDFileLocked 表示PID文件已被其他进程锁定（即已有实例在运行）
+var ErrPIDFileLocked = errors.New("PID文件已被其他进程锁定")
+
 // IsRunning 检查指定PID的进程是否正在运行
 func IsRunning(pid int) bool {
 	process, err := os.FindProcess(pid)
@@ -29,7 +34,7 @@ func ReadPIDFile(pidFile string) (int, error) {
 		return 0, fmt.Errorf("读取PID文件失败: %w", err)
 	}
 
-	pid, err := strconv.Atoi(string(content))
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
 	if err != nil {
 		return 0, fmt.Errorf("解析PID失败: %w", err)
 	}
@@ -43,6 +48,95 @@ func WritePIDFile(pidFile string) error {
 	return ioutil.WriteFile(pidFile, []byte(strconv.Itoa(pid)), 0644)
 }
 
+// LockPIDFile 以独占方式锁定PID文件并写入当前进程的PID
+// 返回的文件需要在进程运行期间保持打开，退出时调用 ReleasePIDFile 释放
+func LockPIDFile(pidFile string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(pidFile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("打开PID文件失败: %w", err)
+		}
+
+		if err := lockFile(f); err != nil {
+			f.Close()
+			if errors.Is(err, ErrPIDFileLocked) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("锁定PID文件失败: %w", err)
+		}
+
+		// 加锁前文件可能已被退出的进程移走，此时锁定的是旧文件，需要重新打开
+		if !sameFile(f, pidFile) {
+			f.Close()
+			continue
+		}
+
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("清空PID文件失败: %w", err)
+		}
+		if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("写入PID文件失败: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("写入PID文件失败: %w", err)
+		}
+		return f, nil
+	}
+}
+
+// ReleasePIDFile 释放 LockPIDFile 锁定的PID文件并删除
+//
+// 文件先被移走再释放锁，之后启动的进程只会创建并锁定新的文件，
+// 删除时不会误删其他进程的PID文件。
+func ReleasePIDFile(f *os.File, pidFile string) error {
+	defer f.Close()
+	if !sameFile(f, pidFile) {
+		return unlockFile(f)
+	}
+	stale := pidFile + "." + strconv.Itoa(os.Getpid())
+	if err := os.Rename(pidFile, stale); err != nil {
+		unlockFile(f)
+		return fmt.Errorf("删除PID文件失败: %w", err)
+	}
+	unlockFile(f)
+	return os.Remove(stale)
+}
+
+// sameFile 判断打开的文件是否仍然是 path 指向的文件
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, pi)
+}
+
+// IsPIDFileLocked 检查PID文件是否被某个进程锁定
+func IsPIDFileLocked(pidFile string) bool {
+	f, err := os.Open(pidFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return isFileLocked(f)
+}
+
+// WaitForExit 等待指定PID的进程退出，超时返回 false
+func WaitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for IsRunning(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
 // IsZombieProcess 检查指定PID的进程是否是僵尸进程
 func IsZombieProcess(pid int) (bool, error) {
 	procPath := fmt.Sprintf("/proc/%d/status", pid)
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// lockFile 在非 Unix 平台上不加锁，PID文件只记录进程ID
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile 在非 Unix 平台上为空操作
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+// isFileLocked 在非 Unix 平台上总是返回 false
+func isFileLocked(f *os.File) bool {
+	return false
+}
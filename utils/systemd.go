@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemd 通知状态
+const (
+	SdNotifyReady     = "READY=1"
+	SdNotifyStopping  = "STOPPING=1"
+	SdNotifyReloading = "RELOADING=1"
+	SdNotifyWatchdog  = "WATCHDOG=1"
+)
+
+// listenFdsStart 是 systemd 传递的第一个文件描述符
+const listenFdsStart = 3
+
+// SdNotify 向 systemd 发送状态通知（sd_notify 协议），不依赖 cgo
+// 如果没有设置 NOTIFY_SOCKET（即不是由 systemd 以 Type=notify 启动），返回 false 和 nil
+func SdNotify(state string) (bool, error) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return false, nil
+	}
+
+	// 以 @ 开头的是抽象命名空间的套接字
+	if socketAddr[0] == '@' {
+		socketAddr = "\x00" + socketAddr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("连接systemd通知套接字失败: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("发送systemd通知失败: %w", err)
+	}
+	return true, nil
+}
+
+// SdNotifyStatus 向 systemd 发送 STATUS= 状态文本
+func SdNotifyStatus(format string, args ...interface{}) (bool, error) {
+	status := fmt.Sprintf(format, args...)
+	// STATUS 只能是单行文本
+	status = strings.ReplaceAll(status, "\n", " ")
+	return SdNotify("STATUS=" + status)
+}
+
+// SdWatchdogEnabled 返回 systemd 配置的看门狗间隔（WatchdogSec）
+// 如果未启用看门狗或看门狗不属于当前进程，返回 0
+func SdWatchdogEnabled() (time.Duration, error) {
+	usecStr := os.Getenv("WATCHDOG_USEC")
+	if usecStr == "" {
+		return 0, nil
+	}
+
+	usec, err := strconv.ParseInt(usecStr, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, fmt.Errorf("无效的WATCHDOG_USEC: %s", usecStr)
+	}
+
+	if pidStr := os.Getenv("WATCHDOG_PID"); pidStr != "" {
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil {
+			return 0, fmt.Errorf("无效的WATCHDOG_PID: %s", pidStr)
+		}
+		if pid != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	return time.Duration(usec) * time.Microsecond, nil
+}
+
+// SdListenFiles 返回 systemd 套接字激活（LISTEN_FDS）传递的文件
+// 如果没有套接字激活或 LISTEN_PID 不是当前进程，返回 nil
+func SdListenFiles() ([]*os.File, error) {
+	pidStr := os.Getenv("LISTEN_PID")
+	fdsStr := os.Getenv("LISTEN_FDS")
+	if pidStr == "" || fdsStr == "" {
+		return nil, nil
+	}
+
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的LISTEN_PID: %s", pidStr)
+	}
+	if pid != os.Getpid() {
+		return nil, nil
+	}
+
+	nfds, err := strconv.Atoi(fdsStr)
+	if err != nil || nfds < 0 {
+		return nil, fmt.Errorf("无效的LISTEN_FDS: %s", fdsStr)
+	}
+
+	// 清理环境变量，避免子进程误用
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	files := make([]*os.File, 0, nfds)
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		closeOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - listenFdsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	return files, nil
+}
+
+// SdListeners 将 systemd 套接字激活传递的文件转换为 net.Listener
+// 非流式套接字会被忽略
+func SdListeners() ([]net.Listener, error) {
+	files, err := SdListenFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, len(files))
+	for _, f := range files {
+		l, err := net.FileListener(f)
+		f.Close() // FileListener 会复制文件描述符
+		if err != nil {
+			continue
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
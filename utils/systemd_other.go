@@ -0,0 +1,6 @@
+//go:build !unix
+
+package utils
+
+// closeOnExec 在非 Unix 平台上为空操作，systemd 套接字激活仅在 Unix 平台上可用
+func closeOnExec(fd int) {}
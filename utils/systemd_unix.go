@@ -0,0 +1,10 @@
+//go:build unix
+
+package utils
+
+import "syscall"
+
+// closeOnExec 设置文件描述符的 close-on-exec 标志，避免泄漏给子进程
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}